@@ -2,13 +2,21 @@
 package anki
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"html"
 	"html/template"
 	"net/url"
+	"regexp"
+	"strings"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/flimzy/log"
+	"github.com/pkg/errors"
 
 	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/diff"
 	"github.com/FlashbackSRS/flashback/model"
 	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
 )
@@ -22,6 +30,7 @@ const (
 func init() {
 	log.Debug("Registering anki models\n")
 	model.RegisterModelController(&Basic{})
+	model.RegisterModelController(&Basic{Auto: true})
 	model.RegisterModelController(&Cloze{})
 	log.Debug("Done registering anki models\n")
 }
@@ -32,6 +41,7 @@ var (
 	buttonsKeyQuestion        = fmt.Sprintf("%d", QuestionFace)
 	buttonsKeyAnswer          = fmt.Sprintf("%d", AnswerFace)
 	buttonsKeyAnswerIncorrect = fmt.Sprintf("%d-wrong", QuestionFace)
+	buttonsKeyAnswerGraded    = fmt.Sprintf("%d-graded", AnswerFace)
 )
 
 var buttonMaps = map[string]studyview.ButtonMap{
@@ -50,11 +60,72 @@ var buttonMaps = map[string]studyview.ButtonMap{
 		studyview.ButtonCenterRight: {Name: "Correct", Enabled: false},
 		studyview.ButtonRight:       {Name: "Easy", Enabled: false},
 	},
+	buttonsKeyAnswerGraded: studyview.ButtonMap{
+		studyview.ButtonRight: {Name: "Continue", Enabled: true},
+	},
 }
 
 type answer struct {
 	Text    string `json:"text"`
 	Correct bool   `json:"correct"`
+	// Typo is true if an incorrect answer differs from the correct one by
+	// no more than a minor typo.
+	Typo bool `json:"typo,omitempty"`
+	// Score is the partial credit earned by the answer, from 0 to 1.
+	Score float64 `json:"score"`
+	// Words is the per-word breakdown of a sentence answer.
+	Words []diff.Word `json:"words,omitempty"`
+}
+
+// typoScore is the minimum similarity of an incorrect typed answer to the
+// correct one for it to count as a minor typo, when answers are graded
+// automatically.
+const typoScore = 0.8
+
+// answerOptions returns the options with which typed answers to card are
+// compared to the correct ones, according to the answer matching mode of its
+// deck's preset.
+func answerOptions(card *model.Card) diff.Options {
+	// The model controller interface provides no context.
+	conf, err := card.DeckConfig(context.TODO())
+	if err != nil {
+		log.Printf("Failed to fetch deck config: %s\n", err)
+		return diff.Options{}
+	}
+	if conf.AnswerMatching == model.AnswerMatchingLenient {
+		return diff.Lenient
+	}
+	return diff.Options{}
+}
+
+// partialCredit is the minimum average score of incorrect typed answers for
+// the card to be graded as incorrect, but remembered, when answers are graded
+// automatically.
+const partialCredit = 0.5
+
+// autoQuality returns the quality of a set of typed answers, when graded
+// automatically: AnswerPerfect if all are exact, AnswerCorrect if they contain
+// no more than minor typos, AnswerIncorrectRemembered if they earn at least
+// partialCredit on average, and AnswerBlackout otherwise.
+func autoQuality(answers map[string]answer) flashback.AnswerQuality {
+	quality := flashback.AnswerPerfect
+	var credit float64
+	for _, a := range answers {
+		credit += a.Score
+		switch {
+		case a.Correct:
+		case a.Typo:
+			if quality == flashback.AnswerPerfect {
+				quality = flashback.AnswerCorrect
+			}
+		default:
+			quality = flashback.AnswerIncorrectRemembered
+		}
+	}
+	if quality == flashback.AnswerIncorrectRemembered && credit/float64(len(answers)) < partialCredit {
+		return flashback.AnswerBlackout
+	}
+	return quality
 }
 
 func quality(button studyview.Button) flashback.AnswerQuality {
@@ -72,8 +143,28 @@ func quality(button studyview.Button) flashback.AnswerQuality {
 }
 
 var defaultFuncMap = map[string]interface{}{
-	"image": image,
-	"audio": audio,
+	"image":    image,
+	"audio":    audio,
+	"text":     text,
+	"hint":     hint,
+	"nonempty": nonempty,
+	"math":     renderMath,
+	"furigana": furigana,
+	"kanji":    kanji,
+	"kana":     kana,
+	"tts":      tts,
+}
+
+// funcMap returns defaultFuncMap, along with the functions which depend on the
+// face.
+func funcMap(face int) map[string]interface{} {
+	funcs := map[string]interface{}{
+		"type": typeAnswer(face),
+	}
+	for k, v := range defaultFuncMap {
+		funcs[k] = v
+	}
+	return funcs
 }
 
 func image(name string) template.HTML {
@@ -83,3 +174,69 @@ func image(name string) template.HTML {
 func audio(name, ctype string) template.HTML {
 	return template.HTML(fmt.Sprintf(`<audio src="%s" type="%s"></audio>`, url.PathEscape(name), ctype))
 }
+
+// tts returns a placeholder for speaking a field, as Anki's tts filter, which
+// speaks the field's text rather than showing it. lang is the language, such
+// as en_US. It may be followed by Anki's options, such as "speed=1.2", and is
+// followed by the field.
+func tts(lang string, args ...interface{}) (template.HTML, error) {
+	if len(args) == 0 {
+		return "", errors.New("tts: missing field")
+	}
+	field, ok := args[len(args)-1].(template.HTML)
+	if !ok {
+		return "", errors.Errorf("tts: unexpected field type %T", args[len(args)-1])
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<span class="tts" lang="%s"`, html.EscapeString(strings.Replace(lang, "_", "-", -1)))
+	for _, arg := range args[:len(args)-1] {
+		option, _ := arg.(string)
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 || (parts[0] != "voices" && parts[0] != "speed") {
+			return "", errors.Errorf("tts: unsupported option '%v'", arg)
+		}
+		fmt.Fprintf(&buf, ` data-%s="%s"`, parts[0], html.EscapeString(parts[1]))
+	}
+	fmt.Fprintf(&buf, " hidden>%s</span>", text(field))
+	return template.HTML(buf.String()), nil
+}
+
+// typeAnswer returns a text input for typing the answer to a field, as Anki's
+// {{type:Field}}. On the answer face, the input is replaced by the comparison
+// of the typed answer with the expected one.
+func typeAnswer(face int) func(string) template.HTML {
+	return func(field string) template.HTML {
+		disabled := ""
+		if face != QuestionFace {
+			disabled = " disabled"
+		}
+		return template.HTML(fmt.Sprintf(`<input type="text" name="%s"%s>`, html.EscapeString(typePrefix+field), disabled))
+	}
+}
+
+// text returns the text of a field, without HTML, as Anki's text filter.
+func text(field template.HTML) template.HTML {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(field)))
+	if err != nil {
+		// Reading from a string never fails
+		return field
+	}
+	return template.HTML(html.EscapeString(doc.Text()))
+}
+
+// hint returns a field which is hidden until the user chooses to show it, as
+// Anki's hint filter. Empty fields are omitted.
+func hint(name string, field template.HTML) template.HTML {
+	if !nonempty(field) {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<details class="hint"><summary>%s</summary>%s</details>`, html.EscapeString(name), field))
+}
+
+var emptyFieldRE = regexp.MustCompile(`(?i)^(?:\s|&nbsp;|</?(?:br|div)\s*/?>)*$`)
+
+// nonempty returns true if a field has content, other than whitespace and
+// empty lines, as Anki's conditional sections require.
+func nonempty(field template.HTML) bool {
+	return !emptyFieldRE.MatchString(string(field))
+}
@@ -3,6 +3,13 @@ package anki
 import (
 	"html/template"
 	"testing"
+
+	"github.com/flimzy/testy"
+
+	"github.com/FlashbackSRS/flashback"
+	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/diff"
+	"github.com/FlashbackSRS/flashback/model"
 )
 
 func TestImage(t *testing.T) {
@@ -61,3 +68,96 @@ func TestAudio(t *testing.T) {
 		})
 	}
 }
+
+func TestAutoQuality(t *testing.T) {
+	tests := []struct {
+		name     string
+		answers  map[string]answer
+		expected flashback.AnswerQuality
+	}{
+		{
+			name:     "exact",
+			answers:  map[string]answer{"Front": {Correct: true, Score: 1}, "Back": {Correct: true, Score: 1}},
+			expected: flashback.AnswerPerfect,
+		},
+		{
+			name:     "typo",
+			answers:  map[string]answer{"Front": {Correct: true, Score: 1}, "Back": {Typo: true, Score: 0.9}},
+			expected: flashback.AnswerCorrect,
+		},
+		{
+			name:     "partial credit",
+			answers:  map[string]answer{"Front": {Typo: true, Score: 0.8}, "Back": {Score: 0.5}},
+			expected: flashback.AnswerIncorrectRemembered,
+		},
+		{
+			name:     "wrong",
+			answers:  map[string]answer{"Front": {Typo: true, Score: 0.8}, "Back": {Score: 0}},
+			expected: flashback.AnswerBlackout,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := autoQuality(test.answers); result != test.expected {
+				t.Errorf("Expected %d, got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestAnswerOptions(t *testing.T) {
+	// The default preset requires an exact match
+	opts := answerOptions(&model.Card{Card: &fb.Card{}})
+	if diff.Compare("Hola; Buenos días", "hola", opts).Equal {
+		t.Error("Expected an exact comparison by default")
+	}
+}
+
+func TestText(t *testing.T) {
+	if result := text("<b>uno</b> &amp; <i>dos</i><br>"); result != "uno &amp; dos" {
+		t.Errorf("Unexpected result: %s", result)
+	}
+}
+
+func TestTTS(t *testing.T) {
+	tests := []struct {
+		name     string
+		lang     string
+		args     []interface{}
+		expected template.HTML
+		err      string
+	}{
+		{
+			name:     "field",
+			lang:     "es_ES",
+			args:     []interface{}{template.HTML("<b>hola</b>")},
+			expected: `<span class="tts" lang="es-ES" hidden>hola</span>`,
+		},
+		{
+			name:     "options",
+			lang:     "ja_JP",
+			args:     []interface{}{"voices=Apple_Otoya,Microsoft_Haruka", "speed=0.8", template.HTML("犬")},
+			expected: `<span class="tts" lang="ja-JP" data-voices="Apple_Otoya,Microsoft_Haruka" data-speed="0.8" hidden>犬</span>`,
+		},
+		{
+			name: "no field",
+			lang: "en_US",
+			err:  "tts: missing field",
+		},
+		{
+			name: "unsupported option",
+			lang: "en_US",
+			args: []interface{}{"pitch=2", template.HTML("dog")},
+			err:  "tts: unsupported option 'pitch=2'",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := tts(test.lang, test.args...)
+			testy.Error(t, test.err, err)
+			if result != test.expected {
+				t.Errorf("Unexpected result: %s", result)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package anki
 
 import (
+	"context"
 	"html/template"
 	"strconv"
 	"time"
@@ -14,16 +15,47 @@ import (
 )
 
 // Basic is the controller for the Anki Basic model
-type Basic struct{}
+type Basic struct {
+	// Auto, if true, grades typed answers automatically, and schedules the
+	// card without a button press. Auto grading may also be enabled per deck,
+	// with the deck's review mode.
+	Auto bool
+}
 
 var _ model.ModelController = &Basic{}
 var _ model.FuncMapper = &Basic{}
 
-// Type returns the string "anki-basic", to identify this model handler's type.
+// Type returns the string "anki-basic", or "anki-basic-auto" if Auto is true,
+// to identify this model handler's type.
 func (m *Basic) Type() string {
+	if m.auto() {
+		return "anki-basic-auto"
+	}
 	return "anki-basic"
 }
 
+// auto returns true if the model grades typed answers automatically. It is
+// safe to call on a nil Basic, as embedded by Cloze.
+func (m *Basic) auto() bool {
+	return m != nil && m.Auto
+}
+
+// reviewMode returns the mode in which the card's typed answers are reviewed:
+// model.ReviewModeAuto if the model grades them automatically, or else the
+// review mode of the card's deck.
+func (m *Basic) reviewMode(card *model.Card) string {
+	if m.auto() {
+		return model.ReviewModeAuto
+	}
+	// The model controller interface provides no context.
+	conf, err := card.DeckConfig(context.TODO())
+	if err != nil {
+		log.Printf("Failed to fetch deck config: %s\n", err)
+		return model.ReviewModeSelf
+	}
+	return conf.ReviewMode
+}
+
 // IframeScript returns JavaScript to run inside the iframe.
 func (m *Basic) IframeScript() []byte {
 	data, err := Asset("script.js")
@@ -35,6 +67,9 @@ func (m *Basic) IframeScript() []byte {
 
 func buttonsKey(card *model.Card, face int) string {
 	if face == AnswerFace && card.Context != nil {
+		if graded(card) {
+			return buttonsKeyAnswerGraded
+		}
 		answers, _ := card.Context.(map[string]interface{})[contextKeyTypedAnswers].(map[string]answer)
 		for _, answer := range answers {
 			if !answer.Correct {
@@ -45,6 +80,14 @@ func buttonsKey(card *model.Card, face int) string {
 	return strconv.Itoa(face)
 }
 
+// graded returns true if the card's typed answers have been graded, and the
+// card scheduled, automatically.
+func graded(card *model.Card) bool {
+	ctx, _ := card.Context.(map[string]interface{})
+	graded, _ := ctx[contextKeyGraded].(bool)
+	return graded
+}
+
 // Buttons returns the initial button state
 func (m *Basic) Buttons(card *model.Card, face int) (studyview.ButtonMap, error) {
 	key := buttonsKey(card, face)
@@ -57,10 +100,19 @@ func (m *Basic) Buttons(card *model.Card, face int) (studyview.ButtonMap, error)
 
 const (
 	contextKeyTypedAnswers = "typedAnswers"
+	contextKeyGraded       = "graded"
 )
 
 // Action responds to a card action, such as a button press
 func (m *Basic) Action(card *model.Card, face *int, startTime time.Time, payload interface{}) (bool, error) {
+	return m.action(card, face, startTime, payload, func(text string) string {
+		return text
+	})
+}
+
+// action implements Action. expected returns the answer expected when a field
+// with the given text is typed.
+func (m *Basic) action(card *model.Card, face *int, startTime time.Time, payload interface{}, expected func(text string) string) (bool, error) {
 	query := convertQuery(payload)
 	log.Debugf("Submit recieved for face %d: %v\n", *face, query)
 	button := studyview.Button(query.Submit)
@@ -82,26 +134,54 @@ func (m *Basic) Action(card *model.Card, face *int, startTime time.Time, payload
 		typedAnswers := query.TypedAnswers
 		if len(typedAnswers) > 0 {
 			results := make(map[string]answer)
+			opts := answerOptions(card)
 			for _, fieldName := range card.Fields() {
 				if typedAnswer, ok := typedAnswers[fieldName]; ok {
 					fv := card.FieldValue(fieldName)
 					if fv == nil {
 						panic("No field value for field")
 					}
-					correct, d := diff.Diff(fv.Text, typedAnswer)
+					result := diff.Compare(expected(fv.Text), typedAnswer, opts)
 					results[fieldName] = answer{
-						Text:    d,
-						Correct: correct,
+						Text:    result.HTML,
+						Correct: result.Equal,
+						Typo:    !result.Equal && result.Score >= typoScore,
+						Score:   result.Score,
+						Words:   result.Words,
 					}
 				}
 			}
 			card.Context = map[string]interface{}{
 				contextKeyTypedAnswers: results,
 			}
+			if len(results) == 0 {
+				return false, nil
+			}
+			switch m.reviewMode(card) {
+			case model.ReviewModeAuto:
+				q := autoQuality(results)
+				log.Debugf("Auto-graded answer quality: %d\n", q)
+				if err := model.Schedule(card, time.Now().Sub(startTime), q); err != nil {
+					return false, err
+				}
+				card.Context.(map[string]interface{})[contextKeyGraded] = true
+			case model.ReviewModeDelayed:
+				// The model controller interface provides no context.
+				if _, err := card.SubmitForReview(context.TODO(), typedAnswers, time.Now().Sub(startTime), nil); err != nil {
+					return false, err
+				}
+				card.Context = nil
+				return true, nil
+			}
 			return false, nil
 		}
 		return false, nil
 	case AnswerFace:
+		if graded(card) {
+			// Already scheduled when the answer was submitted
+			card.Context = nil
+			return true, nil
+		}
 		log.Debugf("Old schedule: Due %s, Interval: %s, Ease: %f, ReviewCount: %d\n", card.Due, card.Interval, card.EaseFactor, card.ReviewCount)
 		if err := model.Schedule(card, time.Now().Sub(startTime), quality(button)); err != nil {
 			return false, err
@@ -115,6 +195,6 @@ func (m *Basic) Action(card *model.Card, face *int, startTime time.Time, payload
 }
 
 // FuncMap returns a function map for Basic templates.
-func (m *Basic) FuncMap(_ *model.Card, _ int) template.FuncMap {
-	return defaultFuncMap
+func (m *Basic) FuncMap(_ *model.Card, face int) template.FuncMap {
+	return funcMap(face)
 }
@@ -2,6 +2,7 @@ package anki
 
 import (
 	"testing"
+	"time"
 
 	"github.com/flimzy/diff"
 	"github.com/flimzy/testy"
@@ -11,6 +12,15 @@ import (
 	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
 )
 
+func TestBasicType(t *testing.T) {
+	if typ := (&Basic{}).Type(); typ != "anki-basic" {
+		t.Errorf("Unexpected type: %s", typ)
+	}
+	if typ := (&Basic{Auto: true}).Type(); typ != "anki-basic-auto" {
+		t.Errorf("Unexpected type: %s", typ)
+	}
+}
+
 func TestBasicButtons(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -106,6 +116,21 @@ func TestButtonsKey(t *testing.T) {
 			face:     AnswerFace,
 			expected: buttonsKeyAnswerIncorrect,
 		},
+		{
+			name: "auto graded",
+			card: &model.Card{
+				Card: &fb.Card{
+					Context: map[string]interface{}{
+						contextKeyTypedAnswers: map[string]answer{
+							"testField": {Text: "foo", Correct: false},
+						},
+						contextKeyGraded: true,
+					},
+				},
+			},
+			face:     AnswerFace,
+			expected: buttonsKeyAnswerGraded,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -116,3 +141,22 @@ func TestButtonsKey(t *testing.T) {
 		})
 	}
 }
+
+func TestBasicActionGraded(t *testing.T) {
+	card := &model.Card{
+		Card: &fb.Card{
+			Context: map[string]interface{}{contextKeyGraded: true},
+		},
+	}
+	face := AnswerFace
+	done, err := (&Basic{Auto: true}).Action(card, &face, time.Now(), &basicQuery{Submit: "button-r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done {
+		t.Error("Expected the review to be done")
+	}
+	if card.Context != nil {
+		t.Errorf("Context not cleared: %v", card.Context)
+	}
+}
@@ -1,28 +1,74 @@
 package anki
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/FlashbackSRS/flashback/model"
 )
 
 // Cloze is the controller for the Anki Cloze model.
+//
+// Cloze deletions follow Anki's syntax. {{c1::text}} hides text on the first
+// card, and {{c1::text::hint}} shows the hint in its place. All deletions with
+// the same number are hidden on the same card, a deletion may belong to
+// several cards, as {{c1,2::text}}, and deletions may be nested. A typed
+// answer to a field, as rendered by the typeCloze template function, is
+// compared to the text hidden on the card.
 type Cloze struct {
 	*Basic
 }
 
 var _ model.ModelController = &Cloze{}
 var _ model.FuncMapper = &Cloze{}
+var _ model.Clozer = &Cloze{}
 
 // Type returns the string "anki-cloze", to identify this model handler's type.
 func (m *Cloze) Type() string {
 	return "anki-cloze"
 }
 
+// Action responds to a card action, such as a button press
+func (m *Cloze) Action(card *model.Card, face *int, startTime time.Time, payload interface{}) (bool, error) {
+	return m.Basic.action(card, face, startTime, payload, func(text string) string {
+		return clozeAnswer(card.TemplateID(), text)
+	})
+}
+
+// ClozeNumbers returns the distinct numbers of the cloze deletions in the
+// fields, in increasing order. A card is generated for each.
+func (m *Cloze) ClozeNumbers(fields []string) []int {
+	seen := make(map[int]bool)
+	for _, field := range fields {
+		clozeOrds(parseCloze(field), seen)
+	}
+	ords := make([]int, 0, len(seen))
+	for ord := range seen {
+		ords = append(ords, ord)
+	}
+	sort.Ints(ords)
+	return ords
+}
+
+// clozeOrds adds the card numbers of the deletions among nodes to ords.
+func clozeOrds(nodes []*clozeNode, ords map[int]bool) {
+	for _, n := range nodes {
+		if !n.deletion {
+			continue
+		}
+		for _, ord := range n.ords {
+			ords[ord] = true
+		}
+		clozeOrds(n.children, ords)
+	}
+}
+
 // FuncMap returns a function map for Cloze templates.
 func (m *Cloze) FuncMap(card *model.Card, face int) template.FuncMap {
 	var templateID uint32
@@ -30,43 +76,198 @@ func (m *Cloze) FuncMap(card *model.Card, face int) template.FuncMap {
 		// Need to do this check, because card may be nil during template parsing
 		templateID = card.TemplateID()
 	}
-	funcMap := map[string]interface{}{
-		"cloze": cloze(templateID, face),
-	}
-	for k, v := range defaultFuncMap {
-		funcMap[k] = v
-	}
-	return funcMap
+	funcs := funcMap(face)
+	funcs["cloze"] = cloze(templateID, face)
+	// clozeQuestion renders deletions as on the question face, for the
+	// question repeated on the answer face.
+	funcs["clozeQuestion"] = cloze(templateID, QuestionFace)
+	funcs["clozeOnly"] = clozeOnly(templateID)
+	// As Anki's {{type:cloze:Field}}; typed answers are compared to the text
+	// hidden on the card.
+	funcs["typeCloze"] = typeAnswer(face)
+	return funcs
 }
 
 const span = `<span class="cloze">%s</span>`
 
-var placeholder = fmt.Sprintf(span, "[...]")
+// clozeOpenRE matches the opening of a cloze deletion, such as {{c1:: or
+// {{c1,2::.
+var clozeOpenRE = regexp.MustCompile(`^{{c(\d+(?:,\d+)*)::`)
+
+// clozeNode is a node of a parsed cloze field: either plain text, or a cloze
+// deletion.
+type clozeNode struct {
+	text string
+	// The following are set for deletions only.
+	deletion bool
+	ords     []int
+	children []*clozeNode
+	hint     string
+	// open is the opening marker, such as {{c1::, kept in case the deletion
+	// turns out not to be closed.
+	open string
+}
 
-var clozeRE = regexp.MustCompile(`{{c(\d+)::(.*?)}}`)
+// has returns true if the deletion belongs to card ord.
+func (n *clozeNode) has(ord int) bool {
+	for _, o := range n.ords {
+		if o == ord {
+			return true
+		}
+	}
+	return false
+}
 
-func cloze(templateID uint32, face int) func(template.HTML) template.HTML {
-	return func(text template.HTML) template.HTML {
-		str := string(text)
-		var found bool
-		for _, match := range clozeRE.FindAllStringSubmatch(str, -1) {
-			fieldNo, _ := strconv.Atoi(match[1])
-			// Subtract one, because templateID is 0-indexed, but {{cN:...}} fields are 1-indexed
-			index := fieldNo - 1
-			if index == int(templateID) {
-				found = true
-				if face == 0 {
-					str = strings.Replace(str, match[0], placeholder, -1)
-				} else {
-					str = strings.Replace(str, match[0], fmt.Sprintf(span, match[2]), -1)
+// parseCloze parses field text into text and cloze deletions. Markers which
+// are not part of a complete deletion are kept as text.
+func parseCloze(text string) []*clozeNode {
+	root := &clozeNode{}
+	stack := []*clozeNode{root}
+	appendText := func(s string) {
+		parent := stack[len(stack)-1]
+		if n := len(parent.children); n > 0 && !parent.children[n-1].deletion {
+			parent.children[n-1].text += s
+			return
+		}
+		parent.children = append(parent.children, &clozeNode{text: s})
+	}
+	for len(text) > 0 {
+		if m := clozeOpenRE.FindStringSubmatch(text); m != nil {
+			node := &clozeNode{deletion: true, open: m[0]}
+			for _, ord := range strings.Split(m[1], ",") {
+				n, _ := strconv.Atoi(ord)
+				node.ords = append(node.ords, n)
+			}
+			stack = append(stack, node)
+			text = text[len(m[0]):]
+			continue
+		}
+		if strings.HasPrefix(text, "}}") && len(stack) > 1 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			// The hint follows the last '::' of the deletion's own text.
+			if n := len(node.children); n > 0 && !node.children[n-1].deletion {
+				last := node.children[n-1]
+				if i := strings.Index(last.text, "::"); i >= 0 {
+					node.hint = last.text[i+2:]
+					last.text = last.text[:i]
 				}
-			} else {
-				str = strings.Replace(str, match[0], match[2], -1)
 			}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+			text = text[2:]
+			continue
+		}
+		next := len(text)
+		if i := strings.Index(text[1:], "{{"); i >= 0 {
+			next = i + 1
 		}
-		if found {
-			return template.HTML(str)
+		if i := strings.Index(text[1:], "}}"); i >= 0 && i+1 < next {
+			next = i + 1
 		}
-		return ""
+		appendText(text[:next])
+		text = text[next:]
+	}
+	// Unclosed deletions are kept as text
+	for len(stack) > 1 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		appendText(node.open)
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, node.children...)
+	}
+	return root.children
+}
+
+// hasCloze returns true if any of the nodes includes a deletion for card ord.
+func hasCloze(nodes []*clozeNode, ord int) bool {
+	for _, n := range nodes {
+		if n.deletion && (n.has(ord) || hasCloze(n.children, ord)) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderCloze renders the nodes for card ord. On the question face, the card's
+// deletions are replaced by their hints, or [...], and on the answer face they
+// are shown highlighted. Other deletions are shown as plain text.
+func renderCloze(buf *bytes.Buffer, nodes []*clozeNode, ord, face int) {
+	for _, n := range nodes {
+		switch {
+		case !n.deletion:
+			buf.WriteString(n.text)
+		case !n.has(ord):
+			renderCloze(buf, n.children, ord, face)
+		case face == QuestionFace:
+			hint := "..."
+			if n.hint != "" {
+				hint = n.hint
+			}
+			fmt.Fprintf(buf, span, "["+hint+"]")
+		default:
+			var inner bytes.Buffer
+			renderCloze(&inner, n.children, ord, face)
+			fmt.Fprintf(buf, span, inner.String())
+		}
+	}
+}
+
+// clozeTexts returns the text of each of card ord's deletions, in order.
+func clozeTexts(nodes []*clozeNode, ord int) []string {
+	var texts []string
+	for _, n := range nodes {
+		if !n.deletion {
+			continue
+		}
+		if n.has(ord) {
+			var buf bytes.Buffer
+			// Nested deletions are revealed as text
+			renderCloze(&buf, n.children, -1, AnswerFace)
+			texts = append(texts, buf.String())
+			continue
+		}
+		texts = append(texts, clozeTexts(n.children, ord)...)
+	}
+	return texts
+}
+
+// clozeAnswer returns the answer expected when the text hidden on card ord of
+// a field is typed: the text of its deletions, separated by commas, or just
+// one if they are identical, as Anki does. Fields without deletions for the
+// card are returned as is.
+func clozeAnswer(templateID uint32, text string) string {
+	texts := clozeTexts(parseCloze(text), int(templateID)+1)
+	if len(texts) == 0 {
+		return text
+	}
+	for _, t := range texts[1:] {
+		if t != texts[0] {
+			return strings.Join(texts, ", ")
+		}
+	}
+	return texts[0]
+}
+
+func cloze(templateID uint32, face int) func(template.HTML) template.HTML {
+	// Add one, because templateID is 0-indexed, but {{cN:...}} fields are 1-indexed
+	ord := int(templateID) + 1
+	return func(text template.HTML) template.HTML {
+		nodes := parseCloze(string(text))
+		if !hasCloze(nodes, ord) {
+			return ""
+		}
+		var buf bytes.Buffer
+		renderCloze(&buf, nodes, ord, face)
+		return template.HTML(buf.String())
+	}
+}
+
+// clozeOnly returns the text of the card's deletions, as Anki's cloze-only
+// filter does, such as for text to speech.
+func clozeOnly(templateID uint32) func(template.HTML) template.HTML {
+	return func(text template.HTML) template.HTML {
+		texts := clozeTexts(parseCloze(string(text)), int(templateID)+1)
+		return template.HTML(strings.Join(texts, ", "))
 	}
 }
@@ -3,6 +3,8 @@ package anki
 import (
 	"html/template"
 	"testing"
+
+	"github.com/flimzy/diff"
 )
 
 type clozeTest struct {
@@ -50,6 +52,72 @@ func TestCloze(t *testing.T) {
 			Text:       "Quien mucho {{c1::abarca}} poco {{c2::aprieta}}.",
 			Expected:   "",
 		},
+		clozeTest{
+			TemplateID: 0,
+			Face:       0,
+			Text:       "{{c1::Canberra::city}} was founded in {{c2::1913::year}}.",
+			Expected:   `<span class="cloze">[city]</span> was founded in 1913.`,
+		},
+		clozeTest{
+			TemplateID: 0,
+			Face:       1,
+			Text:       "{{c1::Canberra::city}} was founded in {{c2::1913::year}}.",
+			Expected:   `<span class="cloze">Canberra</span> was founded in 1913.`,
+		},
+		clozeTest{
+			TemplateID: 0,
+			Face:       0,
+			Text:       "{{c1::Ottawa}} is the capital of {{c1::Canada}}.",
+			Expected:   `<span class="cloze">[...]</span> is the capital of <span class="cloze">[...]</span>.`,
+		},
+		clozeTest{
+			TemplateID: 0,
+			Face:       0,
+			Text:       "{{c1::Canberra was {{c2::founded}}}} in 1913",
+			Expected:   `<span class="cloze">[...]</span> in 1913`,
+		},
+		clozeTest{
+			TemplateID: 0,
+			Face:       1,
+			Text:       "{{c1::Canberra was {{c2::founded}}}} in 1913",
+			Expected:   `<span class="cloze">Canberra was founded</span> in 1913`,
+		},
+		clozeTest{
+			TemplateID: 1,
+			Face:       0,
+			Text:       "{{c1::Canberra was {{c2::founded}}}} in 1913",
+			Expected:   `Canberra was <span class="cloze">[...]</span> in 1913`,
+		},
+		clozeTest{
+			TemplateID: 1,
+			Face:       1,
+			Text:       "{{c1::Canberra was {{c2::founded::verb}}::sentence}} in 1913",
+			Expected:   `Canberra was <span class="cloze">founded</span> in 1913`,
+		},
+		clozeTest{
+			TemplateID: 0,
+			Face:       0,
+			Text:       "{{c1::Canberra was {{c2::founded::verb}}::sentence}} in 1913",
+			Expected:   `<span class="cloze">[sentence]</span> in 1913`,
+		},
+		clozeTest{
+			TemplateID: 1,
+			Face:       0,
+			Text:       "{{c1,2::Canberra}} was founded in {{c3::1913}}.",
+			Expected:   `<span class="cloze">[...]</span> was founded in 1913.`,
+		},
+		clozeTest{
+			TemplateID: 0,
+			Face:       0,
+			Text:       "Unclosed {{c1::deletion",
+			Expected:   "",
+		},
+		clozeTest{
+			TemplateID: 0,
+			Face:       1,
+			Text:       "{{c2::Unclosed}} }} {{c1::foo {{bar}}",
+			Expected:   `Unclosed }} <span class="cloze">foo {{bar</span>`,
+		},
 	}
 	for _, test := range tests {
 		fn := cloze(test.TemplateID, test.Face)
@@ -59,3 +127,71 @@ func TestCloze(t *testing.T) {
 		}
 	}
 }
+
+func TestClozeAnswer(t *testing.T) {
+	tests := []struct {
+		name       string
+		templateID uint32
+		text       string
+		expected   string
+	}{
+		{
+			name:     "no deletion",
+			text:     "Canberra",
+			expected: "Canberra",
+		},
+		{
+			name:     "hint",
+			text:     "{{c1::Canberra::city}} was founded in {{c2::1913}}.",
+			expected: "Canberra",
+		},
+		{
+			name:     "several deletions",
+			text:     "{{c1::Ottawa}} is the capital of {{c1::Canada}}.",
+			expected: "Ottawa, Canada",
+		},
+		{
+			name:     "identical deletions",
+			text:     "{{c1::la}} casa y {{c1::la}} mesa",
+			expected: "la",
+		},
+		{
+			name:       "nested",
+			templateID: 1,
+			text:       "{{c1::Canberra was {{c2::founded}}}} in 1913",
+			expected:   "founded",
+		},
+		{
+			name:     "nested text",
+			text:     "{{c1::Canberra was {{c2::founded}}}} in 1913",
+			expected: "Canberra was founded",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := clozeAnswer(test.templateID, test.text)
+			if result != test.expected {
+				t.Errorf("Unexpected result: %s", result)
+			}
+		})
+	}
+}
+
+func TestClozeOnly(t *testing.T) {
+	result := clozeOnly(0)("{{c1::Ottawa}} is the capital of {{c1::Canada::country}}.")
+	if expected := template.HTML("Ottawa, Canada"); result != expected {
+		t.Errorf("Unexpected result: %s", result)
+	}
+}
+
+func TestClozeNumbers(t *testing.T) {
+	result := (&Cloze{}).ClozeNumbers([]string{
+		"{{c3::Ottawa}} is the capital of {{c2::{{c5::Canada}}}}.",
+		"{{c2,4::shared}} {{c9::unclosed",
+		"",
+	})
+	expected := []int{2, 3, 4, 5}
+	if d := diff.Interface(expected, result); d != nil {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,45 @@
+package anki
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// furiganaRE matches Anki's furigana syntax, 漢字[かんじ], where the base text
+// extends back to the preceding space, which is dropped, or tag.
+var furiganaRE = regexp.MustCompile(` ?([^ >]+?)\[(.+?)\]`)
+
+// replaceFurigana replaces each base text and reading in a field with the
+// result of fn. Sound references, such as [sound:foo.mp3], are left alone.
+func replaceFurigana(field template.HTML, fn func(base, reading string) string) template.HTML {
+	text := strings.Replace(string(field), "&nbsp;", " ", -1)
+	return template.HTML(furiganaRE.ReplaceAllStringFunc(text, func(match string) string {
+		m := furiganaRE.FindStringSubmatch(match)
+		if strings.HasPrefix(m[2], "sound:") {
+			return match
+		}
+		return fn(m[1], m[2])
+	}))
+}
+
+// furigana shows readings above their base text, as Anki's furigana filter.
+func furigana(field template.HTML) template.HTML {
+	return replaceFurigana(field, func(base, reading string) string {
+		return "<ruby>" + base + "<rt>" + reading + "</rt></ruby>"
+	})
+}
+
+// kanji shows only the base text, as Anki's kanji filter.
+func kanji(field template.HTML) template.HTML {
+	return replaceFurigana(field, func(base, _ string) string {
+		return base
+	})
+}
+
+// kana shows only the readings, as Anki's kana filter.
+func kana(field template.HTML) template.HTML {
+	return replaceFurigana(field, func(_, reading string) string {
+		return reading
+	})
+}
@@ -0,0 +1,58 @@
+package anki
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestFurigana(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    template.HTML
+		furigana template.HTML
+		kanji    template.HTML
+		kana     template.HTML
+	}{
+		{
+			name:     "no readings",
+			field:    "日本語",
+			furigana: "日本語",
+			kanji:    "日本語",
+			kana:     "日本語",
+		},
+		{
+			name:     "readings",
+			field:    "日本[にほん] 語[ご]を 話[はな]す",
+			furigana: "<ruby>日本<rt>にほん</rt></ruby><ruby>語<rt>ご</rt></ruby>を<ruby>話<rt>はな</rt></ruby>す",
+			kanji:    "日本語を話す",
+			kana:     "にほんごをはなす",
+		},
+		{
+			name:     "markup",
+			field:    "<b>漢字[かんじ]</b>&nbsp;字[じ]",
+			furigana: "<b><ruby>漢字<rt>かんじ</rt></ruby></b><ruby>字<rt>じ</rt></ruby>",
+			kanji:    "<b>漢字</b>字",
+			kana:     "<b>かんじ</b>じ",
+		},
+		{
+			name:     "sound",
+			field:    "犬[いぬ][sound:inu.mp3]",
+			furigana: "<ruby>犬<rt>いぬ</rt></ruby>[sound:inu.mp3]",
+			kanji:    "犬[sound:inu.mp3]",
+			kana:     "いぬ[sound:inu.mp3]",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := furigana(test.field); result != test.furigana {
+				t.Errorf("Unexpected furigana: %s", result)
+			}
+			if result := kanji(test.field); result != test.kanji {
+				t.Errorf("Unexpected kanji: %s", result)
+			}
+			if result := kana(test.field); result != test.kana {
+				t.Errorf("Unexpected kana: %s", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package anki
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/FlashbackSRS/flashback/mathml"
+)
+
+// mathRE matches the math in Anki fields: [latex]...[/latex], [$]...[/$] and
+// [$$]...[/$$] for LaTeX, and \(...\) and \[...\] for MathJax.
+var mathRE = regexp.MustCompile(`(?s)\[latex\](.*?)\[/latex\]|\[\$\](.*?)\[/\$\]|\[\$\$\](.*?)\[/\$\$\]|\\\((.*?)\\\)|\\\[(.*?)\\\]`)
+
+// latexMathRE matches math within a [latex] block.
+var latexMathRE = regexp.MustCompile(`(?s)\$\$(.*?)\$\$|\$(.*?)\$|\\\((.*?)\\\)|\\\[(.*?)\\\]`)
+
+var (
+	brRE  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	tagRE = regexp.MustCompile(`<[^>]*>`)
+)
+
+// renderMath converts the math in a field to MathML, so that it is displayed
+// without MathJax, or rendering LaTeX to images, as Anki does.
+func renderMath(field template.HTML) template.HTML {
+	return template.HTML(mathRE.ReplaceAllStringFunc(string(field), func(match string) string {
+		m := mathRE.FindStringSubmatch(match)
+		switch {
+		case strings.HasPrefix(match, "[latex]"):
+			return latex(texSource(m[1]))
+		case strings.HasPrefix(match, "[$]"):
+			return mathml.Convert(texSource(m[2]), false)
+		case strings.HasPrefix(match, "[$$]"):
+			return mathml.Convert(texSource(m[3]), true)
+		case strings.HasPrefix(match, `\(`):
+			return mathml.Convert(texSource(m[4]), false)
+		}
+		return mathml.Convert(texSource(m[5]), true)
+	}))
+}
+
+// latex converts a [latex] block, which is in LaTeX's text mode, and so
+// contains text, along with math between delimiters. A block without any
+// delimiters is taken to be math, as it would otherwise rarely be valid.
+func latex(src string) string {
+	matches := latexMathRE.FindAllStringSubmatchIndex(src, -1)
+	if len(matches) == 0 {
+		return mathml.Convert(src, true)
+	}
+	var buf bytes.Buffer
+	var last int
+	for _, m := range matches {
+		buf.WriteString(html.EscapeString(src[last:m[0]]))
+		switch {
+		case m[2] >= 0:
+			buf.WriteString(mathml.Convert(src[m[2]:m[3]], true))
+		case m[4] >= 0:
+			buf.WriteString(mathml.Convert(src[m[4]:m[5]], false))
+		case m[6] >= 0:
+			buf.WriteString(mathml.Convert(src[m[6]:m[7]], false))
+		default:
+			buf.WriteString(mathml.Convert(src[m[8]:m[9]], true))
+		}
+		last = m[1]
+	}
+	buf.WriteString(html.EscapeString(src[last:]))
+	return buf.String()
+}
+
+// texSource returns the TeX source of math in a field, without the HTML which
+// the field's editor may have added, such as line breaks and entities.
+func texSource(field string) string {
+	field = brRE.ReplaceAllString(field, "\n")
+	return html.UnescapeString(tagRE.ReplaceAllString(field, ""))
+}
@@ -0,0 +1,56 @@
+package anki
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestRenderMath(t *testing.T) {
+	const (
+		open      = `<math xmlns="http://www.w3.org/1998/Math/MathML">`
+		openBlock = `<math xmlns="http://www.w3.org/1998/Math/MathML" display="block">`
+	)
+	tests := []struct {
+		name     string
+		field    template.HTML
+		expected template.HTML
+	}{
+		{
+			name:     "no math",
+			field:    "<b>[x]</b> $5",
+			expected: "<b>[x]</b> $5",
+		},
+		{
+			name:     "inline",
+			field:    "Area: [$]\\pi r^2[/$].",
+			expected: "Area: " + open + "<mrow><mi>π</mi><msup><mi>r</mi><mn>2</mn></msup></mrow></math>.",
+		},
+		{
+			name:     "display",
+			field:    "[$$]x&lt;1[/$$]",
+			expected: openBlock + "<mrow><mi>x</mi><mo>&lt;</mo><mn>1</mn></mrow></math>",
+		},
+		{
+			name:     "mathjax",
+			field:    `\(a\) and \[b\]`,
+			expected: open + "<mi>a</mi></math> and " + openBlock + "<mi>b</mi></math>",
+		},
+		{
+			name:     "latex math",
+			field:    `[latex]\frac{1}{<br>2}[/latex]`,
+			expected: openBlock + "<mfrac><mn>1</mn><mn>2</mn></mfrac></math>",
+		},
+		{
+			name:     "latex text",
+			field:    "[latex]If $x>0$ & so on[/latex]",
+			expected: "If " + open + "<mrow><mi>x</mi><mo>&gt;</mo><mn>0</mn></mrow></math> &amp; so on",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := renderMath(test.field); result != test.expected {
+				t.Errorf("Unexpected result:\n%s", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package anki
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/FlashbackSRS/flashback/model"
+)
+
+// Template is an Anki card template.
+type Template struct {
+	// Name is the template's name, such as "Card 1".
+	Name string
+	// Question and Answer are the front and back templates, in Anki's syntax.
+	Question string
+	Answer   string
+}
+
+// Translate converts Anki card templates to the main HTML template of a model
+// handled by mc, such as &Basic{} or &Cloze{}, with a question and an answer
+// for each template, in order. It may be stored with Repo.SetModelTemplate.
+//
+// As in Anki, a cloze model has a single template, which renders the card of
+// each cloze number used in a note's fields.
+//
+// Constructs which cannot be translated, such as filters unknown to mc, are
+// left out of the translation, and reported in a model.TemplateErrors, along
+// with the otherwise complete translation.
+func Translate(mc model.FuncMapper, templates []Template) (string, error) {
+	funcs := mc.FuncMap(nil, QuestionFace)
+	var buf bytes.Buffer
+	var errs model.TemplateErrors
+	for i, t := range templates {
+		qt := &translator{funcs: funcs, name: t.Name, file: t.Name + "/front"}
+		question := qt.translate(t.Question)
+		// The question is translated again, for {{FrontSide}} on the answer
+		// face. Any problems have been reported already.
+		front := (&translator{funcs: funcs, name: t.Name, front: true}).translate(t.Question)
+		at := &translator{funcs: funcs, name: t.Name, file: t.Name + "/back", answer: true, frontSide: front}
+		answer := at.translate(t.Answer)
+		errs = append(errs, qt.errs...)
+		errs = append(errs, at.errs...)
+		fmt.Fprintf(&buf, "<div class=\"question\" data-id=\"%d\">%s</div>\n", i, question)
+		fmt.Fprintf(&buf, "<div class=\"answer\" data-id=\"%d\">%s</div>\n", i, answer)
+	}
+	if len(errs) > 0 {
+		return buf.String(), errs
+	}
+	return buf.String(), nil
+}
+
+// ankiTagRE matches an Anki template tag, such as {{Field}} or {{#Field}}.
+var ankiTagRE = regexp.MustCompile(`(?s){{(.*?)}}`)
+
+// identRE matches field names which may be referred to as .Fields.Name.
+var identRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// filterFuncs maps Anki filters to the template functions which implement
+// them, where their names differ.
+var filterFuncs = map[string]string{
+	"cloze-only": "clozeOnly",
+}
+
+// translator translates a single Anki template.
+type translator struct {
+	funcs template.FuncMap
+	// name is the name of the Anki template.
+	name string
+	file string
+	// answer is true when translating the answer, and front when translating
+	// the question for {{FrontSide}}.
+	answer bool
+	front  bool
+	// frontSide is the translation of {{FrontSide}}, on the answer face.
+	frontSide string
+	// sections is the stack of open conditional sections.
+	sections []string
+	errs     model.TemplateErrors
+	// src and pos locate the tag being translated, for errors.
+	src string
+	pos int
+}
+
+func (t *translator) errorf(format string, args ...interface{}) {
+	t.errs = append(t.errs, &model.TemplateError{
+		File:    t.file,
+		Line:    strings.Count(t.src[:t.pos], "\n") + 1,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (t *translator) translate(src string) string {
+	t.src = src
+	var buf bytes.Buffer
+	var last int
+	for _, m := range ankiTagRE.FindAllStringSubmatchIndex(src, -1) {
+		buf.WriteString(src[last:m[0]])
+		t.pos = m[0]
+		buf.WriteString(t.tag(strings.TrimSpace(src[m[2]:m[3]])))
+		last = m[1]
+	}
+	buf.WriteString(src[last:])
+	for len(t.sections) > 0 {
+		t.errorf("unclosed section {{#%s}}", t.sections[len(t.sections)-1])
+		t.sections = t.sections[:len(t.sections)-1]
+		buf.WriteString("{{ end }}")
+	}
+	// Stray delimiters, such as an unclosed {{, survive translation.
+	if _, err := template.New(t.file).Funcs(t.funcs).Parse(buf.String()); err != nil {
+		t.pos = 0
+		t.errorf("%s", err)
+	}
+	return buf.String()
+}
+
+// tag translates the content of a single tag.
+func (t *translator) tag(tag string) string {
+	switch {
+	case tag == "":
+		return ""
+	case strings.HasPrefix(tag, "#"), strings.HasPrefix(tag, "^"):
+		name := strings.TrimSpace(tag[1:])
+		cond, ok := t.condition(name)
+		if !ok {
+			t.errorf("unsupported section {{%s}}", tag)
+			cond = "false"
+		}
+		t.sections = append(t.sections, name)
+		if tag[0] == '^' {
+			return fmt.Sprintf("{{ if not (%s) }}", cond)
+		}
+		return fmt.Sprintf("{{ if %s }}", cond)
+	case strings.HasPrefix(tag, "/"):
+		name := strings.TrimSpace(tag[1:])
+		if len(t.sections) == 0 {
+			t.errorf("found {{/%s}} outside of a section", name)
+			return ""
+		}
+		if open := t.sections[len(t.sections)-1]; open != name {
+			t.errorf("found {{/%s}}, expected {{/%s}}", name, open)
+		}
+		t.sections = t.sections[:len(t.sections)-1]
+		return "{{ end }}"
+	case strings.HasPrefix(tag, "=") && strings.HasSuffix(tag, "="):
+		t.errorf("unsupported delimiter change {{%s}}", tag)
+		return ""
+	}
+	parts := strings.Split(tag, ":")
+	name := strings.TrimSpace(parts[len(parts)-1])
+	filters := parts[:len(parts)-1]
+	for i, f := range filters {
+		filters[i] = strings.TrimSpace(f)
+	}
+	if len(filters) > 0 && filters[0] == "type" {
+		return t.typeTag(tag, name, filters[1:])
+	}
+	if special, ok := t.special(name); ok {
+		if len(filters) > 0 {
+			t.errorf("unsupported filter on {{%s}}", name)
+		}
+		return special
+	}
+	value := fieldExpr(name)
+	// Filters are applied from the one nearest the field outwards.
+	for i := len(filters) - 1; i >= 0; i-- {
+		if cmd, ok := t.filter(name, filters[i]); ok {
+			value += " | " + cmd
+		}
+	}
+	if _, ok := t.funcs["math"]; ok {
+		// Anki renders math in all fields.
+		value += " | math"
+	}
+	return "{{ " + value + " }}"
+}
+
+// typeTag translates {{type:Field}} and {{type:cloze:Field}}.
+func (t *translator) typeTag(tag, name string, filters []string) string {
+	fn := "type"
+	switch {
+	case len(filters) == 0:
+	case len(filters) == 1 && filters[0] == "cloze":
+		fn = "typeCloze"
+	default:
+		t.errorf("unsupported type-in answer {{%s}}", tag)
+		return ""
+	}
+	if _, ok := t.funcs[fn]; !ok {
+		t.errorf("unsupported type-in answer {{%s}}", tag)
+		return ""
+	}
+	return fmt.Sprintf("{{ %s %s }}", fn, strconv.Quote(name))
+}
+
+// special translates Anki's special fields, and returns false if name is not
+// one of them.
+func (t *translator) special(name string) (string, bool) {
+	switch name {
+	case "FrontSide":
+		if !t.answer {
+			if !t.front {
+				t.errorf("{{FrontSide}} is only available on the back")
+			}
+			return "", true
+		}
+		return t.frontSide, true
+	case "Tags":
+		return `{{ range $i, $tag := .Tags }}{{ if $i }} {{ end }}{{ $tag }}{{ end }}`, true
+	case "Card":
+		return fmt.Sprintf("{{ %s }}", strconv.Quote(t.name)), true
+	case "Type", "Deck", "Subdeck", "CardFlag", "CardID":
+		t.errorf("unsupported field {{%s}}", name)
+		return "", true
+	}
+	return "", false
+}
+
+// fieldExpr returns the template expression for the value of a note field.
+func fieldExpr(name string) string {
+	if identRE.MatchString(name) {
+		return ".Fields." + name
+	}
+	return fmt.Sprintf("index .Fields %s", strconv.Quote(name))
+}
+
+// condition returns the template expression which is true if the named field
+// is not empty.
+func (t *translator) condition(name string) (string, bool) {
+	switch name {
+	case "Tags":
+		return ".Tags", true
+	case "FrontSide", "Card", "Type", "Deck", "Subdeck", "CardFlag", "CardID":
+		return "", false
+	}
+	return fmt.Sprintf("nonempty (%s)", fieldExpr(name)), true
+}
+
+// filter returns the template pipeline command for an Anki filter, such as
+// text or hint, applied to the named field.
+func (t *translator) filter(field, filter string) (string, bool) {
+	args := strings.Fields(filter)
+	if len(args) == 0 {
+		return "", false
+	}
+	fn := args[0]
+	if f, ok := filterFuncs[fn]; ok {
+		fn = f
+	}
+	if fn == "cloze" && t.front {
+		fn = "clozeQuestion"
+	}
+	if _, ok := t.funcs[fn]; !ok {
+		t.errorf("unsupported filter '%s'", args[0])
+		return "", false
+	}
+	cmd := []string{fn}
+	if fn == "hint" {
+		cmd = append(cmd, strconv.Quote(field))
+	}
+	for _, arg := range args[1:] {
+		cmd = append(cmd, strconv.Quote(arg))
+	}
+	return strings.Join(cmd, " "), true
+}
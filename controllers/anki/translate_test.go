@@ -0,0 +1,206 @@
+package anki
+
+import (
+	"bytes"
+	"html/template"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/testy"
+
+	"github.com/FlashbackSRS/flashback/model"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		name      string
+		mc        model.FuncMapper
+		templates []Template
+		expected  string
+		err       string
+	}{
+		{
+			name: "basic",
+			mc:   &Basic{},
+			templates: []Template{
+				{Name: "Card 1", Question: "{{Front}}", Answer: "{{FrontSide}}\n\n<hr id=answer>\n\n{{Back}}"},
+			},
+			expected: `<div class="question" data-id="0">{{ .Fields.Front | math }}</div>
+<div class="answer" data-id="0">{{ .Fields.Front | math }}
+
+<hr id=answer>
+
+{{ .Fields.Back | math }}</div>
+`,
+		},
+		{
+			name: "optional reversed card",
+			mc:   &Basic{},
+			templates: []Template{
+				{Name: "Card 1", Question: "{{Front}}", Answer: "{{Back}}"},
+				{Name: "Card 2", Question: "{{#Add Reverse}}{{Back}}{{/Add Reverse}}", Answer: "{{Front}}"},
+			},
+			expected: `<div class="question" data-id="0">{{ .Fields.Front | math }}</div>
+<div class="answer" data-id="0">{{ .Fields.Back | math }}</div>
+<div class="question" data-id="1">{{ if nonempty (index .Fields "Add Reverse") }}{{ .Fields.Back | math }}{{ end }}</div>
+<div class="answer" data-id="1">{{ .Fields.Front | math }}</div>
+`,
+		},
+		{
+			name: "type in the answer",
+			mc:   &Basic{},
+			templates: []Template{
+				{Name: "Card 1", Question: "{{Front}}\n\n{{type:Back}}", Answer: "{{Front}}\n\n<hr id=answer>\n\n{{type:Back}}"},
+			},
+			expected: `<div class="question" data-id="0">{{ .Fields.Front | math }}
+
+{{ type "Back" }}</div>
+<div class="answer" data-id="0">{{ .Fields.Front | math }}
+
+<hr id=answer>
+
+{{ type "Back" }}</div>
+`,
+		},
+		{
+			name: "filters and special fields",
+			mc:   &Basic{},
+			templates: []Template{
+				{Name: "Card 1", Question: "{{text:Front}} {{^Notes}}none{{/Notes}}", Answer: "{{hint:Notes}}{{#Tags}}{{Tags}}{{/Tags}} {{ Card }}"},
+			},
+			expected: `<div class="question" data-id="0">{{ .Fields.Front | text | math }} {{ if not (nonempty (.Fields.Notes)) }}none{{ end }}</div>
+<div class="answer" data-id="0">{{ .Fields.Notes | hint "Notes" | math }}{{ if .Tags }}{{ range $i, $tag := .Tags }}{{ if $i }} {{ end }}{{ $tag }}{{ end }}{{ end }} {{ "Card 1" }}</div>
+`,
+		},
+		{
+			name: "cloze",
+			mc:   &Cloze{},
+			templates: []Template{
+				{Name: "Cloze", Question: "{{cloze:Text}}{{type:cloze:Text}}", Answer: "{{FrontSide}}<hr>{{cloze:Text}}<br>{{Back Extra}}"},
+			},
+			expected: `<div class="question" data-id="0">{{ .Fields.Text | cloze | math }}{{ typeCloze "Text" }}</div>
+<div class="answer" data-id="0">{{ .Fields.Text | clozeQuestion | math }}{{ typeCloze "Text" }}<hr>{{ .Fields.Text | cloze | math }}<br>{{ index .Fields "Back Extra" | math }}</div>
+`,
+		},
+		{
+			name: "unsupported",
+			mc:   &Basic{},
+			templates: []Template{
+				{Name: "Card 1", Question: "{{FrontSide}}{{cloze:Front}}\n{{#Front}}{{Deck}}", Answer: "{{/Back}}{{=<% %>=}}{{type:nc:Back}}"},
+			},
+			expected: `<div class="question" data-id="0">{{ .Fields.Front | math }}
+{{ if nonempty (.Fields.Front) }}{{ end }}</div>
+<div class="answer" data-id="0"></div>
+`,
+			err: "Card 1/front:1: {{FrontSide}} is only available on the back; " +
+				"Card 1/front:1: unsupported filter 'cloze'; " +
+				"Card 1/front:2: unsupported field {{Deck}}; " +
+				"Card 1/front:2: unclosed section {{#Front}}; " +
+				"Card 1/back:1: found {{/Back}} outside of a section; " +
+				"Card 1/back:1: unsupported delimiter change {{=<% %>=}}; " +
+				"Card 1/back:1: unsupported type-in answer {{type:nc:Back}}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := Translate(test.mc, test.templates)
+			testy.Error(t, test.err, err)
+			if d := diff.Text(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestTranslateExecute(t *testing.T) {
+	result, err := Translate(&Basic{}, []Template{
+		{Name: "Card 1", Question: "{{#Notes}}{{Notes}}{{/Notes}}{{^Notes}}{{text:Front}}{{/Notes}} {{Tags}}", Answer: "{{FrontSide}}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := template.New("test").Funcs((&Basic{}).FuncMap(nil, AnswerFace)).Parse(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{
+		"Fields": map[string]template.HTML{"Front": "<b>uno</b> &amp; dos", "Notes": "<br>"},
+		"Tags":   []string{"spanish", "numbers"},
+	}
+	var buf bytes.Buffer
+	if e := tmpl.Execute(&buf, data); e != nil {
+		t.Fatal(e)
+	}
+	expected := `<div class="question" data-id="0">uno &amp; dos spanish numbers</div>
+<div class="answer" data-id="0">uno &amp; dos spanish numbers</div>
+`
+	if d := diff.Text(expected, buf.String()); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestTranslateJapanese(t *testing.T) {
+	result, err := Translate(&Basic{}, []Template{
+		{Name: "Card 1", Question: "{{kanji:Reading}}{{tts ja_JP speed=0.8:kana:Reading}}", Answer: "{{furigana:Reading}}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<div class="question" data-id="0">{{ .Fields.Reading | kanji | math }}{{ .Fields.Reading | kana | tts "ja_JP" "speed=0.8" | math }}</div>
+<div class="answer" data-id="0">{{ .Fields.Reading | furigana | math }}</div>
+`
+	if d := diff.Text(expected, result); d != nil {
+		t.Fatal(d)
+	}
+	tmpl, err := template.New("test").Funcs((&Basic{}).FuncMap(nil, QuestionFace)).Parse(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{
+		"Fields": map[string]template.HTML{"Reading": "日本[にほん]"},
+	}
+	var buf bytes.Buffer
+	if e := tmpl.Execute(&buf, data); e != nil {
+		t.Fatal(e)
+	}
+	expected = `<div class="question" data-id="0">日本<span class="tts" lang="ja-JP" data-speed="0.8" hidden>にほん</span></div>
+<div class="answer" data-id="0"><ruby>日本<rt>にほん</rt></ruby></div>
+`
+	if d := diff.Text(expected, buf.String()); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestNonempty(t *testing.T) {
+	tests := map[template.HTML]bool{
+		"":                  false,
+		" <br> <div></div>": false,
+		"&nbsp;<br/>":       false,
+		"foo":               true,
+		"<img src=x>":       true,
+	}
+	for field, expected := range tests {
+		if result := nonempty(field); result != expected {
+			t.Errorf("%q: expected %t", field, expected)
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	if result := hint("Notes", ""); result != "" {
+		t.Errorf("Unexpected result for empty field: %s", result)
+	}
+	expected := template.HTML(`<details class="hint"><summary>Notes &amp; tips</summary><i>foo</i></details>`)
+	if result := hint("Notes & tips", "<i>foo</i>"); result != expected {
+		t.Errorf("Unexpected result: %s", result)
+	}
+}
+
+func TestTypeAnswer(t *testing.T) {
+	if result := typeAnswer(QuestionFace)("Back"); result != `<input type="text" name="type:Back">` {
+		t.Errorf("Unexpected question: %s", result)
+	}
+	if result := typeAnswer(AnswerFace)("Back"); result != `<input type="text" name="type:Back" disabled>` {
+		t.Errorf("Unexpected answer: %s", result)
+	}
+}
@@ -0,0 +1,273 @@
+// Package drawing is the model handler for drawing models, whose answers are
+// drawn or hand written, such as for practicing kanji and hanzi.
+//
+// Templates display a drawing canvas on the question face with {{ canvas }},
+// and the submitted drawing on the answer face with {{ drawing }}, alongside
+// the correct answer. The drawing is stored as a file of a pending review, as
+// described in notes.txt, and graded by the user on the answer face, or later,
+// if the card's deck uses delayed review.
+package drawing
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/flimzy/log"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback"
+	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/model"
+	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
+)
+
+// The possible faces of a drawing card
+const (
+	QuestionFace = iota
+	AnswerFace
+)
+
+// CanvasSize is the width and height of the drawing canvas, in pixels.
+const CanvasSize = 300
+
+// The names under which drawings are stored, according to their format.
+const (
+	pngFile     = "drawing.png"
+	strokesFile = "drawing.json"
+)
+
+const pngPrefix = "data:image/png;base64,"
+
+func init() {
+	log.Debug("Registering drawing model\n")
+	model.RegisterModelController(&Drawing{})
+	log.Debug("Done registering drawing model\n")
+}
+
+//go:generate go-bindata -pkg drawing -nocompress -prefix files -o data.go files
+
+// Drawing is the controller for drawing models.
+type Drawing struct{}
+
+var _ model.ModelController = &Drawing{}
+var _ model.FuncMapper = &Drawing{}
+
+// Type returns the string "drawing", to identify this model handler's type.
+func (m *Drawing) Type() string {
+	return "drawing"
+}
+
+// IframeScript returns JavaScript to run inside the iframe.
+func (m *Drawing) IframeScript() []byte {
+	data, err := Asset("script.js")
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+var buttonMaps = map[int]studyview.ButtonMap{
+	QuestionFace: studyview.ButtonMap{
+		studyview.ButtonRight: {Name: "Show Answer", Enabled: true},
+	},
+	AnswerFace: studyview.ButtonMap{
+		studyview.ButtonLeft:        {Name: "Incorrect", Enabled: true},
+		studyview.ButtonCenterLeft:  {Name: "Difficult", Enabled: true},
+		studyview.ButtonCenterRight: {Name: "Correct", Enabled: true},
+		studyview.ButtonRight:       {Name: "Easy", Enabled: true},
+	},
+}
+
+// Buttons returns the initial button state
+func (m *Drawing) Buttons(_ *model.Card, face int) (studyview.ButtonMap, error) {
+	buttons, ok := buttonMaps[face]
+	if !ok {
+		return nil, errors.Errorf("Invalid face %d", face)
+	}
+	return buttons, nil
+}
+
+const (
+	contextKeyReview = "review"
+	contextKeyFile   = "file"
+)
+
+// Action responds to a card action, such as a button press
+func (m *Drawing) Action(card *model.Card, face *int, startTime time.Time, payload interface{}) (bool, error) {
+	query := convertQuery(payload)
+	log.Debugf("Submit recieved for face %d\n", *face)
+	button := studyview.Button(query.Submit)
+	buttons, ok := buttonMaps[*face]
+	if !ok {
+		return false, errors.Errorf("Unexpected face %d", *face)
+	}
+	if _, valid := buttons[button]; !valid {
+		return false, errors.Errorf("Unexpected button press %s", button)
+	}
+	// The model controller interface provides no context.
+	ctx := context.TODO()
+	switch *face {
+	case QuestionFace:
+		name, file, err := parseDrawing(query.Drawing)
+		if err != nil {
+			return false, err
+		}
+		*face++
+		card.Context = nil
+		if file == nil {
+			// Nothing was drawn
+			return false, nil
+		}
+		review, err := card.SubmitForReview(ctx, nil, time.Now().Sub(startTime), map[string]*fb.Attachment{name: file})
+		if err != nil {
+			return false, err
+		}
+		conf, err := card.DeckConfig(ctx)
+		if err != nil {
+			return false, err
+		}
+		if conf.ReviewMode == model.ReviewModeDelayed {
+			return true, nil
+		}
+		card.Context = map[string]interface{}{
+			contextKeyReview: review.ID,
+			contextKeyFile:   name,
+		}
+		return false, nil
+	case AnswerFace:
+		if reviewID, _ := contextString(card, contextKeyReview); reviewID != "" {
+			// Graded here, so not to be reviewed later. Discarded first, as
+			// that unburies the card, which may then be buried by Schedule.
+			if err := card.DiscardReview(ctx, reviewID); err != nil {
+				return false, err
+			}
+		}
+		log.Debugf("Old schedule: Due %s, Interval: %s, Ease: %f, ReviewCount: %d\n", card.Due, card.Interval, card.EaseFactor, card.ReviewCount)
+		if err := model.Schedule(card, time.Now().Sub(startTime), quality(button)); err != nil {
+			return false, err
+		}
+		log.Debugf("New schedule: Due %s, Interval: %s, Ease: %f, ReviewCount: %d\n", card.Due, card.Interval, card.EaseFactor, card.ReviewCount)
+		card.Context = nil // Clear the review
+		return true, nil
+	}
+	return false, nil
+}
+
+func quality(button studyview.Button) flashback.AnswerQuality {
+	switch button {
+	case studyview.ButtonLeft:
+		return flashback.AnswerBlackout
+	case studyview.ButtonCenterLeft:
+		return flashback.AnswerCorrectDifficult
+	case studyview.ButtonCenterRight:
+		return flashback.AnswerCorrect
+	case studyview.ButtonRight:
+		return flashback.AnswerPerfect
+	}
+	return flashback.AnswerBlackout
+}
+
+// contextString returns the string stored in the card's context under key.
+func contextString(card *model.Card, key string) (string, bool) {
+	ctx, _ := card.Context.(map[string]interface{})
+	s, ok := ctx[key].(string)
+	return s, ok
+}
+
+// parseDrawing parses a submitted drawing, which is either a list of strokes,
+// in JSON, or a PNG image, as a data URL, and returns it as a file to store.
+// If nothing was drawn, file is nil.
+func parseDrawing(drawing string) (name string, file *fb.Attachment, err error) {
+	switch {
+	case drawing == "":
+		return "", nil, nil
+	case strings.HasPrefix(drawing, pngPrefix):
+		content, err := base64.StdEncoding.DecodeString(drawing[len(pngPrefix):])
+		if err != nil {
+			return "", nil, errors.Wrap(err, "invalid drawing")
+		}
+		return pngFile, &fb.Attachment{ContentType: "image/png", Content: content}, nil
+	}
+	if _, err := parseStrokes([]byte(drawing)); err != nil {
+		return "", nil, err
+	}
+	return strokesFile, &fb.Attachment{ContentType: "application/json", Content: []byte(drawing)}, nil
+}
+
+// parseStrokes parses a drawing's strokes, each of which is a list of [x, y]
+// points.
+func parseStrokes(data []byte) ([][][2]float64, error) {
+	var strokes [][][2]float64
+	if err := json.Unmarshal(data, &strokes); err != nil {
+		return nil, errors.Wrap(err, "invalid drawing")
+	}
+	return strokes, nil
+}
+
+// FuncMap returns a function map for drawing templates.
+func (m *Drawing) FuncMap(card *model.Card, face int) template.FuncMap {
+	return template.FuncMap{
+		"canvas": func() template.HTML {
+			if face != QuestionFace {
+				return ""
+			}
+			return template.HTML(fmt.Sprintf(`<canvas class="drawing" width="%d" height="%d"></canvas><input type="hidden" name="drawing" value=""/>`, CanvasSize, CanvasSize))
+		},
+		"drawing": func() (template.HTML, error) {
+			if card == nil || face != AnswerFace {
+				// Template parsing, or the question face
+				return "", nil
+			}
+			reviewID, _ := contextString(card, contextKeyReview)
+			name, _ := contextString(card, contextKeyFile)
+			if reviewID == "" {
+				return `<div class="drawing empty"></div>`, nil
+			}
+			// The model controller interface provides no context.
+			file, err := card.ReviewFile(context.TODO(), reviewID, name)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to fetch drawing")
+			}
+			return drawingHTML(file)
+		},
+	}
+}
+
+// drawingHTML renders a stored drawing: an image as an <img> element, and
+// strokes as SVG.
+func drawingHTML(file *fb.Attachment) (template.HTML, error) {
+	if file.ContentType != "application/json" {
+		return template.HTML(fmt.Sprintf(`<img class="drawing" src="data:%s;base64,%s"/>`,
+			template.HTMLEscapeString(file.ContentType), base64.StdEncoding.EncodeToString(file.Content))), nil
+	}
+	strokes, err := parseStrokes(file.Content)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg class="drawing" xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, CanvasSize, CanvasSize, CanvasSize, CanvasSize)
+	for _, stroke := range strokes {
+		if len(stroke) == 0 {
+			continue
+		}
+		buf.WriteString(`<path d="`)
+		for i, p := range stroke {
+			cmd := "L"
+			if i == 0 {
+				cmd = "M"
+			} else {
+				buf.WriteString(" ")
+			}
+			fmt.Fprintf(&buf, "%s%g %g", cmd, p[0], p[1])
+		}
+		buf.WriteString(`" fill="none" stroke="currentColor" stroke-width="4" stroke-linecap="round" stroke-linejoin="round"/>`)
+	}
+	buf.WriteString(`</svg>`)
+	return template.HTML(buf.String()), nil
+}
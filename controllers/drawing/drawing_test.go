@@ -0,0 +1,179 @@
+package drawing
+
+import (
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/testy"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/model"
+	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
+)
+
+func TestType(t *testing.T) {
+	if typ := (&Drawing{}).Type(); typ != "drawing" {
+		t.Errorf("Unexpected type: %s", typ)
+	}
+}
+
+func TestButtons(t *testing.T) {
+	result, err := (&Drawing{}).Buttons(nil, QuestionFace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := studyview.ButtonMap{
+		"button-r": {Name: "Show Answer", Enabled: true},
+	}
+	if d := diff.Interface(expected, result); d != nil {
+		t.Error(d)
+	}
+	_, err = (&Drawing{}).Buttons(nil, 2)
+	testy.Error(t, "Invalid face 2", err)
+}
+
+func TestParseDrawing(t *testing.T) {
+	tests := []struct {
+		name     string
+		drawing  string
+		file     string
+		expected *fb.Attachment
+		err      string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "strokes",
+			drawing:  "[[[0,0],[1,1]]]",
+			file:     "drawing.json",
+			expected: &fb.Attachment{ContentType: "application/json", Content: []byte("[[[0,0],[1,1]]]")},
+		},
+		{
+			name:     "png",
+			drawing:  "data:image/png;base64,iVBORw==",
+			file:     "drawing.png",
+			expected: &fb.Attachment{ContentType: "image/png", Content: []byte("\x89PNG")},
+		},
+		{
+			name:    "invalid png",
+			drawing: "data:image/png;base64,!!",
+			err:     "^invalid drawing: illegal base64 data at input byte 0",
+		},
+		{
+			name:    "invalid strokes",
+			drawing: `[["foo"]]`,
+			err:     "^invalid drawing: json: cannot unmarshal string into ",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, file, err := parseDrawing(test.drawing)
+			testy.ErrorRE(t, test.err, err)
+			if name != test.file {
+				t.Errorf("Unexpected file name: %s", name)
+			}
+			if d := diff.Interface(test.expected, file); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestDrawingHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     *fb.Attachment
+		expected string
+		err      string
+	}{
+		{
+			name:     "png",
+			file:     &fb.Attachment{ContentType: "image/png", Content: []byte("\x89PNG")},
+			expected: `<img class="drawing" src="data:image/png;base64,iVBORw=="/>`,
+		},
+		{
+			name: "strokes",
+			file: &fb.Attachment{ContentType: "application/json", Content: []byte("[[[0,0],[1.5,2]],[],[[3,4]]]")},
+			expected: `<svg class="drawing" xmlns="http://www.w3.org/2000/svg" width="300" height="300" viewBox="0 0 300 300">` +
+				`<path d="M0 0 L1.5 2" fill="none" stroke="currentColor" stroke-width="4" stroke-linecap="round" stroke-linejoin="round"/>` +
+				`<path d="M3 4" fill="none" stroke="currentColor" stroke-width="4" stroke-linecap="round" stroke-linejoin="round"/>` +
+				`</svg>`,
+		},
+		{
+			name: "invalid",
+			file: &fb.Attachment{ContentType: "application/json", Content: []byte("foo")},
+			err:  "invalid drawing: invalid character 'o' in literal false (expecting 'a')",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := drawingHTML(test.file)
+			testy.Error(t, test.err, err)
+			if string(result) != test.expected {
+				t.Errorf("Unexpected result:\n%s", result)
+			}
+		})
+	}
+}
+
+func TestAction(t *testing.T) {
+	m := &Drawing{}
+	t.Run("invalid button", func(t *testing.T) {
+		face := QuestionFace
+		_, err := m.Action(&model.Card{Card: &fb.Card{}}, &face, time.Now(), &drawingQuery{Submit: "button-l"})
+		testy.Error(t, "Unexpected button press Left", err)
+	})
+	t.Run("invalid face", func(t *testing.T) {
+		face := 2
+		_, err := m.Action(&model.Card{Card: &fb.Card{}}, &face, time.Now(), &drawingQuery{Submit: "button-r"})
+		testy.Error(t, "Unexpected face 2", err)
+	})
+	t.Run("invalid drawing", func(t *testing.T) {
+		face := QuestionFace
+		_, err := m.Action(&model.Card{Card: &fb.Card{}}, &face, time.Now(), &drawingQuery{Submit: "button-r", Drawing: "foo"})
+		testy.Error(t, "invalid drawing: invalid character 'o' in literal false (expecting 'a')", err)
+	})
+	t.Run("nothing drawn", func(t *testing.T) {
+		card := &model.Card{Card: &fb.Card{}}
+		face := QuestionFace
+		done, err := m.Action(card, &face, time.Now(), &drawingQuery{Submit: "button-r"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done || face != AnswerFace {
+			t.Errorf("Expected the answer face, got done: %t, face: %d", done, face)
+		}
+	})
+	t.Run("grade", func(t *testing.T) {
+		card := &model.Card{Card: &fb.Card{}}
+		face := AnswerFace
+		done, err := m.Action(card, &face, time.Now(), &drawingQuery{Submit: "button-cr"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !done {
+			t.Error("Expected the card to be done")
+		}
+		if card.ReviewCount != 1 {
+			t.Errorf("Card not scheduled: %v", card.Card)
+		}
+	})
+}
+
+func TestFuncMapDrawing(t *testing.T) {
+	m := &Drawing{}
+	render := func(card *model.Card, face int) (template.HTML, error) {
+		return m.FuncMap(card, face)["drawing"].(func() (template.HTML, error))()
+	}
+	card := &model.Card{Card: &fb.Card{}}
+	if result, err := render(card, AnswerFace); err != nil || result != `<div class="drawing empty"></div>` {
+		t.Errorf("Unexpected result for no drawing: %s, %v", result, err)
+	}
+	card.Context = map[string]interface{}{"review": "review-Zm9v", "file": "drawing.json"}
+	if result, _ := render(card, QuestionFace); result != "" {
+		t.Errorf("Unexpected result on the question face: %s", result)
+	}
+}
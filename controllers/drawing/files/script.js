@@ -0,0 +1,53 @@
+'use strict';
+docReady(function() {
+    if ( FB.face != 0 ) {
+        return;
+    }
+    // Strokes are recorded as lists of [x, y] points, in canvas coordinates,
+    // and submitted as JSON in the hidden 'drawing' field.
+    var canvas = document.querySelector('canvas.drawing');
+    var field = document.getElementsByName('drawing')[0];
+    if ( canvas === null || field === undefined ) {
+        console.log("Drawing canvas not found");
+        return;
+    }
+    var ctx = canvas.getContext('2d');
+    ctx.lineWidth = 4;
+    ctx.lineCap = 'round';
+    ctx.lineJoin = 'round';
+    var strokes = [];
+    var stroke = null;
+    var point = function(e) {
+        var rect = canvas.getBoundingClientRect();
+        return [
+            Math.round((e.clientX - rect.left) * canvas.width / rect.width),
+            Math.round((e.clientY - rect.top) * canvas.height / rect.height)
+        ];
+    };
+    canvas.addEventListener('pointerdown', function(e) {
+        e.preventDefault();
+        canvas.setPointerCapture(e.pointerId);
+        stroke = [point(e)];
+        ctx.beginPath();
+        ctx.moveTo(stroke[0][0], stroke[0][1]);
+    });
+    canvas.addEventListener('pointermove', function(e) {
+        if ( stroke === null ) {
+            return;
+        }
+        var p = point(e);
+        stroke.push(p);
+        ctx.lineTo(p[0], p[1]);
+        ctx.stroke();
+    });
+    var end = function() {
+        if ( stroke === null ) {
+            return;
+        }
+        strokes.push(stroke);
+        stroke = null;
+        field.value = JSON.stringify(strokes);
+    };
+    canvas.addEventListener('pointerup', end);
+    canvas.addEventListener('pointercancel', end);
+});
@@ -0,0 +1,8 @@
+package drawing
+
+type drawingQuery struct {
+	Submit string
+	// Drawing is the submitted drawing, as JSON strokes or a PNG data URL, or
+	// empty if nothing was drawn.
+	Drawing string
+}
@@ -0,0 +1,18 @@
+// +build js
+
+package drawing
+
+import "github.com/gopherjs/gopherjs/js"
+
+func convertQuery(query interface{}) *drawingQuery {
+	if jsQuery, ok := query.(*js.Object); ok {
+		q := &drawingQuery{
+			Submit: jsQuery.Get("submit").String(),
+		}
+		if drawing := jsQuery.Get("drawing"); drawing != js.Undefined {
+			q.Drawing = drawing.String()
+		}
+		return q
+	}
+	return query.(*drawingQuery)
+}
@@ -0,0 +1,7 @@
+// +build !js
+
+package drawing
+
+func convertQuery(query interface{}) *drawingQuery {
+	return query.(*drawingQuery)
+}
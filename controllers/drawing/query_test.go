@@ -0,0 +1,14 @@
+package drawing
+
+import "testing"
+
+func TestConvertQuery(t *testing.T) {
+	expected := &drawingQuery{
+		Submit:  "button-r",
+		Drawing: "[[[0,0],[1,1]]]",
+	}
+	result := convertQuery(expected)
+	if result != expected {
+		t.Errorf("Unexpected result")
+	}
+}
@@ -0,0 +1,73 @@
+// Package lesson is the model handler for lessons.
+//
+// A lesson card is informational, and is shown once, with only a "Continue"
+// button, before the cards which follow it in its deck are introduced. Lesson
+// cards are not scheduled for review.
+package lesson
+
+import (
+	"time"
+
+	"github.com/flimzy/log"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/model"
+	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
+)
+
+// LessonFace is the only face of a lesson card.
+const LessonFace = 0
+
+func init() {
+	log.Debug("Registering lesson model\n")
+	model.RegisterModelController(&Lesson{})
+	log.Debug("Done registering lesson model\n")
+}
+
+// Lesson is the controller for lesson models.
+type Lesson struct{}
+
+var _ model.ModelController = &Lesson{}
+var _ model.Lessoner = &Lesson{}
+
+// Type returns the string "lesson", to identify this model handler's type.
+func (m *Lesson) Type() string {
+	return "lesson"
+}
+
+// IframeScript returns JavaScript to run inside the iframe. Lessons need none.
+func (m *Lesson) IframeScript() []byte {
+	return nil
+}
+
+// Lesson returns true, as the model's cards are lessons.
+func (m *Lesson) Lesson() bool {
+	return true
+}
+
+var buttons = studyview.ButtonMap{
+	studyview.ButtonRight: {Name: "Continue", Enabled: true},
+}
+
+// Buttons returns the initial button state
+func (m *Lesson) Buttons(_ *model.Card, face int) (studyview.ButtonMap, error) {
+	if face != LessonFace {
+		return nil, errors.Errorf("Invalid face %d", face)
+	}
+	return buttons, nil
+}
+
+// Action responds to a card action, such as a button press
+func (m *Lesson) Action(card *model.Card, face *int, _ time.Time, payload interface{}) (bool, error) {
+	query := convertQuery(payload)
+	log.Debugf("Submit recieved for face %d: %v\n", *face, query)
+	if *face != LessonFace {
+		return false, errors.Errorf("Unexpected face %d", *face)
+	}
+	button := studyview.Button(query.Submit)
+	if _, valid := buttons[button]; !valid {
+		return false, errors.Errorf("Unexpected button press %s", button)
+	}
+	model.MarkSeen(card)
+	return true, nil
+}
@@ -0,0 +1,56 @@
+package lesson
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/testy"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/model"
+	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
+)
+
+func TestButtons(t *testing.T) {
+	result, err := (&Lesson{}).Buttons(nil, LessonFace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := studyview.ButtonMap{
+		"button-r": {Name: "Continue", Enabled: true},
+	}
+	if d := diff.Interface(expected, result); d != nil {
+		t.Error(d)
+	}
+	_, err = (&Lesson{}).Buttons(nil, 1)
+	testy.Error(t, "Invalid face 1", err)
+}
+
+func TestAction(t *testing.T) {
+	m := &Lesson{}
+	t.Run("invalid button", func(t *testing.T) {
+		face := LessonFace
+		_, err := m.Action(&model.Card{Card: &fb.Card{}}, &face, time.Now(), &lessonQuery{Submit: "button-l"})
+		testy.Error(t, "Unexpected button press Left", err)
+	})
+	t.Run("invalid face", func(t *testing.T) {
+		face := 1
+		_, err := m.Action(&model.Card{Card: &fb.Card{}}, &face, time.Now(), &lessonQuery{Submit: "button-r"})
+		testy.Error(t, "Unexpected face 1", err)
+	})
+	t.Run("continue", func(t *testing.T) {
+		card := &model.Card{Card: &fb.Card{}}
+		face := LessonFace
+		done, err := m.Action(card, &face, time.Now(), &lessonQuery{Submit: "button-r"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !done {
+			t.Error("Expected the lesson to be done")
+		}
+		if card.Suspended || card.ReviewCount != 1 || !card.Due.After(fb.Due(time.Now()).Add(365*fb.Day)) {
+			t.Errorf("Lesson not marked seen: %v", card.Card)
+		}
+	})
+}
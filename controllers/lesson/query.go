@@ -0,0 +1,5 @@
+package lesson
+
+type lessonQuery struct {
+	Submit string
+}
@@ -0,0 +1,14 @@
+// +build js
+
+package lesson
+
+import "github.com/gopherjs/gopherjs/js"
+
+func convertQuery(query interface{}) *lessonQuery {
+	if jsQuery, ok := query.(*js.Object); ok {
+		return &lessonQuery{
+			Submit: jsQuery.Get("submit").String(),
+		}
+	}
+	return query.(*lessonQuery)
+}
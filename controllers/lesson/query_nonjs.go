@@ -0,0 +1,7 @@
+// +build !js
+
+package lesson
+
+func convertQuery(query interface{}) *lessonQuery {
+	return query.(*lessonQuery)
+}
@@ -0,0 +1,19 @@
+'use strict';
+docReady(function() {
+    if ( FB.face != 0 ) {
+        return;
+    }
+    // Selecting a choice submits it, as if the 'Check Answer' button were pressed.
+    var form = document.getElementById('mainform');
+    var choices = document.getElementsByName('choice');
+    for ( var i = 0; i < choices.length; i++ ) {
+        choices[i].addEventListener('change', function() {
+            var input = document.createElement('input');
+            input.type = 'hidden';
+            input.name = 'submit';
+            input.value = 'button-r';
+            form.appendChild(input);
+            form.dispatchEvent(new Event('submit'));
+        });
+    }
+});
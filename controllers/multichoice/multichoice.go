@@ -0,0 +1,294 @@
+// Package multichoice is the model handler for multiple-choice models.
+//
+// A multiple-choice note has a field named "Question", a field named "Answer",
+// holding the correct choice, and any number of fields whose names begin with
+// "Choice", holding the incorrect choices, or distractors. Templates display
+// the choices with {{ choices }}, in an order which is shuffled for each
+// review. Selecting a choice grades the answer automatically.
+package multichoice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flimzy/log"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/model"
+	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
+)
+
+// The possible faces of a multiple-choice card
+const (
+	QuestionFace = iota
+	AnswerFace
+)
+
+// The names of the fields used by multiple-choice models.
+const (
+	AnswerField  = "Answer"
+	ChoicePrefix = "Choice"
+)
+
+// DeckChoices is the number of distractors a deck multiple-choice card draws
+// from other notes in its deck, less any provided by the note itself.
+const DeckChoices = 3
+
+// quickAnswer is the time within which a correct answer is graded as perfect.
+const quickAnswer = 10 * time.Second
+
+func init() {
+	log.Debug("Registering multiple-choice models\n")
+	model.RegisterModelController(&Multichoice{})
+	model.RegisterModelController(&Multichoice{FromDeck: true})
+	log.Debug("Done registering multiple-choice models\n")
+}
+
+//go:generate go-bindata -pkg multichoice -nocompress -prefix files -o data.go files
+
+// Multichoice is the controller for multiple-choice models.
+type Multichoice struct {
+	// FromDeck, if true, supplements the note's distractors with the answers
+	// of other notes in the card's deck, for a total of DeckChoices.
+	FromDeck bool
+}
+
+var _ model.ModelController = &Multichoice{}
+var _ model.FuncMapper = &Multichoice{}
+
+// Type returns the string "multichoice", or "multichoice-deck" if FromDeck is
+// true, to identify this model handler's type.
+func (m *Multichoice) Type() string {
+	if m.FromDeck {
+		return "multichoice-deck"
+	}
+	return "multichoice"
+}
+
+// IframeScript returns JavaScript to run inside the iframe.
+func (m *Multichoice) IframeScript() []byte {
+	data, err := Asset("script.js")
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+var buttonMaps = map[int]studyview.ButtonMap{
+	QuestionFace: studyview.ButtonMap{
+		studyview.ButtonRight: {Name: "Check Answer", Enabled: true},
+	},
+	AnswerFace: studyview.ButtonMap{
+		studyview.ButtonRight: {Name: "Continue", Enabled: true},
+	},
+}
+
+// Buttons returns the initial button state
+func (m *Multichoice) Buttons(_ *model.Card, face int) (studyview.ButtonMap, error) {
+	buttons, ok := buttonMaps[face]
+	if !ok {
+		return nil, errors.Errorf("Invalid face %d", face)
+	}
+	return buttons, nil
+}
+
+const (
+	contextKeySelected = "selected"
+	contextKeyQuality  = "quality"
+)
+
+// Action responds to a card action, such as a button press
+func (m *Multichoice) Action(card *model.Card, face *int, startTime time.Time, payload interface{}) (bool, error) {
+	query := convertQuery(payload)
+	log.Debugf("Submit recieved for face %d: %v\n", *face, query)
+	button := studyview.Button(query.Submit)
+	buttons, ok := buttonMaps[*face]
+	if !ok {
+		return false, errors.Errorf("Unexpected face %d", *face)
+	}
+	if _, valid := buttons[button]; !valid {
+		return false, errors.Errorf("Unexpected button press %s", button)
+	}
+	switch *face {
+	case QuestionFace:
+		cs, err := m.choices(card)
+		if err != nil {
+			return false, err
+		}
+		selected := -1
+		if query.Choice != "" {
+			selected, err = strconv.Atoi(query.Choice)
+			if err != nil || selected < 0 || selected >= len(cs.Texts) {
+				return false, errors.Errorf("Invalid choice '%s'", query.Choice)
+			}
+		}
+		card.Context = map[string]interface{}{
+			contextKeySelected: selected,
+			contextKeyQuality:  int(grade(cs, selected, time.Now().Sub(startTime))),
+		}
+		*face++
+		return false, nil
+	case AnswerFace:
+		quality := flashback.AnswerQuality(contextInt(card, contextKeyQuality, int(flashback.AnswerBlackout)))
+		log.Debugf("Old schedule: Due %s, Interval: %s, Ease: %f, ReviewCount: %d\n", card.Due, card.Interval, card.EaseFactor, card.ReviewCount)
+		if err := model.Schedule(card, time.Now().Sub(startTime), quality); err != nil {
+			return false, err
+		}
+		log.Debugf("New schedule: Due %s, Interval: %s, Ease: %f, ReviewCount: %d\n", card.Due, card.Interval, card.EaseFactor, card.ReviewCount)
+		card.Context = nil // Clear the selected choice
+		return true, nil
+	}
+	return false, nil
+}
+
+// contextInt returns the integer stored in the card's context under key, or
+// def. Once the card has been stored, the context's numbers are float64s.
+func contextInt(card *model.Card, key string, def int) int {
+	if card == nil || card.Card == nil {
+		return def
+	}
+	ctx, _ := card.Context.(map[string]interface{})
+	switch v := ctx[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return def
+}
+
+// grade returns the quality of the answer, given the position of the selected
+// choice (-1 for none) and the time taken to answer.
+func grade(cs *choices, selected int, elapsed time.Duration) flashback.AnswerQuality {
+	switch {
+	case selected < 0:
+		return flashback.AnswerBlackout
+	case selected != cs.Correct:
+		// The correct choice is shown along with the answer.
+		return flashback.AnswerIncorrectRemembered
+	case elapsed <= quickAnswer:
+		return flashback.AnswerPerfect
+	}
+	return flashback.AnswerCorrect
+}
+
+// FuncMap returns a function map for multiple-choice templates.
+func (m *Multichoice) FuncMap(card *model.Card, face int) template.FuncMap {
+	return template.FuncMap{
+		"choices": func() (template.HTML, error) {
+			if card == nil {
+				// Template parsing
+				return "", nil
+			}
+			cs, err := m.choices(card)
+			if err != nil {
+				return "", err
+			}
+			selected := -1
+			if face == AnswerFace {
+				selected = contextInt(card, contextKeySelected, -1)
+			}
+			return cs.html(face, selected), nil
+		},
+	}
+}
+
+// choices returns the card's choices, in the order in which they are displayed
+// for the current review.
+func (m *Multichoice) choices(card *model.Card) (*choices, error) {
+	var answer string
+	distractors := make([]string, 0)
+	for _, name := range card.Fields() {
+		fv := card.FieldValue(name)
+		if fv == nil {
+			continue
+		}
+		switch {
+		case name == AnswerField:
+			answer = fv.Text
+		case strings.HasPrefix(name, ChoicePrefix) && strings.TrimSpace(fv.Text) != "":
+			distractors = append(distractors, fv.Text)
+		}
+	}
+	var others []string
+	if m.FromDeck && len(distractors) < DeckChoices {
+		var err error
+		// The model controller interface provides no context.
+		others, err = card.DeckFieldValues(context.TODO(), AnswerField)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch deck choices")
+		}
+	}
+	return newChoices(answer, distractors, others, DeckChoices-len(distractors), seed(card)), nil
+}
+
+// seed returns the shuffling seed for the current review of the card, so that
+// the choices are displayed in the same order on both faces, but in a
+// different order the next time the card is reviewed, as identified by the
+// time of the last review.
+func seed(card *model.Card) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s:%d", card.ID, card.LastReview.UnixNano())
+	return int64(h.Sum64())
+}
+
+// choices is the list of choices displayed for a card.
+type choices struct {
+	Texts []string
+	// Correct is the position of the correct choice.
+	Correct int
+}
+
+// newChoices returns the answer and distractors, along with up to n
+// distractors chosen from others, in an order determined by seed.
+func newChoices(answer string, distractors, others []string, n int, seed int64) *choices {
+	rnd := rand.New(rand.NewSource(seed))
+	texts := append([]string{answer}, distractors...)
+	if n > len(others) {
+		n = len(others)
+	}
+	for _, i := range rnd.Perm(len(others))[:n] {
+		texts = append(texts, others[i])
+	}
+	cs := &choices{Texts: make([]string, len(texts))}
+	for pos, i := range rnd.Perm(len(texts)) {
+		cs.Texts[pos] = texts[i]
+		if i == 0 {
+			cs.Correct = pos
+		}
+	}
+	return cs
+}
+
+// html renders the choices as radio buttons. On the answer face, they are
+// disabled, and the correct and selected choices are marked.
+func (cs *choices) html(face, selected int) template.HTML {
+	var buf bytes.Buffer
+	buf.WriteString(`<ol class="choices">`)
+	for pos, text := range cs.Texts {
+		class := "choice"
+		var attrs string
+		if face == AnswerFace {
+			attrs = ` disabled="disabled"`
+			if pos == cs.Correct {
+				class += " correct"
+			}
+			if pos == selected {
+				class += " selected"
+				attrs = ` checked="checked"` + attrs
+			}
+		}
+		// Field values are HTML, so are included as they are.
+		fmt.Fprintf(&buf, `<li class="%s"><label><input type="radio" name="choice" value="%d"%s/>%s</label></li>`, class, pos, attrs, text)
+	}
+	buf.WriteString(`</ol>`)
+	return template.HTML(buf.String())
+}
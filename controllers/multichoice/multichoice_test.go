@@ -0,0 +1,227 @@
+package multichoice
+
+import (
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/testy"
+
+	"github.com/FlashbackSRS/flashback"
+	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/model"
+	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
+)
+
+func TestType(t *testing.T) {
+	if typ := (&Multichoice{}).Type(); typ != "multichoice" {
+		t.Errorf("Unexpected type: %s", typ)
+	}
+	if typ := (&Multichoice{FromDeck: true}).Type(); typ != "multichoice-deck" {
+		t.Errorf("Unexpected type: %s", typ)
+	}
+}
+
+func TestButtons(t *testing.T) {
+	tests := []struct {
+		name     string
+		face     int
+		expected studyview.ButtonMap
+		err      string
+	}{
+		{
+			name: "question",
+			face: QuestionFace,
+			expected: studyview.ButtonMap{
+				"button-r": {Name: "Check Answer", Enabled: true},
+			},
+		},
+		{
+			name: "answer",
+			face: AnswerFace,
+			expected: studyview.ButtonMap{
+				"button-r": {Name: "Continue", Enabled: true},
+			},
+		},
+		{
+			name: "unsupported face",
+			face: -1,
+			err:  "Invalid face -1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := (&Multichoice{}).Buttons(nil, test.face)
+			testy.Error(t, test.err, err)
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestSeed(t *testing.T) {
+	card := &model.Card{Card: &fb.Card{ID: "card-foo.bar.0", ReviewCount: 3}}
+	first := seed(card)
+	// A merge may change the review count, but not the review
+	card.ReviewCount = 5
+	if seed(card) != first {
+		t.Error("Seed changed without a review")
+	}
+	card.LastReview = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	if seed(card) == first {
+		t.Error("Seed unchanged after a review")
+	}
+}
+
+func TestNewChoices(t *testing.T) {
+	t.Run("deterministic", func(t *testing.T) {
+		a := newChoices("uno", []string{"dos", "tres", "cuatro"}, nil, 0, 42)
+		b := newChoices("uno", []string{"dos", "tres", "cuatro"}, nil, 0, 42)
+		if d := diff.Interface(a, b); d != nil {
+			t.Error(d)
+		}
+		if a.Texts[a.Correct] != "uno" {
+			t.Errorf("Correct choice is %s", a.Texts[a.Correct])
+		}
+	})
+	t.Run("shuffled per seed", func(t *testing.T) {
+		orders := make(map[string]bool)
+		for seed := int64(0); seed < 10; seed++ {
+			cs := newChoices("uno", []string{"dos", "tres", "cuatro"}, nil, 0, seed)
+			if cs.Texts[cs.Correct] != "uno" {
+				t.Errorf("Correct choice is %s", cs.Texts[cs.Correct])
+			}
+			orders[cs.Texts[0]+cs.Texts[1]+cs.Texts[2]+cs.Texts[3]] = true
+		}
+		if len(orders) < 2 {
+			t.Error("Choices were never shuffled")
+		}
+	})
+	t.Run("from deck", func(t *testing.T) {
+		cs := newChoices("uno", []string{"dos"}, []string{"tres", "cuatro", "cinco"}, 2, 42)
+		if len(cs.Texts) != 4 {
+			t.Fatalf("Expected 4 choices, got %v", cs.Texts)
+		}
+		var found bool
+		for _, text := range cs.Texts {
+			found = found || text == "dos"
+		}
+		if !found {
+			t.Errorf("Note's own distractor missing: %v", cs.Texts)
+		}
+	})
+	t.Run("too few in deck", func(t *testing.T) {
+		cs := newChoices("uno", nil, []string{"dos"}, 3, 42)
+		if len(cs.Texts) != 2 {
+			t.Errorf("Expected 2 choices, got %v", cs.Texts)
+		}
+	})
+}
+
+func TestChoicesHTML(t *testing.T) {
+	cs := &choices{Texts: []string{"dos", "<b>uno</b>"}, Correct: 1}
+	tests := []struct {
+		name     string
+		face     int
+		selected int
+		expected template.HTML
+	}{
+		{
+			name:     "question",
+			face:     QuestionFace,
+			selected: -1,
+			expected: `<ol class="choices">` +
+				`<li class="choice"><label><input type="radio" name="choice" value="0"/>dos</label></li>` +
+				`<li class="choice"><label><input type="radio" name="choice" value="1"/><b>uno</b></label></li>` +
+				`</ol>`,
+		},
+		{
+			name:     "incorrect answer",
+			face:     AnswerFace,
+			selected: 0,
+			expected: `<ol class="choices">` +
+				`<li class="choice selected"><label><input type="radio" name="choice" value="0" checked="checked" disabled="disabled"/>dos</label></li>` +
+				`<li class="choice correct"><label><input type="radio" name="choice" value="1" disabled="disabled"/><b>uno</b></label></li>` +
+				`</ol>`,
+		},
+		{
+			name:     "no answer",
+			face:     AnswerFace,
+			selected: -1,
+			expected: `<ol class="choices">` +
+				`<li class="choice"><label><input type="radio" name="choice" value="0" disabled="disabled"/>dos</label></li>` +
+				`<li class="choice correct"><label><input type="radio" name="choice" value="1" disabled="disabled"/><b>uno</b></label></li>` +
+				`</ol>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := cs.html(test.face, test.selected)
+			if result != test.expected {
+				t.Errorf("Expected: %s\n  Actual: %s\n", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestGrade(t *testing.T) {
+	cs := &choices{Texts: []string{"dos", "uno"}, Correct: 1}
+	tests := []struct {
+		name     string
+		selected int
+		elapsed  time.Duration
+		expected flashback.AnswerQuality
+	}{
+		{name: "no choice", selected: -1, expected: flashback.AnswerBlackout},
+		{name: "incorrect", selected: 0, elapsed: time.Second, expected: flashback.AnswerIncorrectRemembered},
+		{name: "quick", selected: 1, elapsed: time.Second, expected: flashback.AnswerPerfect},
+		{name: "slow", selected: 1, elapsed: time.Minute, expected: flashback.AnswerCorrect},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := grade(cs, test.selected, test.elapsed); result != test.expected {
+				t.Errorf("Expected %d, got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestContextInt(t *testing.T) {
+	tests := []struct {
+		name     string
+		card     *model.Card
+		expected int
+	}{
+		{name: "nil card", expected: -1},
+		{name: "no context", card: &model.Card{Card: &fb.Card{}}, expected: -1},
+		{
+			name:     "int",
+			card:     &model.Card{Card: &fb.Card{Context: map[string]interface{}{contextKeySelected: 2}}},
+			expected: 2,
+		},
+		{
+			name:     "stored",
+			card:     &model.Card{Card: &fb.Card{Context: map[string]interface{}{contextKeySelected: float64(3)}}},
+			expected: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := contextInt(test.card, contextKeySelected, -1); result != test.expected {
+				t.Errorf("Expected %d, got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestActionErrors(t *testing.T) {
+	m := &Multichoice{}
+	face := AnswerFace
+	_, err := m.Action(&model.Card{Card: &fb.Card{}}, &face, time.Now(), &choiceQuery{Submit: "button-l"})
+	testy.Error(t, "Unexpected button press Left", err)
+	face = 3
+	_, err = m.Action(&model.Card{Card: &fb.Card{}}, &face, time.Now(), &choiceQuery{Submit: "button-r"})
+	testy.Error(t, "Unexpected face 3", err)
+}
@@ -0,0 +1,8 @@
+package multichoice
+
+type choiceQuery struct {
+	Submit string
+	// Choice is the position of the selected choice, as displayed, or empty
+	// if none was selected.
+	Choice string
+}
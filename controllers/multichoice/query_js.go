@@ -0,0 +1,18 @@
+// +build js
+
+package multichoice
+
+import "github.com/gopherjs/gopherjs/js"
+
+func convertQuery(query interface{}) *choiceQuery {
+	if jsQuery, ok := query.(*js.Object); ok {
+		q := &choiceQuery{
+			Submit: jsQuery.Get("submit").String(),
+		}
+		if choice := jsQuery.Get("choice"); choice != js.Undefined {
+			q.Choice = choice.String()
+		}
+		return q
+	}
+	return query.(*choiceQuery)
+}
@@ -0,0 +1,7 @@
+// +build !js
+
+package multichoice
+
+func convertQuery(query interface{}) *choiceQuery {
+	return query.(*choiceQuery)
+}
@@ -0,0 +1,14 @@
+package multichoice
+
+import "testing"
+
+func TestConvertQuery(t *testing.T) {
+	expected := &choiceQuery{
+		Submit: "button-r",
+		Choice: "2",
+	}
+	result := convertQuery(expected)
+	if result != expected {
+		t.Errorf("Unexpected result")
+	}
+}
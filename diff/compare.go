@@ -0,0 +1,339 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Options configures the normalization Compare applies to answers before they
+// are compared. The zero value requires an exact match, once whitespace is
+// trimmed and collapsed, text is normalized to Unicode NFC, and typographic
+// apostrophes (’) are replaced with plain ones.
+type Options struct {
+	// IgnoreCase ignores differences of case.
+	IgnoreCase bool
+	// IgnorePunctuation ignores punctuation, such as ¿, ! and commas.
+	IgnorePunctuation bool
+	// IgnoreAccents ignores accents and other diacritics, so that é matches e.
+	IgnoreAccents bool
+	// StripHTML removes HTML tags from the expected answer, and replaces
+	// entities with the characters they represent.
+	StripHTML bool
+	// Articles is a list of articles which are ignored when they begin an
+	// answer, such as "the" or "el". Elided articles, such as "l'", are
+	// matched including the apostrophe.
+	Articles []string
+	// Mode selects how the answers are compared and their differences shown.
+	Mode Mode
+	// Alternatives splits the expected answer on ';' and '|', and accepts
+	// any of the parts as correct.
+	Alternatives bool
+}
+
+// Mode is a mode of comparison.
+type Mode int
+
+// The modes of comparison
+const (
+	// CharacterMode compares answers character by character.
+	CharacterMode Mode = iota
+	// WordMode compares answers word by word, so that each word of the
+	// expected answer is either correct or incorrect.
+	WordMode
+	// AutoMode uses WordMode for expected answers of at least SentenceWords
+	// words, and CharacterMode otherwise.
+	AutoMode
+)
+
+// SentenceWords is the number of words from which AutoMode compares answers
+// word by word.
+const SentenceWords = 4
+
+// DefaultArticles is a list of common articles in English, Spanish, French,
+// Italian, Portuguese and German, suitable for Options.Articles.
+var DefaultArticles = []string{
+	"the", "a", "an",
+	"el", "la", "los", "las", "un", "una", "unos", "unas",
+	"le", "les", "l'", "une", "des",
+	"il", "lo", "gli", "uno",
+	"os", "um", "uma",
+	"der", "die", "das", "ein", "eine",
+}
+
+// Lenient is a set of Options which forgives the differences which rarely
+// matter in typed answers: case, HTML markup, and the choice between several
+// correct answers. Sentences are compared word by word.
+var Lenient = Options{
+	IgnoreCase:   true,
+	StripHTML:    true,
+	Alternatives: true,
+	Mode:         AutoMode,
+}
+
+// Result is the result of comparing a typed answer to the expected one.
+type Result struct {
+	// Equal is true if the answers match, after normalization.
+	Equal bool
+	// HTML shows the differences between the answers.
+	HTML string
+	// Score is the similarity of the normalized answers, from 0 for
+	// completely different, to 1 for equal, and may be used as partial
+	// credit. It is measured in characters or words, according to the mode.
+	Score float64
+	// Words is the breakdown of the words of the expected answer, when
+	// compared in WordMode.
+	Words []Word
+}
+
+// Word is a word of an expected answer, compared in WordMode.
+type Word struct {
+	Text    string `json:"text"`
+	Correct bool   `json:"correct"`
+}
+
+// Compare compares a typed answer to the expected one, after applying the
+// normalization configured by opts. When the expected answer has
+// alternatives, the result for the most similar one is returned.
+func Compare(expected, typed string, opts Options) *Result {
+	if opts.StripHTML {
+		expected = stripHTML(expected)
+	}
+	alternatives := []string{expected}
+	if opts.Alternatives {
+		alternatives = strings.FieldsFunc(expected, func(r rune) bool {
+			return r == ';' || r == '|'
+		})
+		if len(alternatives) == 0 {
+			alternatives = []string{expected}
+		}
+	}
+	typed = strings.TrimSpace(typed)
+	var best *Result
+	for _, alt := range alternatives {
+		alt = strings.TrimSpace(alt)
+		var result *Result
+		if opts.wordMode(alt) {
+			result = opts.compareWords(alt, typed)
+		} else {
+			result = opts.compareCharacters(alt, typed)
+		}
+		if best == nil || result.Score > best.Score {
+			best = result
+		}
+	}
+	return best
+}
+
+// wordMode returns true if expected is to be compared word by word.
+func (opts Options) wordMode(expected string) bool {
+	switch opts.Mode {
+	case WordMode:
+		return true
+	case AutoMode:
+		return len(strings.Fields(expected)) >= SentenceWords
+	}
+	return false
+}
+
+// compareCharacters compares expected and typed character by character.
+func (opts Options) compareCharacters(expected, typed string) *Result {
+	result := &Result{Score: similarity(opts.normalize(expected), opts.normalize(typed))}
+	result.Equal = result.Score == 1
+	if result.Equal {
+		result.HTML = opts.equalHTML(expected)
+		return result
+	}
+	dmp := diffmatchpatch.New()
+	result.HTML = PrettyHTML(dmp, dmp.DiffMain(expected, typed, false))
+	return result
+}
+
+// equalHTML returns the HTML for an expected answer which matched.
+func (opts Options) equalHTML(expected string) string {
+	if opts.StripHTML {
+		// expected is now plain text
+		expected = html.EscapeString(expected)
+	}
+	return fmt.Sprintf("<span class=\"good\">%s</span>", expected)
+}
+
+// word is a word of an answer.
+type word struct {
+	// text is the word as written.
+	text string
+	// key is the normalized word, by which it is compared.
+	key string
+}
+
+// words splits text into words. Words which normalize to nothing, such as
+// stray punctuation, are dropped, as is a leading article.
+func (opts Options) words(text string) []word {
+	words := make([]word, 0)
+	for _, w := range strings.Fields(text) {
+		if key := opts.normalize(w); key != "" {
+			words = append(words, word{text: w, key: key})
+		}
+	}
+	if len(words) > 1 {
+		for _, article := range opts.Articles {
+			if strings.EqualFold(words[0].key, article) {
+				return words[1:]
+			}
+		}
+	}
+	return words
+}
+
+// compareWords compares expected and typed word by word.
+func (opts Options) compareWords(expected, typed string) *Result {
+	expWords := opts.words(expected)
+	typedWords := opts.words(typed)
+	// Each distinct word is encoded as a rune, so that the word lists can be
+	// diffed as strings. The encoded runes begin in a private use plane.
+	const base = 0xF0000
+	codes := make(map[string]rune)
+	encode := func(words []word) []rune {
+		runes := make([]rune, len(words))
+		for i, w := range words {
+			code, ok := codes[w.key]
+			if !ok {
+				code = base + rune(len(codes))
+				codes[w.key] = code
+			}
+			runes[i] = code
+		}
+		return runes
+	}
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMainRunes(encode(expWords), encode(typedWords), false)
+
+	result := &Result{Words: make([]Word, 0, len(expWords))}
+	length := max(len(expWords), len(typedWords))
+	if length == 0 {
+		result.Score = 1
+	} else {
+		result.Score = 1 - float64(levenshtein(diffs))/float64(length)
+	}
+	result.Equal = result.Score == 1
+
+	var buf bytes.Buffer
+	var e, t int
+	for _, diff := range diffs {
+		n := utf8.RuneCountInString(diff.Text)
+		var texts []string
+		var class string
+		switch diff.Type {
+		case diffmatchpatch.DiffEqual:
+			class = "good"
+			for _, w := range expWords[e : e+n] {
+				texts = append(texts, w.text)
+				result.Words = append(result.Words, Word{Text: w.text, Correct: true})
+			}
+			e, t = e+n, t+n
+		case diffmatchpatch.DiffDelete:
+			class = "del"
+			for _, w := range expWords[e : e+n] {
+				texts = append(texts, w.text)
+				result.Words = append(result.Words, Word{Text: w.text})
+			}
+			e += n
+		case diffmatchpatch.DiffInsert:
+			class = "ins"
+			for _, w := range typedWords[t : t+n] {
+				texts = append(texts, w.text)
+			}
+			t += n
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(" ")
+		}
+		fmt.Fprintf(&buf, "<span class=\"%s\">%s</span>", class, html.EscapeString(strings.Join(texts, " ")))
+	}
+	if result.Equal {
+		result.HTML = opts.equalHTML(expected)
+	} else {
+		result.HTML = buf.String()
+	}
+	return result
+}
+
+// normalize returns text, normalized as configured by opts.
+func (opts Options) normalize(text string) string {
+	text = strings.Replace(norm.NFC.String(text), "’", "'", -1)
+	if opts.IgnoreCase {
+		text = strings.ToLower(text)
+	}
+	if opts.IgnoreAccents {
+		text = stripAccents(text)
+	}
+	if opts.IgnorePunctuation {
+		text = strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) && r != '\'' {
+				return -1
+			}
+			return r
+		}, text)
+	}
+	text = strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")
+	if len(opts.Articles) > 0 {
+		text = stripArticle(text, opts.Articles)
+	}
+	if opts.IgnorePunctuation {
+		// Apostrophes are kept until articles are removed, for elisions.
+		text = strings.Replace(text, "'", "", -1)
+	}
+	return text
+}
+
+// stripHTML returns the text content of an HTML fragment.
+func stripHTML(text string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(text))
+	if err != nil {
+		// Reading from a string never fails
+		return text
+	}
+	return doc.Text()
+}
+
+// stripAccents removes combining marks, such as accents, from text.
+func stripAccents(text string) string {
+	var buf bytes.Buffer
+	for _, r := range norm.NFD.String(text) {
+		if !unicode.Is(unicode.Mn, r) {
+			buf.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(buf.String())
+}
+
+// stripArticle removes a leading article from text, unless text consists of
+// nothing else.
+func stripArticle(text string, articles []string) string {
+	for _, prefix := range articles {
+		if !strings.HasSuffix(prefix, "'") {
+			prefix += " "
+		}
+		if len(text) > len(prefix) && strings.EqualFold(text[:len(prefix)], prefix) {
+			return strings.TrimSpace(text[len(prefix):])
+		}
+	}
+	return text
+}
+
+// similarity returns the similarity of two strings, from 0 to 1, based on
+// the Levenshtein distance between them.
+func similarity(text1, text2 string) float64 {
+	length := max(utf8.RuneCountInString(text1), utf8.RuneCountInString(text2))
+	if length == 0 {
+		return 1
+	}
+	return 1 - float64(Distance(text1, text2))/float64(length)
+}
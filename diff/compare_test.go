@@ -0,0 +1,220 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/flimzy/diff"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		typed    string
+		opts     Options
+		equal    bool
+		html     string
+		score    float64
+	}{
+		{
+			name:     "exact",
+			expected: "el perro",
+			typed:    " el perro",
+			equal:    true,
+			html:     `<span class="good">el perro</span>`,
+			score:    1,
+		},
+		{
+			name:     "case",
+			expected: "Él",
+			typed:    "él",
+			opts:     Options{IgnoreCase: true},
+			equal:    true,
+			html:     `<span class="good">Él</span>`,
+			score:    1,
+		},
+		{
+			name:     "case not ignored",
+			expected: "Él",
+			typed:    "él",
+			html:     `<span class="del">É</span><span class="ins">é</span><span class="good">l</span>`,
+			score:    0.5,
+		},
+		{
+			name:     "accents",
+			expected: "Él",
+			typed:    "el",
+			opts:     Options{IgnoreCase: true, IgnoreAccents: true},
+			equal:    true,
+			html:     `<span class="good">Él</span>`,
+			score:    1,
+		},
+		{
+			name:     "punctuation",
+			expected: "¡Hola, amigo!",
+			typed:    "hola amigo",
+			opts:     Options{IgnoreCase: true, IgnorePunctuation: true},
+			equal:    true,
+			html:     `<span class="good">¡Hola, amigo!</span>`,
+			score:    1,
+		},
+		{
+			name:     "html",
+			expected: "pe<b>rr</b>o &amp; gato<br>",
+			typed:    "perro & gato",
+			opts:     Options{StripHTML: true},
+			equal:    true,
+			html:     `<span class="good">perro &amp; gato</span>`,
+			score:    1,
+		},
+		{
+			name:     "articles",
+			expected: "la biblioteca",
+			typed:    "biblioteca",
+			opts:     Options{Articles: DefaultArticles},
+			equal:    true,
+			html:     `<span class="good">la biblioteca</span>`,
+			score:    1,
+		},
+		{
+			name:     "elided article",
+			expected: "l’école",
+			typed:    "ecole",
+			opts:     Options{IgnoreAccents: true, IgnorePunctuation: true, Articles: DefaultArticles},
+			equal:    true,
+			html:     `<span class="good">l’école</span>`,
+			score:    1,
+		},
+		{
+			name:     "article alone",
+			expected: "la",
+			typed:    "",
+			opts:     Options{Articles: DefaultArticles},
+			html:     `<span class="del">la</span>`,
+			score:    0,
+		},
+		{
+			name:     "alternatives",
+			expected: "car; automobile|auto",
+			typed:    "automobile",
+			opts:     Options{Alternatives: true},
+			equal:    true,
+			html:     `<span class="good">automobile</span>`,
+			score:    1,
+		},
+		{
+			name:     "closest alternative",
+			expected: "car; automobile",
+			typed:    "automobil",
+			opts:     Options{Alternatives: true},
+			html:     `<span class="good">automobil</span><span class="del">e</span>`,
+			score:    0.9,
+		},
+		{
+			name:     "alternatives not split",
+			expected: "car; auto",
+			typed:    "auto",
+			html:     `<span class="del">car; </span><span class="good">auto</span>`,
+			score:    4.0 / 9,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Compare(test.expected, test.typed, test.opts)
+			if result.Equal != test.equal {
+				t.Errorf("Unexpected equality: %t", result.Equal)
+			}
+			if result.HTML != test.html {
+				t.Errorf("Unexpected HTML: %s", result.HTML)
+			}
+			if result.Score != test.score {
+				t.Errorf("Unexpected score: %f", result.Score)
+			}
+		})
+	}
+}
+
+func TestCompareWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		typed    string
+		opts     Options
+		equal    bool
+		html     string
+		score    float64
+		words    []Word
+	}{
+		{
+			name:     "equal",
+			expected: "El perro come.",
+			typed:    "el perro come",
+			opts:     Options{Mode: WordMode, IgnoreCase: true, IgnorePunctuation: true},
+			equal:    true,
+			html:     `<span class="good">El perro come.</span>`,
+			score:    1,
+			words:    []Word{{Text: "El", Correct: true}, {Text: "perro", Correct: true}, {Text: "come.", Correct: true}},
+		},
+		{
+			name:     "wrong word",
+			expected: "el perro come carne",
+			typed:    "el gato come carne",
+			opts:     Options{Mode: WordMode},
+			html:     `<span class="good">el</span> <span class="del">perro</span> <span class="ins">gato</span> <span class="good">come carne</span>`,
+			score:    0.75,
+			words:    []Word{{Text: "el", Correct: true}, {Text: "perro"}, {Text: "come", Correct: true}, {Text: "carne", Correct: true}},
+		},
+		{
+			name:     "missing word",
+			expected: "the <b>big</b> dog",
+			typed:    "dog",
+			opts:     Options{Mode: WordMode, StripHTML: true, Articles: DefaultArticles},
+			html:     `<span class="del">big</span> <span class="good">dog</span>`,
+			score:    0.5,
+			words:    []Word{{Text: "big"}, {Text: "dog", Correct: true}},
+		},
+		{
+			name:     "auto, short answer",
+			expected: "el perro",
+			typed:    "el pero",
+			opts:     Options{Mode: AutoMode},
+			html:     `<span class="good">el per</span><span class="del">r</span><span class="good">o</span>`,
+			score:    0.875,
+		},
+		{
+			name:     "auto, sentence",
+			expected: "yo tengo un perro",
+			typed:    "yo tengo un pero",
+			opts:     Options{Mode: AutoMode},
+			html:     `<span class="good">yo tengo un</span> <span class="del">perro</span> <span class="ins">pero</span>`,
+			score:    0.75,
+			words:    []Word{{Text: "yo", Correct: true}, {Text: "tengo", Correct: true}, {Text: "un", Correct: true}, {Text: "perro"}},
+		},
+		{
+			name:     "escaped",
+			expected: "a < b & c",
+			typed:    "a > b & c",
+			opts:     Options{Mode: WordMode},
+			html:     `<span class="good">a</span> <span class="del">&lt;</span> <span class="ins">&gt;</span> <span class="good">b &amp; c</span>`,
+			score:    0.8,
+			words:    []Word{{Text: "a", Correct: true}, {Text: "<"}, {Text: "b", Correct: true}, {Text: "&", Correct: true}, {Text: "c", Correct: true}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Compare(test.expected, test.typed, test.opts)
+			if result.Equal != test.equal {
+				t.Errorf("Unexpected equality: %t", result.Equal)
+			}
+			if result.HTML != test.html {
+				t.Errorf("Unexpected HTML: %s", result.HTML)
+			}
+			if result.Score != test.score {
+				t.Errorf("Unexpected score: %f", result.Score)
+			}
+			if d := diff.Interface(test.words, result.Words); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
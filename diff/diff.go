@@ -2,24 +2,56 @@ package diff
 
 import (
 	"bytes"
-	"fmt"
 	"html"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 // Diff finds the differences between two strings, returned as HTML, and a
 // boolean indicating if the strings are the same.
+//
+// Diff compares the strings as by Compare with the zero Options, so that they
+// need match only after their whitespace, Unicode composition and apostrophes
+// are normalized. Use Compare for a more lenient comparison.
 func Diff(text1, text2 string) (equal bool, diff string) {
-	text1 = strings.TrimSpace(text1)
-	text2 = strings.TrimSpace(text2)
-	if text1 == text2 {
-		return true, fmt.Sprintf("<span class=\"good\">%s</span>", text1)
-	}
+	result := Compare(text1, text2, Options{})
+	return result.Equal, result.HTML
+}
+
+// Distance returns the Levenshtein distance, in characters, between two
+// strings, after trimming surrounding whitespace.
+//
+// diffmatchpatch.DiffLevenshtein is not used, as it counts bytes.
+func Distance(text1, text2 string) int {
 	dmp := diffmatchpatch.New()
-	diffs := dmp.DiffMain(text1, text2, false)
-	return false, PrettyHTML(dmp, diffs)
+	return levenshtein(dmp.DiffMain(strings.TrimSpace(text1), strings.TrimSpace(text2), false))
+}
+
+// levenshtein returns the Levenshtein distance, in runes, represented by diffs.
+func levenshtein(diffs []diffmatchpatch.Diff) int {
+	var distance, insertions, deletions int
+	for _, diff := range diffs {
+		n := utf8.RuneCountInString(diff.Text)
+		switch diff.Type {
+		case diffmatchpatch.DiffInsert:
+			insertions += n
+		case diffmatchpatch.DiffDelete:
+			deletions += n
+		case diffmatchpatch.DiffEqual:
+			distance += max(insertions, deletions)
+			insertions, deletions = 0, 0
+		}
+	}
+	return distance + max(insertions, deletions)
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
 
 // PrettyHTML produces pretty HTML for display.
@@ -36,3 +36,23 @@ func TestDiff(t *testing.T) {
 		})
 	}
 }
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name         string
+		text1, text2 string
+		expected     int
+	}{
+		{name: "equal", text1: "foo", text2: " foo ", expected: 0},
+		{name: "one typo", text1: "enseigner", text2: "enseignr", expected: 1},
+		{name: "different", text1: "enseigner", text2: "ensignier", expected: 2},
+		{name: "multibyte", text1: "él", text2: "el", expected: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if d := Distance(test.text1, test.text2); d != test.expected {
+				t.Errorf("Expected %d, got %d", test.expected, d)
+			}
+		})
+	}
+}
@@ -0,0 +1,490 @@
+// Package mathml converts TeX math to MathML, so that math may be displayed
+// by the browser itself, without MathJax or any network access.
+//
+// The common subset of TeX math used in flash cards is supported: scripts,
+// fractions, roots, Greek letters and symbols, functions, accents, fonts,
+// \left and \right delimiters, \text, and the matrix, cases and aligned
+// environments. Unsupported commands are shown as MathML <merror> elements,
+// containing the command as written.
+package mathml
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Convert converts TeX math, such as would appear between $ delimiters, to a
+// MathML <math> element. If display is true, the math is displayed as a
+// block, as between $$ delimiters.
+func Convert(tex string, display bool) string {
+	p := &parser{src: []rune(tex), display: display}
+	var attr string
+	if display {
+		attr = ` display="block"`
+	}
+	return fmt.Sprintf(`<math xmlns="http://www.w3.org/1998/Math/MathML"%s>%s</math>`, attr, mrow(p.parseAll()))
+}
+
+type parser struct {
+	src     []rune
+	pos     int
+	display bool
+}
+
+func (p *parser) atEnd() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() rune {
+	if p.atEnd() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for !p.atEnd() && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// peekCommand returns the name of the command at the current position, if
+// any, without consuming it.
+func (p *parser) peekCommand() string {
+	if p.peek() != '\\' || p.pos+1 >= len(p.src) {
+		return ""
+	}
+	end := p.pos + 1
+	for end < len(p.src) && isLetter(p.src[end]) {
+		end++
+	}
+	if end == p.pos+1 {
+		// A control symbol, such as \{
+		end++
+	}
+	return string(p.src[p.pos+1 : end])
+}
+
+// command consumes and returns the command at the current position.
+func (p *parser) command() string {
+	name := p.peekCommand()
+	p.pos += 1 + len([]rune(name))
+	return name
+}
+
+// isLetter reports whether r may be part of a command name.
+func isLetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// parseAll parses the whole source, skipping any stray closing braces and
+// environment or delimiter ends.
+func (p *parser) parseAll() []string {
+	var items []string
+	for {
+		items = append(items, p.parseRow(false)...)
+		if p.atEnd() {
+			return items
+		}
+		if p.peek() == '}' {
+			p.pos++
+			continue
+		}
+		items = append(items, unsupported(`\`+p.command()))
+	}
+}
+
+// parseRow parses a sequence of atoms, up to the end of the enclosing group,
+// \left ... \right pair or environment, or if inTable is true, the end of the
+// table cell.
+func (p *parser) parseRow(inTable bool) []string {
+	var items []string
+	for {
+		p.skipSpace()
+		if p.atEnd() || p.peek() == '}' {
+			return items
+		}
+		switch p.peekCommand() {
+		case "right", "end":
+			return items
+		case `\`:
+			if inTable {
+				return items
+			}
+			p.command()
+			items = append(items, `<mspace linebreak="newline"/>`)
+			continue
+		}
+		if p.peek() == '&' {
+			if inTable {
+				return items
+			}
+			p.pos++
+			continue
+		}
+		items = append(items, p.parseScripted())
+	}
+}
+
+// parseGroup parses a group in braces, which the current position is at.
+func (p *parser) parseGroup() string {
+	p.pos++ // {
+	row := p.parseRow(false)
+	if p.peek() == '}' {
+		p.pos++
+	}
+	return mrow(row)
+}
+
+// rawGroup returns the unparsed content of a group in braces, or the next
+// character if there is no group.
+func (p *parser) rawGroup() string {
+	p.skipSpace()
+	if p.peek() != '{' {
+		if p.atEnd() {
+			return ""
+		}
+		p.pos++
+		return string(p.src[p.pos-1])
+	}
+	start := p.pos + 1
+	depth := 0
+	for ; !p.atEnd(); p.pos++ {
+		switch p.src[p.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		if depth == 0 {
+			p.pos++
+			return string(p.src[start : p.pos-1])
+		}
+	}
+	return string(p.src[start:])
+}
+
+// parseArg parses the argument of a command or script: a group, or a single
+// character or command.
+func (p *parser) parseArg() string {
+	p.skipSpace()
+	switch r := p.peek(); {
+	case r == '{':
+		return p.parseGroup()
+	case r >= '0' && r <= '9':
+		p.pos++
+		return mn(string(r))
+	}
+	base, _ := p.parseAtom()
+	return base
+}
+
+// parseScripted parses an atom, and any sub- and superscripts.
+func (p *parser) parseScripted() string {
+	base, limits := p.parseAtom()
+	var sub string
+	// Primes are superscripts, which precede any other superscript.
+	var sups []string
+scripts:
+	for {
+		p.skipSpace()
+		switch p.peek() {
+		case '_':
+			p.pos++
+			sub = p.parseArg()
+		case '^':
+			p.pos++
+			sups = append(sups, p.parseArg())
+		case '\'':
+			p.pos++
+			sups = append(sups, mo("′"))
+		default:
+			break scripts
+		}
+	}
+	var sup string
+	if len(sups) > 0 {
+		sup = mrow(sups)
+	}
+	if base == "" && (sub != "" || sup != "") {
+		base = "<mrow></mrow>"
+	}
+	under, over := "msub", "msup"
+	if limits && p.display {
+		under, over = "munder", "mover"
+	}
+	switch {
+	case sub != "" && sup != "":
+		if under == "munder" {
+			return fmt.Sprintf("<munderover>%s%s%s</munderover>", base, sub, sup)
+		}
+		return fmt.Sprintf("<msubsup>%s%s%s</msubsup>", base, sub, sup)
+	case sub != "":
+		return fmt.Sprintf("<%s>%s%s</%[1]s>", under, base, sub)
+	case sup != "":
+		return fmt.Sprintf("<%s>%s%s</%[1]s>", over, base, sup)
+	}
+	return base
+}
+
+// parseAtom parses a single character, number, group or command. limits is
+// true for operators, such as \sum, whose scripts are placed above and below
+// them in display math.
+func (p *parser) parseAtom() (atom string, limits bool) {
+	p.skipSpace()
+	r := p.peek()
+	switch {
+	case r == '{':
+		return p.parseGroup(), false
+	case r == '\\':
+		return p.parseCommand()
+	case r == '^' || r == '_' || r == 0:
+		return "", false
+	case r >= '0' && r <= '9' || r == '.' && p.pos+1 < len(p.src) && unicode.IsDigit(p.src[p.pos+1]):
+		start := p.pos
+		for !p.atEnd() && (unicode.IsDigit(p.peek()) || p.peek() == '.' && p.pos+1 < len(p.src) && unicode.IsDigit(p.src[p.pos+1])) {
+			p.pos++
+		}
+		return mn(string(p.src[start:p.pos])), false
+	case unicode.IsLetter(r):
+		p.pos++
+		return mi(string(r)), false
+	case r == '~':
+		p.pos++
+		return space("0.25em"), false
+	}
+	p.pos++
+	return mo(string(r)), false
+}
+
+// parseCommand parses a command, and its arguments.
+func (p *parser) parseCommand() (string, bool) {
+	name := p.command()
+	if s, ok := greek[name]; ok {
+		return mi(s), false
+	}
+	if s, ok := identifiers[name]; ok {
+		return mi(s), false
+	}
+	if s, ok := operators[name]; ok {
+		return mo(s), false
+	}
+	if s, ok := bigOperators[name]; ok {
+		return mo(s), true
+	}
+	if w, ok := spaces[name]; ok {
+		return space(w), false
+	}
+	if _, ok := functions[name]; ok {
+		return mi(name), false
+	}
+	if _, ok := limitFunctions[name]; ok {
+		return mi(name), true
+	}
+	if s, ok := accents[name]; ok {
+		return fmt.Sprintf(`<mover accent="true">%s<mo stretchy="false">%s</mo></mover>`, p.parseArg(), s), false
+	}
+	if s, ok := wideAccents[name]; ok {
+		return fmt.Sprintf(`<mover accent="true">%s<mo>%s</mo></mover>`, p.parseArg(), s), false
+	}
+	if v, ok := variants[name]; ok {
+		return fmt.Sprintf(`<mi mathvariant="%s">%s</mi>`, v, html.EscapeString(p.rawGroup())), false
+	}
+	switch name {
+	case "{", "}", "|", "#", "$", "%", "&", "_":
+		if name == "|" {
+			return mo("‖"), false
+		}
+		return mo(name), false
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num := p.parseArg()
+		return fmt.Sprintf("<mfrac>%s%s</mfrac>", num, p.parseArg()), false
+	case "binom":
+		n := p.parseArg()
+		return fmt.Sprintf(`<mrow><mo>(</mo><mfrac linethickness="0">%s%s</mfrac><mo>)</mo></mrow>`, n, p.parseArg()), false
+	case "sqrt":
+		p.skipSpace()
+		if p.peek() == '[' {
+			p.pos++
+			start := p.pos
+			for !p.atEnd() && p.peek() != ']' {
+				p.pos++
+			}
+			index := (&parser{src: p.src[start:p.pos], display: p.display}).parseAll()
+			p.pos++ // ]
+			return fmt.Sprintf("<mroot>%s%s</mroot>", p.parseArg(), mrow(index)), false
+		}
+		return fmt.Sprintf("<msqrt>%s</msqrt>", p.parseArg()), false
+	case "underline":
+		return fmt.Sprintf(`<munder accentunder="true">%s<mo>_</mo></munder>`, p.parseArg()), false
+	case "text", "textrm", "textit", "textbf", "mbox", "textnormal":
+		return fmt.Sprintf("<mtext>%s</mtext>", html.EscapeString(p.rawGroup())), false
+	case "operatorname":
+		return mi(p.rawGroup()), false
+	case "left":
+		return p.parseFenced(), false
+	case "big", "Big", "bigg", "Bigg", "bigl", "Bigl", "biggl", "Biggl", "bigr", "Bigr", "biggr", "Biggr", "bigm", "Bigm":
+		return fmt.Sprintf(`<mo stretchy="false">%s</mo>`, html.EscapeString(p.delimiter())), false
+	case "not":
+		next, _ := p.parseAtom()
+		if strings.HasPrefix(next, "<mo>") {
+			// Combined with a long solidus overlay, as in ≠
+			return strings.TrimSuffix(next, "</mo>") + "\u0338</mo>", false
+		}
+		return next, false
+	case "begin":
+		return p.parseEnvironment(), false
+	case "displaystyle", "textstyle", "scriptstyle", "limits", "nolimits":
+		return "", false
+	}
+	return unsupported(`\` + name), false
+}
+
+// delimiter parses a delimiter, as follows \left or \right, and returns its
+// character, or "" for the empty delimiter, '.'.
+func (p *parser) delimiter() string {
+	p.skipSpace()
+	if p.peek() == '\\' {
+		name := p.command()
+		if s, ok := delimiters[name]; ok {
+			return s
+		}
+		return name
+	}
+	if p.atEnd() {
+		return ""
+	}
+	r := p.peek()
+	p.pos++
+	if r == '.' {
+		return ""
+	}
+	return string(r)
+}
+
+// parseFenced parses \left ... \right, after the \left.
+func (p *parser) parseFenced() string {
+	open := p.delimiter()
+	row := p.parseRow(false)
+	var closing string
+	if p.peekCommand() == "right" {
+		p.command()
+		closing = p.delimiter()
+	}
+	items := []string{fence(open)}
+	items = append(items, row...)
+	return mrow(append(items, fence(closing)))
+}
+
+func fence(delim string) string {
+	if delim == "" {
+		return ""
+	}
+	return fmt.Sprintf(`<mo fence="true" stretchy="true">%s</mo>`, html.EscapeString(delim))
+}
+
+// environments are the supported environments, with their delimiters and
+// column alignment.
+var environments = map[string]struct {
+	open, close, align string
+}{
+	"matrix":   {},
+	"pmatrix":  {"(", ")", ""},
+	"bmatrix":  {"[", "]", ""},
+	"Bmatrix":  {"{", "}", ""},
+	"vmatrix":  {"|", "|", ""},
+	"Vmatrix":  {"‖", "‖", ""},
+	"cases":    {"{", "", "left"},
+	"array":    {},
+	"aligned":  {"", "", "right left"},
+	"align":    {"", "", "right left"},
+	"align*":   {"", "", "right left"},
+	"gathered": {},
+}
+
+// parseEnvironment parses \begin{env} ... \end{env}, after the \begin.
+func (p *parser) parseEnvironment() string {
+	name := p.rawGroup()
+	env, ok := environments[name]
+	if name == "array" {
+		// The column specification
+		p.rawGroup()
+	}
+	var rows [][]string
+	var cells []string
+	for {
+		cells = append(cells, mrow(p.parseRow(true)))
+		if p.peek() == '&' {
+			p.pos++
+			continue
+		}
+		rows = append(rows, cells)
+		cells = nil
+		if p.peekCommand() == `\` {
+			p.command()
+			continue
+		}
+		break
+	}
+	if p.peekCommand() == "end" {
+		p.command()
+		p.rawGroup()
+	}
+	var buf bytes.Buffer
+	buf.WriteString("<mtable")
+	if env.align != "" {
+		fmt.Fprintf(&buf, ` columnalign="%s"`, env.align)
+	}
+	buf.WriteString(">")
+	for _, row := range rows {
+		buf.WriteString("<mtr>")
+		for _, cell := range row {
+			fmt.Fprintf(&buf, "<mtd>%s</mtd>", cell)
+		}
+		buf.WriteString("</mtr>")
+	}
+	buf.WriteString("</mtable>")
+	table := mrow([]string{fence(env.open), buf.String(), fence(env.close)})
+	if !ok {
+		return mrow([]string{unsupported(`\begin{` + name + `}`), table})
+	}
+	return table
+}
+
+func mrow(items []string) string {
+	var nonEmpty []string
+	for _, item := range items {
+		if item != "" {
+			nonEmpty = append(nonEmpty, item)
+		}
+	}
+	if len(nonEmpty) == 1 {
+		return nonEmpty[0]
+	}
+	return "<mrow>" + strings.Join(nonEmpty, "") + "</mrow>"
+}
+
+func mi(s string) string {
+	return "<mi>" + html.EscapeString(s) + "</mi>"
+}
+
+func mn(s string) string {
+	return "<mn>" + html.EscapeString(s) + "</mn>"
+}
+
+func mo(s string) string {
+	return "<mo>" + html.EscapeString(s) + "</mo>"
+}
+
+func space(width string) string {
+	return fmt.Sprintf(`<mspace width="%s"/>`, width)
+}
+
+// unsupported returns the fallback for an unsupported command: the command as
+// written, marked as an error.
+func unsupported(tex string) string {
+	return fmt.Sprintf(`<merror><mtext>%s</mtext></merror>`, html.EscapeString(tex))
+}
@@ -0,0 +1,122 @@
+package mathml
+
+import "testing"
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		tex      string
+		display  bool
+		expected string
+	}{
+		{
+			name:     "scripts",
+			tex:      "x^2 + y_1",
+			expected: `<mrow><msup><mi>x</mi><mn>2</mn></msup><mo>+</mo><msub><mi>y</mi><mn>1</mn></msub></mrow>`,
+		},
+		{
+			name:     "single character script",
+			tex:      "x^23",
+			expected: `<mrow><msup><mi>x</mi><mn>2</mn></msup><mn>3</mn></mrow>`,
+		},
+		{
+			name:     "numbers",
+			tex:      "3.14 x_{10}",
+			expected: `<mrow><mn>3.14</mn><msub><mi>x</mi><mn>10</mn></msub></mrow>`,
+		},
+		{
+			name:     "fraction",
+			tex:      `\frac{a}{b}`,
+			expected: `<mfrac><mi>a</mi><mi>b</mi></mfrac>`,
+		},
+		{
+			name:     "roots",
+			tex:      `\sqrt{2} \sqrt[3]{x}`,
+			expected: `<mrow><msqrt><mn>2</mn></msqrt><mroot><mi>x</mi><mn>3</mn></mroot></mrow>`,
+		},
+		{
+			name:     "inline sum",
+			tex:      `\sum_{i=1}^{n} i`,
+			expected: `<mrow><msubsup><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></msubsup><mi>i</mi></mrow>`,
+		},
+		{
+			name:     "display sum",
+			tex:      `\sum_{i=1}^{n} i`,
+			display:  true,
+			expected: `<mrow><munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover><mi>i</mi></mrow>`,
+		},
+		{
+			name:     "limit",
+			tex:      `\lim_{x \to 0} \sin x`,
+			display:  true,
+			expected: `<mrow><munder><mi>lim</mi><mrow><mi>x</mi><mo>→</mo><mn>0</mn></mrow></munder><mi>sin</mi><mi>x</mi></mrow>`,
+		},
+		{
+			name:     "greek and symbols",
+			tex:      `\alpha \leq \pi \cdot \infty`,
+			expected: `<mrow><mi>α</mi><mo>≤</mo><mi>π</mi><mo>⋅</mo><mi>∞</mi></mrow>`,
+		},
+		{
+			name:     "fences",
+			tex:      `\left( \frac{1}{2} \right.`,
+			expected: `<mrow><mo fence="true" stretchy="true">(</mo><mfrac><mn>1</mn><mn>2</mn></mfrac></mrow>`,
+		},
+		{
+			name:     "matrix",
+			tex:      `\begin{pmatrix} a & b \\ c & d \end{pmatrix}`,
+			expected: `<mrow><mo fence="true" stretchy="true">(</mo><mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable><mo fence="true" stretchy="true">)</mo></mrow>`,
+		},
+		{
+			name:     "cases",
+			tex:      `|x| = \begin{cases} x & x \ge 0 \\ -x & \text{otherwise} \end{cases}`,
+			expected: `<mrow><mo>|</mo><mi>x</mi><mo>|</mo><mo>=</mo><mrow><mo fence="true" stretchy="true">{</mo><mtable columnalign="left"><mtr><mtd><mi>x</mi></mtd><mtd><mrow><mi>x</mi><mo>≥</mo><mn>0</mn></mrow></mtd></mtr><mtr><mtd><mrow><mo>-</mo><mi>x</mi></mrow></mtd><mtd><mtext>otherwise</mtext></mtd></mtr></mtable></mrow></mrow>`,
+		},
+		{
+			name:     "text and fonts",
+			tex:      `\text{if } x < 0, \mathbb{R}`,
+			expected: `<mrow><mtext>if </mtext><mi>x</mi><mo>&lt;</mo><mn>0</mn><mo>,</mo><mi mathvariant="double-struck">R</mi></mrow>`,
+		},
+		{
+			name:     "accents",
+			tex:      `\vec{v} \overline{AB}`,
+			expected: `<mrow><mover accent="true"><mi>v</mi><mo stretchy="false">→</mo></mover><mover accent="true"><mrow><mi>A</mi><mi>B</mi></mrow><mo>¯</mo></mover></mrow>`,
+		},
+		{
+			name:     "primes",
+			tex:      `f''(x)`,
+			expected: `<mrow><msup><mi>f</mi><mrow><mo>′</mo><mo>′</mo></mrow></msup><mo>(</mo><mi>x</mi><mo>)</mo></mrow>`,
+		},
+		{
+			name:     "negation",
+			tex:      `a \not= b`,
+			expected: "<mrow><mi>a</mi><mo>=\u0338</mo><mi>b</mi></mrow>",
+		},
+		{
+			name:     "unsupported command",
+			tex:      `\color{red} x`,
+			expected: `<mrow><merror><mtext>\color</mtext></merror><mrow><mi>r</mi><mi>e</mi><mi>d</mi></mrow><mi>x</mi></mrow>`,
+		},
+		{
+			name:     "unsupported environment",
+			tex:      `\begin{foo} a \end{foo}`,
+			expected: `<mrow><merror><mtext>\begin{foo}</mtext></merror><mtable><mtr><mtd><mi>a</mi></mtd></mtr></mtable></mrow>`,
+		},
+		{
+			name:     "stray delimiters",
+			tex:      `a} \right) b`,
+			expected: `<mrow><mi>a</mi><merror><mtext>\right</mtext></merror><mo>)</mo><mi>b</mi></mrow>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attr := ""
+			if test.display {
+				attr = ` display="block"`
+			}
+			expected := `<math xmlns="http://www.w3.org/1998/Math/MathML"` + attr + ">" + test.expected + "</math>"
+			if result := Convert(test.tex, test.display); result != expected {
+				t.Errorf("Unexpected result:\n%s", result)
+			}
+		})
+	}
+}
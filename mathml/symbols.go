@@ -0,0 +1,104 @@
+package mathml
+
+// greek maps the commands for Greek letters to their characters.
+var greek = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ",
+	"varepsilon": "ε", "zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ",
+	"iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ",
+	"omicron": "ο", "pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ",
+	"sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+	"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ",
+	"Pi": "Π", "Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ",
+	"Omega": "Ω",
+}
+
+// identifiers maps the commands for symbols which are identifiers, rather
+// than operators, to their characters.
+var identifiers = map[string]string{
+	"infty": "∞", "partial": "∂", "hbar": "ℏ", "ell": "ℓ", "emptyset": "∅",
+	"varnothing": "∅", "aleph": "ℵ", "Re": "ℜ", "Im": "ℑ", "wp": "℘",
+	"imath": "ı", "jmath": "ȷ",
+}
+
+// operators maps the commands for operators, relations, arrows and other
+// symbols to their characters.
+var operators = map[string]string{
+	"times": "×", "cdot": "⋅", "pm": "±", "mp": "∓", "div": "÷", "ast": "∗",
+	"star": "⋆", "circ": "∘", "bullet": "∙", "oplus": "⊕", "otimes": "⊗",
+	"le": "≤", "leq": "≤", "ge": "≥", "geq": "≥", "ne": "≠", "neq": "≠",
+	"ll": "≪", "gg": "≫", "approx": "≈", "equiv": "≡", "sim": "∼",
+	"simeq": "≃", "cong": "≅", "propto": "∝", "mid": "∣", "parallel": "∥",
+	"perp": "⊥",
+	"in":   "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆",
+	"supset": "⊃", "supseteq": "⊇", "cup": "∪", "cap": "∩", "setminus": "∖",
+	"forall": "∀", "exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬",
+	"land": "∧", "wedge": "∧", "lor": "∨", "vee": "∨", "nabla": "∇",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺", "mapsto": "↦",
+	"longrightarrow": "⟶", "longleftarrow": "⟵", "uparrow": "↑",
+	"downarrow": "↓",
+	"ldots":     "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"angle": "∠", "triangle": "△", "degree": "°", "prime": "′",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉", "vert": "|", "Vert": "‖",
+	"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+}
+
+// bigOperators maps the commands for operators whose scripts are placed
+// above and below them in display math to their characters.
+var bigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂",
+	"bigoplus": "⨁", "bigotimes": "⨂", "bigvee": "⋁", "bigwedge": "⋀",
+}
+
+// delimiters maps the commands which may follow \left and \right to their
+// characters.
+var delimiters = map[string]string{
+	"{": "{", "}": "}", "|": "‖", "langle": "⟨", "rangle": "⟩",
+	"lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉", "vert": "|",
+	"Vert": "‖", "lbrace": "{", "rbrace": "}", "lbrack": "[", "rbrack": "]",
+}
+
+// spaces maps the spacing commands to their widths.
+var spaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em",
+	"!": "-0.1667em", " ": "0.25em", "quad": "1em", "qquad": "2em",
+}
+
+// functions are the named functions, such as \sin.
+var functions = map[string]struct{}{
+	"sin": {}, "cos": {}, "tan": {}, "cot": {}, "sec": {}, "csc": {},
+	"arcsin": {}, "arccos": {}, "arctan": {}, "sinh": {}, "cosh": {},
+	"tanh": {}, "coth": {}, "log": {}, "ln": {}, "lg": {}, "exp": {},
+	"deg": {}, "dim": {}, "ker": {}, "hom": {}, "arg": {},
+}
+
+// limitFunctions are the named functions whose subscripts are placed below
+// them in display math, such as \lim.
+var limitFunctions = map[string]struct{}{
+	"lim": {}, "liminf": {}, "limsup": {}, "max": {}, "min": {}, "sup": {},
+	"inf": {}, "det": {}, "gcd": {}, "Pr": {},
+}
+
+// accents maps the accent commands to their characters.
+var accents = map[string]string{
+	"hat": "^", "bar": "¯", "vec": "→", "dot": "˙", "ddot": "¨",
+	"tilde": "~", "acute": "´", "grave": "`", "breve": "˘", "check": "ˇ",
+}
+
+// wideAccents maps the accent commands which stretch over their argument to
+// their characters.
+var wideAccents = map[string]string{
+	"overline": "¯", "widehat": "^", "widetilde": "~",
+	"overrightarrow": "→", "overleftarrow": "←",
+}
+
+// variants maps the font commands to their MathML mathvariant.
+var variants = map[string]string{
+	"mathrm": "normal", "mathbf": "bold", "mathit": "italic",
+	"mathbb": "double-struck", "mathcal": "script", "mathscr": "script",
+	"mathfrak": "fraktur", "mathsf": "sans-serif", "mathtt": "monospace",
+	"boldsymbol": "bold-italic", "bm": "bold-italic",
+}
@@ -9,6 +9,8 @@ import (
 	"io"
 	"math"
 	"math/rand"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -32,6 +34,8 @@ type Card struct {
 	model  *fbModel
 	appURL string
 	repo   *Repo
+	// tags caches the card's tags, once fetched for a template.
+	tags []string
 }
 
 var _ flashback.CardView = &Card{}
@@ -70,6 +74,20 @@ type cardData struct {
 	// Deck     *Deck
 	BaseURI string
 	Fields  map[string]template.HTML
+	ctx     context.Context
+}
+
+// Tags returns the combined tags of the card and its note. They are fetched
+// only if the template refers to them, and then cached on the card.
+func (d cardData) Tags() ([]string, error) {
+	if d.Card.tags == nil {
+		tags, err := d.Card.cardTags(d.ctx)
+		if err != nil {
+			return nil, err
+		}
+		d.Card.tags = tags
+	}
+	return d.Card.tags, nil
 }
 
 // Body produces the HTML body of the card to be displayed.
@@ -83,14 +101,33 @@ func (c *Card) Body(ctx context.Context, face int) (body string, err error) {
 		return "", errors.New("card hasn't been fetched")
 	}
 
+	htmlDoc, err := c.execTemplate(ctx, face)
+	if err != nil {
+		return "", err
+	}
+
+	iframeScript, _ := c.model.IframeScript()
+	newBody, err := prepareBody(cardFace, c.TemplateID(), c.model.templateDiv(c.TemplateID()), string(iframeScript), htmlDoc)
+	if err != nil {
+		return "", errors.Wrap(err, "prepare body")
+	}
+
+	nbString := string(newBody)
+	log.Debugf("new body size = %d\n", len(nbString))
+	return nbString, nil
+}
+
+// execTemplate executes the card's model template for the requested face, and
+// returns the complete, unprocessed, HTML document.
+func (c *Card) execTemplate(ctx context.Context, face int) (*bytes.Buffer, error) {
 	funcMap, err := c.model.FuncMap(c, face)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get FuncMap")
+		return nil, errors.Wrap(err, "failed to get FuncMap")
 	}
 
 	tmpl, err := c.model.Template(ctx)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to generate template")
+		return nil, errors.Wrap(err, "failed to generate template")
 	}
 
 	data := cardData{
@@ -100,30 +137,49 @@ func (c *Card) Body(ctx context.Context, face int) (body string, err error) {
 		// Model:    model,
 		BaseURI: c.appURL,
 		Fields:  make(map[string]template.HTML, len(c.model.Fields)),
+		ctx:     ctx,
 	}
 
 	for i, field := range c.model.Fields {
-		switch c.note.FieldValues[i].Type() {
+		fv := c.note.FieldValues[i]
+		switch fv.Type() {
 		case fb.AnkiField, fb.TextField:
-			data.Fields[field.Name] = template.HTML(c.note.FieldValues[i].Text)
+			data.Fields[field.Name] = template.HTML(fv.Text)
+		case fb.ImageField, fb.AudioField:
+			data.Fields[field.Name] = mediaHTML(fv.Type(), fieldFiles(fv))
 		}
 	}
 
 	htmlDoc := new(bytes.Buffer)
 	if e := tmpl.Funcs(funcMap).Execute(htmlDoc, data); e != nil {
-		return "", errors.Wrap(e, "template execution")
+		return nil, errors.Wrap(e, "template execution")
 	}
-	// return htmlDoc.String(), nil
+	return htmlDoc, nil
+}
 
-	iframeScript, _ := c.model.IframeScript()
-	newBody, err := prepareBody(cardFace, c.TemplateID(), string(iframeScript), htmlDoc)
-	if err != nil {
-		return "", errors.Wrap(err, "prepare body")
-	}
+// fieldFiles returns the sorted names of the files attached to a field value.
+func fieldFiles(fv *fb.FieldValue) []string {
+	files := fv.Files()
+	sort.Strings(files)
+	return files
+}
 
-	nbString := string(newBody)
-	log.Debugf("new body size = %d\n", len(nbString))
-	return nbString, nil
+// mediaHTML returns the markup to display or play the files of an image or
+// audio field. Sources are path-escaped filenames, as by the Anki image and
+// audio functions, which the card iframe fetches from the note's attachments
+// via fserve.
+func mediaHTML(fieldType fb.FieldType, files []string) template.HTML {
+	var buf bytes.Buffer
+	for _, file := range files {
+		src := template.HTMLEscapeString(url.PathEscape(file))
+		switch fieldType {
+		case fb.ImageField:
+			fmt.Fprintf(&buf, `<img src="%s"/>`, src)
+		case fb.AudioField:
+			fmt.Fprintf(&buf, `<audio controls="controls" src="%s"></audio>`, src)
+		}
+	}
+	return template.HTML(buf.String())
 }
 
 // Action handles a card action produced by the user.
@@ -330,7 +386,11 @@ func (r *Repo) getCardToStudy(ctx context.Context, deck string) (*Card, error) {
 	if err != nil {
 		return nil, err
 	}
-	card, err := getCardToStudy(ctx, udb, deck)
+	isLesson := r.lessonChecker(udb)
+	lessonBefore := func(ctx context.Context, card *fb.Card) (string, error) {
+		return precedingLesson(ctx, udb, card, isLesson)
+	}
+	card, err := getCardToStudy(ctx, udb, deck, lessonBefore)
 	if err != nil || card == nil {
 		return nil, err
 	}
@@ -372,7 +432,10 @@ func (c *Card) fetch(ctx context.Context, client kivikClient) error {
 		return errors.New("card's theme has no model")
 	}
 	c.note = &fbNote{Note: note}
-	model := theme.Models[c.ThemeModelID()]
+	model, err := themeModel(theme, uint32(c.ThemeModelID()))
+	if err != nil {
+		return err
+	}
 	c.model = &fbModel{Model: model, db: db}
 	return c.note.SetModel(model)
 }
@@ -384,7 +447,94 @@ type cardSchedule struct {
 	BuriedUntil fb.Due      `json:"buriedUntil"`
 }
 
-func getCardToStudy(ctx context.Context, db queryGetter, deck string) (*fb.Card, error) {
+// lessonChecker returns a function which reports whether a card is a lesson,
+// according to its model's controller. Themes are cached for the lifetime of
+// the function.
+func (r *Repo) lessonChecker(udb getter) func(context.Context, string) (bool, error) {
+	themes := make(map[string]*fb.Theme)
+	return func(ctx context.Context, cardID string) (bool, error) {
+		card := &fb.Card{}
+		if err := getDoc(ctx, udb, cardID, card); err != nil {
+			return false, err
+		}
+		theme, ok := themes[card.ThemeID()]
+		if !ok {
+			bdb, err := r.newDB(ctx, card.BundleID())
+			if err != nil {
+				return false, err
+			}
+			theme = &fb.Theme{}
+			if e := getDoc(ctx, bdb, card.ThemeID(), theme); e != nil {
+				return false, e
+			}
+			themes[card.ThemeID()] = theme
+		}
+		model, err := themeModel(theme, uint32(card.ThemeModelID()))
+		if err != nil {
+			return false, err
+		}
+		return isLessonType(model.Type), nil
+	}
+}
+
+// precedingLesson returns the ID of the first new lesson which precedes a new
+// card in its deck, in the order in which the cards were created, or "" if
+// there is none, so that no card is introduced before a lesson which precedes
+// it. The lesson is returned even if it is buried.
+func precedingLesson(ctx context.Context, db finder, card *fb.Card, isLesson func(context.Context, string) (bool, error)) (string, error) {
+	if card.Deck == "" {
+		return "", nil
+	}
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": map[string]interface{}{
+			"type":      "card",
+			"deck":      card.Deck,
+			"created":   map[string]interface{}{"$lt": card.Created},
+			"interval":  map[string]interface{}{"$exists": false},
+			"suspended": map[string]interface{}{"$ne": true},
+		},
+		"fields": []string{"_id", "created"},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find new cards")
+	}
+	defer func() { _ = rows.Close() }()
+	type newCard struct {
+		ID      string    `json:"_id"`
+		Created time.Time `json:"created"`
+	}
+	var earlier []newCard
+	for rows.Next() {
+		var doc newCard
+		if e := rows.ScanDoc(&doc); e != nil {
+			return "", errors.Wrap(e, "scan card")
+		}
+		earlier = append(earlier, doc)
+	}
+	if e := rows.Err(); e != nil {
+		return "", e
+	}
+	sort.Slice(earlier, func(i, j int) bool {
+		if !earlier[i].Created.Equal(earlier[j].Created) {
+			return earlier[i].Created.Before(earlier[j].Created)
+		}
+		return earlier[i].ID < earlier[j].ID
+	})
+	for _, c := range earlier {
+		lesson, err := isLesson(ctx, c.ID)
+		if err != nil {
+			return "", err
+		}
+		if lesson {
+			return c.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// getCardToStudy selects a card to study from the deck. If lessonBefore is not
+// nil, it is used to select a new lesson in place of any new card it precedes.
+func getCardToStudy(ctx context.Context, db queryGetter, deck string, lessonBefore func(context.Context, *fb.Card) (string, error)) (*fb.Card, error) {
 	defer profile("getCardToStudy")()
 	var newCards, oldCards []*cardSchedule
 	var newErr, oldErr error
@@ -408,6 +558,21 @@ func getCardToStudy(ctx context.Context, db queryGetter, deck string) (*fb.Card,
 	if cardID == "" {
 		return nil, nil
 	}
+	card, err := getCard(ctx, db, cardID)
+	if err != nil || lessonBefore == nil || !card.Due.IsZero() {
+		return card, err
+	}
+	lessonID, err := lessonBefore(ctx, card)
+	if err != nil {
+		return nil, errors.Wrap(err, "lessons")
+	}
+	if lessonID == "" {
+		return card, nil
+	}
+	return getCard(ctx, db, lessonID)
+}
+
+func getCard(ctx context.Context, db getter, cardID string) (*fb.Card, error) {
 	row, err := db.Get(ctx, cardID)
 	if err != nil {
 		return nil, err
@@ -429,14 +594,17 @@ var faces = map[int]string{
 	Answer:   "answer",
 }
 
-func prepareBody(cardFace string, templateID uint32, iframeScript string, r io.Reader) ([]byte, error) {
+// prepareBody extracts the requested face from the template output, in the
+// div whose data-id is div, and wraps it for display. The body's class is set
+// from the card's template ID.
+func prepareBody(cardFace string, templateID, div uint32, iframeScript string, r io.Reader) ([]byte, error) {
 	defer profile("prepareBody")()
 	doc, err := goquery.NewDocumentFromReader(r)
 	if err != nil {
 		return nil, errors.Wrap(err, "goquery parse")
 	}
 	body := doc.Find("body")
-	sel := fmt.Sprintf("div.%s[data-id='%d']", cardFace, templateID)
+	sel := fmt.Sprintf("div.%s[data-id='%d']", cardFace, div)
 	container := body.Find(sel)
 	if container.Length() == 0 {
 		return nil, errors.Errorf("No div matching '%s' found in template output", sel)
@@ -460,6 +628,23 @@ func prepareBody(cardFace string, templateID uint32, iframeScript string, r io.R
 	return []byte(newBody), nil
 }
 
+// hasContent returns true if the template output contains a non-empty div for
+// the requested face and template.
+func hasContent(cardFace string, templateID uint32, r io.Reader) (bool, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return false, errors.Wrap(err, "goquery parse")
+	}
+	container := doc.Find(fmt.Sprintf("div.%s[data-id='%d']", cardFace, templateID))
+	if container.Length() == 0 {
+		return false, nil
+	}
+	if strings.TrimSpace(container.Text()) != "" {
+		return true, nil
+	}
+	return container.Find("img, audio, video, object, embed").Length() > 0, nil
+}
+
 func relatedKeyRange(cardID string) (startKey, endKey string) {
 	startKey = strings.TrimRight(cardID, "0123456789")
 	return startKey, startKey + string(rune(0x10FFFF))
@@ -483,3 +668,129 @@ func (c *Card) FieldValue(fieldName string) *fb.FieldValue {
 	}
 	return nil
 }
+
+// DeckFieldValues returns the text values of the named field, from the other
+// notes of the card's model which have cards in the card's deck, so that
+// model controllers may draw on the rest of the deck, such as for the
+// distractors of multiple-choice cards. Empty values, and those equal to the
+// card's own value, are omitted, as are duplicates. The notes of each bundle
+// are fetched with a single query, for only their field values.
+func (c *Card) DeckFieldValues(ctx context.Context, fieldName string) ([]string, error) {
+	if c.Deck == "" || c.repo == nil {
+		return []string{}, nil
+	}
+	i := fieldIndex(c.model.Model, fieldName)
+	if i < 0 {
+		return []string{}, nil
+	}
+	udb, err := c.repo.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cardIDs, err := findIDs(ctx, udb, map[string]interface{}{
+		"type":  "card",
+		"deck":  c.Deck,
+		"model": c.ModelID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find deck cards")
+	}
+	bundleIDs := make([]string, 0)
+	noteIDs := make(map[string][]string)
+	notes := map[string]bool{c.BundleID() + "/" + c.NoteID(): true}
+	for _, id := range cardIDs {
+		card := &fb.Card{ID: id}
+		bundleID, noteID := card.BundleID(), card.NoteID()
+		key := bundleID + "/" + noteID
+		if notes[key] {
+			continue
+		}
+		notes[key] = true
+		if _, ok := noteIDs[bundleID]; !ok {
+			bundleIDs = append(bundleIDs, bundleID)
+		}
+		noteIDs[bundleID] = append(noteIDs[bundleID], noteID)
+	}
+	seen := make(map[string]bool)
+	if fv := c.FieldValue(fieldName); fv != nil {
+		seen[fv.Text] = true
+	}
+	values := make([]string, 0)
+	for _, bundleID := range bundleIDs {
+		bdb, err := c.repo.newDB(ctx, bundleID)
+		if err != nil {
+			return nil, err
+		}
+		texts, err := noteFieldTexts(ctx, bdb, noteIDs[bundleID], i)
+		if err != nil {
+			return nil, err
+		}
+		for _, text := range texts {
+			if strings.TrimSpace(text) == "" || seen[text] {
+				continue
+			}
+			seen[text] = true
+			values = append(values, text)
+		}
+	}
+	return values, nil
+}
+
+// noteFieldTexts returns the text of the field at index i of each of the
+// notes in db, in the order of the notes' IDs. Missing notes, and those
+// without the field, are skipped.
+func noteFieldTexts(ctx context.Context, db finder, noteIDs []string, i int) ([]string, error) {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": map[string]interface{}{
+			"_id": map[string]interface{}{"$in": noteIDs},
+		},
+		"fields": []string{"_id", "fieldValues"},
+		// CouchDB returns only 25 results by default
+		"limit": math.MaxInt32,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch notes")
+	}
+	defer func() { _ = rows.Close() }()
+	texts := make(map[string]string)
+	for rows.Next() {
+		var doc struct {
+			ID          string `json:"_id"`
+			FieldValues []*struct {
+				Text string `json:"text"`
+			} `json:"fieldValues"`
+		}
+		if e := rows.ScanDoc(&doc); e != nil {
+			return nil, errors.Wrap(e, "scan note")
+		}
+		if i < len(doc.FieldValues) && doc.FieldValues[i] != nil {
+			texts[doc.ID] = doc.FieldValues[i].Text
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(texts))
+	for _, id := range noteIDs {
+		if text, ok := texts[id]; ok {
+			result = append(result, text)
+		}
+	}
+	return result, nil
+}
+
+// DeckConfig returns the preset in use by the card's deck, or the default
+// preset if the card belongs to no deck.
+func (c *Card) DeckConfig(ctx context.Context) (*DeckConfig, error) {
+	if c.repo == nil {
+		return defaultDeckConfig(), nil
+	}
+	udb, err := c.repo.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.Deck == "" {
+		return getDeckConfig(ctx, udb, DefaultDeckConfigID)
+	}
+	return deckConfigForDeck(ctx, udb, c.Deck)
+}
@@ -2,10 +2,13 @@ package model
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"math/rand"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -624,7 +627,7 @@ func TestGetCardToStudy(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result, err := getCardToStudy(context.Background(), test.db, "")
+			result, err := getCardToStudy(context.Background(), test.db, "", nil)
 			checkErr(t, test.err, err)
 			if err != nil {
 				return
@@ -782,6 +785,26 @@ func TestCardBody(t *testing.T) {
 			}(),
 			err: "failed to generate template: main template '$template.0.html' not found in model",
 		},
+		{
+			name: "tags error",
+			card: func() *Card {
+				theme, _ := fb.NewTheme("theme-YmFy")
+				modelFiles := theme.Attachments.NewView()
+				_ = modelFiles.AddFile("$template.0.html", "text/html", []byte(`<body><div class="question" data-id="0">{{ .Tags }}</div></body>`))
+				model := &fb.Model{
+					Theme: theme,
+					Type:  "basic",
+					Files: modelFiles,
+				}
+				return &Card{
+					Card:  &fb.Card{ID: "card-foo.bar.0"},
+					note:  &fbNote{Note: &fb.Note{ID: "note-Zm9v"}},
+					model: &fbModel{Model: model},
+					repo:  &Repo{},
+				}
+			}(),
+			err: `template execution: template: template:1:69: executing "template.html" at <.Tags>: error calling Tags: not logged in`,
+		},
 		{
 			name: "success",
 			card: func() *Card {
@@ -802,6 +825,8 @@ func TestCardBody(t *testing.T) {
 					note:   &fbNote{Note: &fb.Note{ID: "note-Zm9v"}},
 					model:  &fbModel{Model: model},
 					appURL: "http://foo.com/",
+					// Tags are not fetched, as the template doesn't use them
+					repo: &Repo{},
 				}
 			}(),
 			expected: `<!DOCTYPE html><html><head>
@@ -852,6 +877,7 @@ func TestCardPrepareBody(t *testing.T) {
 		name         string
 		cardFace     string
 		templateID   uint32
+		div          uint32
 		iframeScript string
 		r            io.Reader
 		expected     string
@@ -873,10 +899,17 @@ func TestCardPrepareBody(t *testing.T) {
 			r:        strings.NewReader(`<body><div class="question" data-id="0">foo</div></body>`),
 			expected: `<html><head><script type="text/javascript"></script></head><body class="card card1"><form id="mainform">foo</form></body></html>`,
 		},
+		{
+			name:       "cloze",
+			cardFace:   "question",
+			templateID: 1,
+			r:          strings.NewReader(`<body><div class="question" data-id="0">foo</div></body>`),
+			expected:   `<html><head><script type="text/javascript"></script></head><body class="card card2"><form id="mainform">foo</form></body></html>`,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result, err := prepareBody(test.cardFace, test.templateID, test.iframeScript, test.r)
+			result, err := prepareBody(test.cardFace, test.templateID, test.div, test.iframeScript, test.r)
 			checkErr(t, test.err, err)
 			if err != nil {
 				return
@@ -899,3 +932,258 @@ func TestRelatedKeyRange(t *testing.T) {
 		t.Errorf("Unexpected end key: %s", end)
 	}
 }
+
+func TestFieldFiles(t *testing.T) {
+	fv := &fb.FieldValue{}
+	if e := json.Unmarshal([]byte(`{"files":["b.png","a.png"]}`), fv); e != nil {
+		t.Fatal(e)
+	}
+	if d := diff.Interface([]string{"a.png", "b.png"}, fieldFiles(fv)); d != nil {
+		t.Error(d)
+	}
+	if files := fieldFiles(&fb.FieldValue{}); len(files) != 0 {
+		t.Errorf("Unexpected files: %v", files)
+	}
+}
+
+func TestMediaHTML(t *testing.T) {
+	tests := []struct {
+		name      string
+		fieldType fb.FieldType
+		files     []string
+		expected  template.HTML
+	}{
+		{
+			name:      "no files",
+			fieldType: fb.ImageField,
+			expected:  "",
+		},
+		{
+			name:      "images",
+			fieldType: fb.ImageField,
+			files:     []string{"a.png", `"b" & c.png`},
+			expected:  `<img src="a.png"/><img src="%22b%22%20&amp;%20c.png"/>`,
+		},
+		{
+			name:      "audio",
+			fieldType: fb.AudioField,
+			files:     []string{"a.mp3"},
+			expected:  `<audio controls="controls" src="a.mp3"></audio>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := mediaHTML(test.fieldType, test.files)
+			if result != test.expected {
+				t.Errorf("Unexpected result: %s", result)
+			}
+		})
+	}
+}
+
+func TestDeckFieldValues(t *testing.T) {
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	var cardID, tresID string
+	for _, front := range []string{"uno", "dos", "uno", "", "tres", "dos"} {
+		note, cards, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": front, "Back": "x"}, testDeckID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cardID == "" {
+			cardID = cards[0].ID
+		}
+		if front == "tres" {
+			tresID = note.ID
+		}
+	}
+	fetchCard := func(t *testing.T) *Card {
+		t.Helper()
+		card := &fb.Card{}
+		if e := getDoc(ctx, udb, cardID, card); e != nil {
+			t.Fatal(e)
+		}
+		c := &Card{Card: card, repo: repo}
+		if e := c.fetch(ctx, repo.local); e != nil {
+			t.Fatal(e)
+		}
+		return c
+	}
+
+	t.Run("deck", func(t *testing.T) {
+		values, err := fetchCard(t).DeckFieldValues(ctx, "Front")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface([]string{"dos", "tres"}, sortedStrings(values)); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("missing note", func(t *testing.T) {
+		note := &fb.Note{}
+		if e := getDoc(ctx, bdb, tresID, note); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := bdb.Delete(ctx, note.ID, note.Rev); e != nil {
+			t.Fatal(e)
+		}
+		values, err := fetchCard(t).DeckFieldValues(ctx, "Front")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface([]string{"dos"}, values); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("unknown field", func(t *testing.T) {
+		values, err := fetchCard(t).DeckFieldValues(ctx, "Foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 0 {
+			t.Errorf("Unexpected values: %v", values)
+		}
+	})
+	t.Run("no deck", func(t *testing.T) {
+		card := fetchCard(t)
+		card.Deck = ""
+		values, err := card.DeckFieldValues(ctx, "Front")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 0 {
+			t.Errorf("Unexpected values: %v", values)
+		}
+	})
+}
+
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+func TestCardDeckConfig(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repo{user: "bob", local: newFakeClient("user-bob")}
+	conf, err := repo.CreateDeckConfig(ctx, "Auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.ReviewMode = ReviewModeAuto
+	if e := repo.SaveDeckConfig(ctx, conf); e != nil {
+		t.Fatal(e)
+	}
+	if e := repo.SetDeckConfig(ctx, "deck-foo", conf.ID); e != nil {
+		t.Fatal(e)
+	}
+	tests := []struct {
+		name     string
+		card     *Card
+		expected string
+	}{
+		{name: "no repo", card: &Card{Card: &fb.Card{Deck: "deck-foo"}}, expected: DefaultDeckConfigID},
+		{name: "no deck", card: &Card{Card: &fb.Card{}, repo: repo}, expected: DefaultDeckConfigID},
+		{name: "deck", card: &Card{Card: &fb.Card{Deck: "deck-foo"}, repo: repo}, expected: conf.ID},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.card.DeckConfig(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ID != test.expected {
+				t.Errorf("Unexpected config: %s", result.ID)
+			}
+		})
+	}
+}
+
+func TestPrecedingLesson(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient("user-mjxwe")
+	db, _ := client.DB(ctx, "user-mjxwe")
+	cardID := func(i int) string {
+		return fmt.Sprintf("card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.%d", i)
+	}
+	created := func(day int) time.Time {
+		return time.Date(2017, 1, day, 0, 0, 0, 0, time.UTC)
+	}
+	put := func(i int, doc map[string]interface{}) {
+		doc["type"] = "card"
+		if _, ok := doc["deck"]; !ok {
+			doc["deck"] = "deck-foo"
+		}
+		if _, e := db.Put(ctx, cardID(i), doc); e != nil {
+			t.Fatal(e)
+		}
+	}
+	put(1, map[string]interface{}{"created": created(1), "interval": "100y"})
+	put(2, map[string]interface{}{"created": created(2), "deck": "deck-bar"})
+	put(3, map[string]interface{}{"created": created(3), "suspended": true})
+	put(4, map[string]interface{}{"created": created(5)})
+	put(5, map[string]interface{}{"created": created(4)})
+	put(6, map[string]interface{}{"created": created(6)})
+	put(7, map[string]interface{}{"created": created(7)})
+	lessons := func(ids ...int) func(context.Context, string) (bool, error) {
+		return func(_ context.Context, id string) (bool, error) {
+			for _, i := range ids {
+				if id == cardID(i) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+	}
+	tests := []struct {
+		name     string
+		card     *fb.Card
+		isLesson func(context.Context, string) (bool, error)
+		expected string
+		err      string
+	}{
+		{
+			name:     "no deck",
+			card:     &fb.Card{ID: cardID(8), Created: created(8)},
+			isLesson: lessons(6),
+		},
+		{
+			name:     "no lessons",
+			card:     &fb.Card{ID: cardID(8), Deck: "deck-foo", Created: created(8)},
+			isLesson: lessons(),
+		},
+		{
+			name:     "seen, other deck or suspended",
+			card:     &fb.Card{ID: cardID(8), Deck: "deck-foo", Created: created(8)},
+			isLesson: lessons(1, 2, 3),
+		},
+		{
+			name:     "earliest lesson",
+			card:     &fb.Card{ID: cardID(8), Deck: "deck-foo", Created: created(8)},
+			isLesson: lessons(4, 5, 6),
+			expected: cardID(5),
+		},
+		{
+			name:     "later lesson",
+			card:     &fb.Card{ID: cardID(6), Deck: "deck-foo", Created: created(6)},
+			isLesson: lessons(7),
+		},
+		{
+			name: "error",
+			card: &fb.Card{ID: cardID(8), Deck: "deck-foo", Created: created(8)},
+			isLesson: func(_ context.Context, _ string) (bool, error) {
+				return false, errors.New("lookup failed")
+			},
+			err: "lookup failed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := precedingLesson(ctx, db, test.card, test.isLesson)
+			checkErr(t, test.err, err)
+			if result != test.expected {
+				t.Errorf("Unexpected result: %q", result)
+			}
+		})
+	}
+}
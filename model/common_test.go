@@ -52,3 +52,20 @@ func parseDue(t *testing.T, ds string) fb.Due {
 	}
 	return d
 }
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// setZeroIDSource makes newDocID deterministic, and returns a function to
+// restore the original source.
+func setZeroIDSource() func() {
+	orig := idSource
+	idSource = zeroReader{}
+	return func() { idSource = orig }
+}
@@ -0,0 +1,291 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// deckCachePrefix is the prefix of the per-user deck summary cache, which is
+// kept in the (never synced) state database. The document with the ID
+// decklist-<user> records the update sequence of the user database, and each
+// deck is summarized by a document with the ID decklist-<user>/<deck>.
+const deckCachePrefix = "decklist-"
+
+// matureInterval is the interval at which a card is considered mature, rather
+// than learning.
+const matureInterval = 21 * fb.Day
+
+// deckCache is a compact summary of every card and deck in a user's database,
+// sufficient to produce a DeckList without querying any views. It is updated
+// incrementally from the user database's changes feed, and only the summaries
+// of the decks affected by an update are stored again.
+type deckCache struct {
+	ID  string `json:"_id"`
+	Rev string `json:"_rev,omitempty"`
+	// Seq is the update sequence of the user database, as of the last update.
+	Seq string `json:"seq"`
+
+	summaries map[string]*deckCacheDeck
+	// cardDecks maps each card ID to the ID of the deck it is summarized in.
+	cardDecks map[string]string
+	// changed is the set of IDs of the decks changed by the last update.
+	changed map[string]bool
+}
+
+// deckCacheDeck is the cached summary of a single deck.
+type deckCacheDeck struct {
+	ID    string                    `json:"_id"`
+	Rev   string                    `json:"_rev,omitempty"`
+	Name  string                    `json:"name,omitempty"`
+	Cards map[string]*deckCacheCard `json:"cards"`
+}
+
+// deckCacheCard holds the properties of a card which affect deck stats.
+type deckCacheCard struct {
+	Deck        string      `json:"d"`
+	Suspended   bool        `json:"s,omitempty"`
+	Due         *fb.Due     `json:"due,omitempty"`
+	BuriedUntil *fb.Due     `json:"b,omitempty"`
+	Interval    fb.Interval `json:"i,omitempty"`
+}
+
+func newDeckCache(user string) *deckCache {
+	return &deckCache{
+		ID:        deckCachePrefix + user,
+		summaries: make(map[string]*deckCacheDeck),
+		cardDecks: make(map[string]string),
+		changed:   make(map[string]bool),
+	}
+}
+
+func newDeckCacheCard(card *fb.Card) *deckCacheCard {
+	c := &deckCacheCard{
+		Deck:      card.Deck,
+		Suspended: card.Suspended,
+		Interval:  card.Interval,
+	}
+	if c.Deck == "" {
+		c.Deck = orphanedCardDeckID
+	}
+	if !card.Due.IsZero() {
+		due := card.Due
+		c.Due = &due
+	}
+	if !card.BuriedUntil.IsZero() {
+		buried := card.BuriedUntil
+		c.BuriedUntil = &buried
+	}
+	return c
+}
+
+// update reads all changes to db since the last update.
+func (c *deckCache) update(ctx context.Context, db kivikDB) error {
+	defer profile("deck cache update")()
+	// Take the update sequence before reading the changes feed. Any changes
+	// made in the meantime will simply be read again next time, which is
+	// harmless.
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	opts := kivik.Options{"include_docs": true}
+	if c.Seq != "" {
+		opts["since"] = c.Seq
+	}
+	changes, err := db.Changes(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = changes.Close() }()
+	for changes.Next() {
+		if e := c.apply(changes); e != nil {
+			return e
+		}
+	}
+	if e := changes.Err(); e != nil {
+		return e
+	}
+	c.Seq = stats.UpdateSeq
+	return nil
+}
+
+// apply updates the cache with a single change.
+func (c *deckCache) apply(change kivikChanges) error {
+	var doc struct {
+		ID   string `json:"_id"`
+		Name string `json:"name"`
+	}
+	if err := change.ScanDoc(&doc); err != nil {
+		return errors.Wrap(err, "scan change")
+	}
+	switch {
+	case strings.HasPrefix(doc.ID, "card-"):
+		if deckID, ok := c.cardDecks[doc.ID]; ok {
+			delete(c.deck(deckID).Cards, doc.ID)
+			delete(c.cardDecks, doc.ID)
+		}
+		if change.Deleted() {
+			return nil
+		}
+		card := &fb.Card{}
+		if err := change.ScanDoc(card); err != nil {
+			return errors.Wrapf(err, "scan %s", doc.ID)
+		}
+		cached := newDeckCacheCard(card)
+		c.deck(cached.Deck).Cards[doc.ID] = cached
+		c.cardDecks[doc.ID] = cached.Deck
+	case strings.HasPrefix(doc.ID, "deck-"):
+		deck := c.deck(doc.ID)
+		deck.Name = doc.Name
+		if change.Deleted() {
+			deck.Name = ""
+		}
+	}
+	return nil
+}
+
+// deck returns the summary of the requested deck, creating it if necessary,
+// and marks it as changed.
+func (c *deckCache) deck(deckID string) *deckCacheDeck {
+	c.changed[deckID] = true
+	deck, ok := c.summaries[deckID]
+	if !ok {
+		deck = &deckCacheDeck{
+			ID:    c.ID + "/" + deckID,
+			Cards: make(map[string]*deckCacheCard),
+		}
+		c.summaries[deckID] = deck
+	}
+	return deck
+}
+
+// decks summarizes the cache as of ts. The aggregate 'all' deck is not
+// included.
+func (c *deckCache) decks(ts time.Time) []*Deck {
+	decks := make([]*Deck, 0, len(c.summaries))
+	for deckID, cached := range c.summaries {
+		if len(cached.Cards) == 0 {
+			continue
+		}
+		deck := &Deck{ID: deckID, Name: c.deckName(deckID)}
+		for _, card := range cached.Cards {
+			deck.TotalCards++
+			switch {
+			case card.Suspended:
+				deck.SuspendedCards++
+				continue
+			case card.Due == nil:
+				deck.NewCards++
+				continue
+			case card.Interval >= seenInterval:
+				// A seen lesson, retired from study; neither learning nor
+				// mature, and never due.
+				continue
+			case card.Interval >= matureInterval:
+				deck.MatureCards++
+			default:
+				deck.LearningCards++
+			}
+			if time.Time(*card.Due).After(ts) {
+				continue
+			}
+			if card.BuriedUntil != nil && time.Time(*card.BuriedUntil).After(ts) {
+				continue
+			}
+			deck.DueCards++
+		}
+		decks = append(decks, deck)
+	}
+	return decks
+}
+
+func (c *deckCache) deckName(deckID string) string {
+	if deckID == orphanedCardDeckID {
+		return orphanedCardDeckName
+	}
+	if deck, ok := c.summaries[deckID]; ok && deck.Name != "" {
+		return deck.Name
+	}
+	return deckID
+}
+
+// load reads the cache from the state database.
+func (c *deckCache) load(ctx context.Context, state kivikDB) error {
+	if err := getDoc(ctx, state, c.ID, c); err != nil && kivik.StatusCode(err) != kivik.StatusNotFound {
+		return err
+	}
+	rows, err := state.AllDocs(ctx, kivik.Options{
+		"startkey":     c.ID + "/",
+		"endkey":       c.ID + "/" + kivik.EndKeySuffix,
+		"include_docs": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		deck := &deckCacheDeck{}
+		if e := rows.ScanDoc(deck); e != nil {
+			return errors.Wrap(e, "scan deck cache")
+		}
+		// A deck which was stored without cards will lack these
+		if deck.Cards == nil {
+			deck.Cards = make(map[string]*deckCacheCard)
+		}
+		deckID := strings.TrimPrefix(deck.ID, c.ID+"/")
+		c.summaries[deckID] = deck
+		for cardID := range deck.Cards {
+			c.cardDecks[cardID] = deckID
+		}
+	}
+	return rows.Err()
+}
+
+// save stores the summaries of the changed decks, followed by the update
+// sequence, in the state database. Should the latter fail, the same changes
+// are simply applied again next time.
+func (c *deckCache) save(ctx context.Context, state kivikDB) error {
+	if len(c.changed) > 0 {
+		decks := make([]*deckCacheDeck, 0, len(c.changed))
+		for deckID := range c.changed {
+			decks = append(decks, c.summaries[deckID])
+		}
+		if err := updateDocs(ctx, state, decks); err != nil {
+			return err
+		}
+	}
+	rev, err := state.Put(ctx, c.ID, c)
+	if err != nil {
+		return err
+	}
+	c.Rev = rev
+	return nil
+}
+
+// cachedDeckList returns the list of decks, as calculated from the cache
+// stored in the state database, after bringing it up to date. If the user
+// database does not support a changes feed, a kivik.StatusNotImplemented
+// error is returned.
+func (r *Repo) cachedDeckList(ctx context.Context, udb kivikDB) ([]*Deck, error) {
+	defer profile("cached deck list")()
+	cache := newDeckCache(r.user)
+	if err := cache.load(ctx, r.state); err != nil {
+		return nil, err
+	}
+	seq := cache.Seq
+	if err := cache.update(ctx, udb); err != nil {
+		return nil, err
+	}
+	if cache.Seq != seq {
+		if err := cache.save(ctx, r.state); err != nil {
+			return nil, errors.Wrap(err, "failed to store deck cache")
+		}
+	}
+	return cache.decks(now()), nil
+}
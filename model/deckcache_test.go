@@ -0,0 +1,120 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/flimzy/diff"
+)
+
+func TestCachedDeckList(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient("user-bob", stateDB)
+	state, _ := client.DB(ctx, stateDB)
+	repo := &Repo{user: "bob", local: client, state: state}
+	udb, _ := client.DB(ctx, "user-bob")
+
+	put := func(doc string) {
+		t.Helper()
+		var id struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal([]byte(doc), &id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := udb.Put(ctx, id.ID, json.RawMessage(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(`{"_id":"deck-foo", "type":"deck", "name":"Foo"}`)
+	put(`{"_id":"deck-bar", "type":"deck", "name":"Bar"}`)
+	put(`{"_id":"card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0", "type":"card", "created":"2016-07-31T15:08:24Z", "modified":"2016-07-31T15:08:24Z", "model":"theme-VGVzdCBUaGVtZQ/0", "deck":"deck-foo"}`)
+	put(`{"_id":"card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1", "type":"card", "created":"2016-07-31T15:08:24Z", "modified":"2016-07-31T15:08:24Z", "model":"theme-VGVzdCBUaGVtZQ/0", "deck":"deck-foo", "due":"2016-12-01", "interval":30}`)
+	put(`{"_id":"card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.2", "type":"card", "created":"2016-07-31T15:08:24Z", "modified":"2016-07-31T15:08:24Z", "model":"theme-VGVzdCBUaGVtZQ/0", "deck":"deck-foo", "due":"2017-02-01", "interval":2}`)
+	put(`{"_id":"card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.3", "type":"card", "created":"2016-07-31T15:08:24Z", "modified":"2016-07-31T15:08:24Z", "model":"theme-VGVzdCBUaGVtZQ/0", "deck":"deck-bar", "due":"2016-12-01", "interval":2, "buriedUntil":"2017-01-05"}`)
+	// A seen lesson, retired from study, is neither learning nor mature
+	put(`{"_id":"card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.5", "type":"card", "created":"2016-07-31T15:08:24Z", "modified":"2016-07-31T15:08:24Z", "model":"theme-VGVzdCBUaGVtZQ/0", "deck":"deck-foo", "due":"2116-12-01", "interval":36500}`)
+	put(`{"_id":"card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.4", "type":"card", "created":"2016-07-31T15:08:24Z", "modified":"2016-07-31T15:08:24Z", "model":"theme-VGVzdCBUaGVtZQ/0", "suspended":true}`)
+
+	result, err := repo.DeckList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Deck{
+		{Name: "All", TotalCards: 6, DueCards: 1, LearningCards: 2, MatureCards: 1, NewCards: 1, SuspendedCards: 1},
+		{Name: "Bar", ID: "deck-bar", TotalCards: 1, LearningCards: 1},
+		{Name: "Foo", ID: "deck-foo", TotalCards: 4, DueCards: 1, LearningCards: 1, MatureCards: 1, NewCards: 1},
+		{Name: "[No Deck]", ID: orphanedCardDeckID, TotalCards: 1, SuspendedCards: 1},
+	}
+	if d := diff.Interface(expected, result); d != nil {
+		t.Fatal(d)
+	}
+
+	cache := &deckCache{}
+	if e := getDoc(ctx, state, deckCachePrefix+"bob", cache); e != nil {
+		t.Fatal(e)
+	}
+	if cache.Seq != "8" {
+		t.Errorf("Unexpected stored seq: %s", cache.Seq)
+	}
+	// Each deck is summarized in its own document
+	foo := &deckCacheDeck{}
+	if e := getDoc(ctx, state, deckCachePrefix+"bob/deck-foo", foo); e != nil {
+		t.Fatal(e)
+	}
+	if foo.Name != "Foo" || len(foo.Cards) != 4 {
+		t.Errorf("Unexpected deck summary: %s, %d cards", foo.Name, len(foo.Cards))
+	}
+
+	// Subsequent changes are applied incrementally
+	row, _ := udb.Get(ctx, "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.4")
+	var card struct {
+		Rev string `json:"_rev"`
+	}
+	_ = row.ScanDoc(&card)
+	if _, e := udb.Delete(ctx, "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.4", card.Rev); e != nil {
+		t.Fatal(e)
+	}
+	row, _ = udb.Get(ctx, "deck-bar")
+	_ = row.ScanDoc(&card)
+	put(`{"_id":"deck-bar", "_rev":"` + card.Rev + `", "type":"deck", "name":"Baz"}`)
+
+	result, err = repo.DeckList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []*Deck{
+		{Name: "All", TotalCards: 5, DueCards: 1, LearningCards: 2, MatureCards: 1, NewCards: 1},
+		{Name: "Baz", ID: "deck-bar", TotalCards: 1, LearningCards: 1},
+		{Name: "Foo", ID: "deck-foo", TotalCards: 4, DueCards: 1, LearningCards: 1, MatureCards: 1, NewCards: 1},
+	}
+	if d := diff.Interface(expected, result); d != nil {
+		t.Error(d)
+	}
+	// Decks unaffected by the changes are not stored again
+	unchanged := &deckCacheDeck{}
+	if e := getDoc(ctx, state, deckCachePrefix+"bob/deck-foo", unchanged); e != nil {
+		t.Fatal(e)
+	}
+	if unchanged.Rev != foo.Rev {
+		t.Errorf("Unchanged deck stored again: %s", unchanged.Rev)
+	}
+}
+
+func TestCachedDeckListFallback(t *testing.T) {
+	ctx := context.Background()
+	local, err := localConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := local.CreateDB(ctx, "user-bob"); e != nil {
+		t.Fatal(e)
+	}
+	state, _ := newFakeClient(stateDB).DB(ctx, stateDB)
+	repo := &Repo{user: "bob", local: local, state: state}
+	// The memory driver has no changes feed, so the index is used, which
+	// does not exist.
+	_, err = repo.DeckList(ctx)
+	checkErr(t, "missing", err)
+}
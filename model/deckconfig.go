@@ -0,0 +1,335 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+)
+
+// DeckConfig is a named preset of study options, which may be shared by any
+// number of decks. Presets are stored in the user database, so that they are
+// synced between devices. Options belong here only once study applies them.
+type DeckConfig struct {
+	ID       string    `json:"_id"`
+	Rev      string    `json:"_rev,omitempty"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	Name     string    `json:"name"`
+	// ReviewMode selects how answers are reviewed, for models which support
+	// more than one mode. An empty value means ReviewModeSelf.
+	ReviewMode string `json:"reviewMode,omitempty"`
+	// AnswerMatching selects how typed answers are compared to the correct
+	// ones, for models which compare them. An empty value means
+	// AnswerMatchingExact.
+	AnswerMatching string `json:"answerMatching,omitempty"`
+}
+
+// The review modes, as described in notes.txt.
+const (
+	// ReviewModeSelf has the user grade their own answers.
+	ReviewModeSelf = "self"
+	// ReviewModeAuto grades typed answers automatically.
+	ReviewModeAuto = "auto"
+	// ReviewModeDelayed records answers for review later, by the user or
+	// by another user with access to the card's bundle.
+	ReviewModeDelayed = "delayed"
+)
+
+// The answer matching modes.
+const (
+	// AnswerMatchingExact requires typed answers to match exactly, apart from
+	// whitespace.
+	AnswerMatchingExact = "exact"
+	// AnswerMatchingLenient forgives differences of case and HTML markup,
+	// accepts any of several correct answers separated by ';' or '|', and
+	// compares sentences word by word.
+	AnswerMatchingLenient = "lenient"
+)
+
+const (
+	deckConfigPrefix    = "dconf-"
+	deckConfigRefPrefix = "deckconf-"
+
+	// DefaultDeckConfigID is the ID of the preset used by decks which have not
+	// been assigned a preset explicitly.
+	DefaultDeckConfigID = deckConfigPrefix + "default"
+)
+
+// defaultDeckConfig returns the built-in default preset.
+func defaultDeckConfig() *DeckConfig {
+	return &DeckConfig{
+		ID:       DefaultDeckConfigID,
+		Created:  now().UTC(),
+		Modified: now().UTC(),
+		Name:     "Default",
+	}
+}
+
+// Validate validates that the preset appears valid and self consistent.
+func (c *DeckConfig) Validate() error {
+	if c.ID == "" {
+		return errors.New("id required")
+	}
+	if !strings.HasPrefix(c.ID, deckConfigPrefix) {
+		return errors.New("incorrect doc type")
+	}
+	if c.Name == "" {
+		return errors.New("name required")
+	}
+	if c.Created.IsZero() {
+		return errors.New("created time required")
+	}
+	if c.Modified.IsZero() {
+		return errors.New("modified time required")
+	}
+	switch c.ReviewMode {
+	case "", ReviewModeSelf, ReviewModeAuto, ReviewModeDelayed:
+	default:
+		return errors.Errorf("invalid review mode '%s'", c.ReviewMode)
+	}
+	switch c.AnswerMatching {
+	case "", AnswerMatchingExact, AnswerMatchingLenient:
+	default:
+		return errors.Errorf("invalid answer matching '%s'", c.AnswerMatching)
+	}
+	return nil
+}
+
+type deckConfigAlias DeckConfig
+
+// MarshalJSON implements the json.Marshaler interface for the DeckConfig type.
+func (c *DeckConfig) MarshalJSON() ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	doc := struct {
+		deckConfigAlias
+		Type string `json:"type"`
+	}{
+		Type:            "deckconfig",
+		deckConfigAlias: deckConfigAlias(*c),
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for the DeckConfig
+// type.
+func (c *DeckConfig) UnmarshalJSON(data []byte) error {
+	doc := &deckConfigAlias{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return err
+	}
+	*c = DeckConfig(*doc)
+	return c.Validate()
+}
+
+// deckConfigRef associates a deck with a preset. It is stored as a separate
+// document, rather than as part of the deck, so that assigning a preset does
+// not modify the (possibly shared) deck itself.
+type deckConfigRef struct {
+	ID     string `json:"_id"`
+	Rev    string `json:"_rev,omitempty"`
+	Type   string `json:"type"`
+	ConfID string `json:"conf"`
+}
+
+func deckConfigRefID(deckID string) string {
+	return deckConfigRefPrefix + strings.TrimPrefix(deckID, "deck-")
+}
+
+// CreateDeckConfig creates and stores a new preset with the provided name, and
+// the default option values.
+func (r *Repo) CreateDeckConfig(ctx context.Context, name string) (*DeckConfig, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conf := defaultDeckConfig()
+	conf.ID = newDocID(deckConfigPrefix)
+	conf.Name = name
+	if err := conf.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid deck config")
+	}
+	rev, err := udb.Put(ctx, conf.ID, conf)
+	if err != nil {
+		return nil, err
+	}
+	conf.Rev = rev
+	return conf, nil
+}
+
+// GetDeckConfig returns the requested preset. The default preset is always
+// available, even if it has never been stored.
+func (r *Repo) GetDeckConfig(ctx context.Context, id string) (*DeckConfig, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return getDeckConfig(ctx, udb, id)
+}
+
+func getDeckConfig(ctx context.Context, db getter, id string) (*DeckConfig, error) {
+	conf := &DeckConfig{}
+	err := getDoc(ctx, db, id, conf)
+	if err == nil {
+		return conf, nil
+	}
+	if id == DefaultDeckConfigID && kivik.StatusCode(err) == kivik.StatusNotFound {
+		return defaultDeckConfig(), nil
+	}
+	return nil, err
+}
+
+// DeckConfigs returns all available presets, sorted by name, with the
+// default preset first.
+func (r *Repo) DeckConfigs(ctx context.Context) ([]*DeckConfig, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := udb.AllDocs(ctx, kivik.Options{
+		"startkey":     deckConfigPrefix,
+		"endkey":       deckConfigPrefix + kivik.EndKeySuffix,
+		"include_docs": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var def *DeckConfig
+	confs := make([]*DeckConfig, 0)
+	for rows.Next() {
+		conf := &DeckConfig{}
+		if e := rows.ScanDoc(conf); e != nil {
+			return nil, errors.Wrapf(e, "failed to scan %s", rows.ID())
+		}
+		if conf.ID == DefaultDeckConfigID {
+			def = conf
+			continue
+		}
+		confs = append(confs, conf)
+	}
+	if e := rows.Err(); e != nil {
+		return nil, e
+	}
+	if def == nil {
+		def = defaultDeckConfig()
+	}
+	sort.Slice(confs, func(i, j int) bool {
+		return confs[i].Name < confs[j].Name
+	})
+	return append([]*DeckConfig{def}, confs...), nil
+}
+
+// SaveDeckConfig stores changes to an existing preset.
+func (r *Repo) SaveDeckConfig(ctx context.Context, conf *DeckConfig) error {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	conf.Modified = now().UTC()
+	if err := conf.Validate(); err != nil {
+		return errors.Wrap(err, "invalid deck config")
+	}
+	rev, err := udb.Put(ctx, conf.ID, conf)
+	if err != nil {
+		return err
+	}
+	conf.Rev = rev
+	return nil
+}
+
+// DeleteDeckConfig deletes the requested preset. Any decks which used the
+// preset revert to the default preset. The default preset cannot be deleted.
+func (r *Repo) DeleteDeckConfig(ctx context.Context, id string) error {
+	if id == DefaultDeckConfigID {
+		return kerrors.Status(kivik.StatusBadRequest, "the default deck config cannot be deleted")
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	conf, err := getDeckConfig(ctx, udb, id)
+	if err != nil {
+		return err
+	}
+	rows, err := udb.AllDocs(ctx, kivik.Options{
+		"startkey":     deckConfigRefPrefix,
+		"endkey":       deckConfigRefPrefix + kivik.EndKeySuffix,
+		"include_docs": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var ref deckConfigRef
+		if e := rows.ScanDoc(&ref); e != nil {
+			return errors.Wrapf(e, "failed to scan %s", rows.ID())
+		}
+		if ref.ConfID != id {
+			continue
+		}
+		if _, e := udb.Delete(ctx, ref.ID, ref.Rev); e != nil {
+			return errors.Wrapf(e, "failed to reset %s", ref.ID)
+		}
+	}
+	if e := rows.Err(); e != nil {
+		return e
+	}
+	_, err = udb.Delete(ctx, conf.ID, conf.Rev)
+	return err
+}
+
+// SetDeckConfig assigns the requested preset to the deck.
+func (r *Repo) SetDeckConfig(ctx context.Context, deckID, confID string) error {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	if _, e := getDeckConfig(ctx, udb, confID); e != nil {
+		return errors.Wrap(e, "deck config")
+	}
+	refID := deckConfigRefID(deckID)
+	ref := &deckConfigRef{}
+	if e := getDoc(ctx, udb, refID, ref); e != nil && kivik.StatusCode(e) != kivik.StatusNotFound {
+		return e
+	}
+	ref.ID = refID
+	ref.Type = "deckconf"
+	ref.ConfID = confID
+	_, err = udb.Put(ctx, refID, ref)
+	return err
+}
+
+// DeckConfigForDeck returns the preset in use by the requested deck.
+func (r *Repo) DeckConfigForDeck(ctx context.Context, deckID string) (*DeckConfig, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return deckConfigForDeck(ctx, udb, deckID)
+}
+
+func deckConfigForDeck(ctx context.Context, db getter, deckID string) (*DeckConfig, error) {
+	ref := &deckConfigRef{}
+	err := getDoc(ctx, db, deckConfigRefID(deckID), ref)
+	switch {
+	case kivik.StatusCode(err) == kivik.StatusNotFound:
+		return getDeckConfig(ctx, db, DefaultDeckConfigID)
+	case err != nil:
+		return nil, err
+	}
+	conf, err := getDeckConfig(ctx, db, ref.ConfID)
+	if kivik.StatusCode(err) == kivik.StatusNotFound {
+		// The preset was deleted on another device
+		return getDeckConfig(ctx, db, DefaultDeckConfigID)
+	}
+	return conf, err
+}
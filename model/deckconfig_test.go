@@ -0,0 +1,272 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+)
+
+func TestDeckConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *DeckConfig
+		err  string
+	}{
+		{
+			name: "no id",
+			conf: &DeckConfig{},
+			err:  "id required",
+		},
+		{
+			name: "wrong type",
+			conf: &DeckConfig{ID: "deck-foo"},
+			err:  "incorrect doc type",
+		},
+		{
+			name: "no name",
+			conf: &DeckConfig{ID: "dconf-foo"},
+			err:  "name required",
+		},
+		{
+			name: "no created time",
+			conf: &DeckConfig{ID: "dconf-foo", Name: "foo"},
+			err:  "created time required",
+		},
+		{
+			name: "no modified time",
+			conf: &DeckConfig{ID: "dconf-foo", Name: "foo", Created: now()},
+			err:  "modified time required",
+		},
+		{
+			name: "invalid review mode",
+			conf: &DeckConfig{ID: "dconf-foo", Name: "foo", Created: now(), Modified: now(), ReviewMode: "peer"},
+			err:  "invalid review mode 'peer'",
+		},
+		{
+			name: "auto review",
+			conf: &DeckConfig{ID: "dconf-foo", Name: "foo", Created: now(), Modified: now(), ReviewMode: ReviewModeAuto},
+		},
+		{
+			name: "delayed review",
+			conf: &DeckConfig{ID: "dconf-foo", Name: "foo", Created: now(), Modified: now(), ReviewMode: ReviewModeDelayed},
+		},
+		{
+			name: "invalid answer matching",
+			conf: &DeckConfig{ID: "dconf-foo", Name: "foo", Created: now(), Modified: now(), AnswerMatching: "fuzzy"},
+			err:  "invalid answer matching 'fuzzy'",
+		},
+		{
+			name: "lenient answers",
+			conf: &DeckConfig{ID: "dconf-foo", Name: "foo", Created: now(), Modified: now(), AnswerMatching: AnswerMatchingLenient},
+		},
+		{
+			name: "default",
+			conf: defaultDeckConfig(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkErr(t, test.err, test.conf.Validate())
+		})
+	}
+}
+
+func TestDeckConfigMarshalJSON(t *testing.T) {
+	conf := defaultDeckConfig()
+	conf.ID = "dconf-foo"
+	conf.Name = "Foo"
+	conf.ReviewMode = ReviewModeDelayed
+	result, err := conf.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+		"_id":           "dconf-foo",
+		"type":          "deckconfig",
+		"created":       "2017-01-01T12:00:00Z",
+		"modified":      "2017-01-01T12:00:00Z",
+		"name":          "Foo",
+		"reviewMode":    "delayed"
+	}`
+	if d := diff.JSON([]byte(expected), result); d != nil {
+		t.Error(d)
+	}
+	if _, err := (&DeckConfig{}).MarshalJSON(); err == nil {
+		t.Errorf("Expected an error for an invalid config")
+	}
+}
+
+func TestDeckConfigUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected *DeckConfig
+		err      string
+	}{
+		{
+			name:  "invalid json",
+			input: "xxx",
+			err:   "invalid character 'x' looking for beginning of value",
+		},
+		{
+			name:  "invalid config",
+			input: `{"_id":"deck-foo"}`,
+			err:   "incorrect doc type",
+		},
+		{
+			name: "success",
+			input: `{"_id":"dconf-foo", "type":"deckconfig", "created":"2017-01-01T12:00:00Z", "modified":"2017-01-01T12:00:00Z",
+				"name":"Foo", "reviewMode":"auto"}`,
+			expected: &DeckConfig{
+				ID:         "dconf-foo",
+				Created:    parseTime(t, "2017-01-01T12:00:00Z"),
+				Modified:   parseTime(t, "2017-01-01T12:00:00Z"),
+				Name:       "Foo",
+				ReviewMode: ReviewModeAuto,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := &DeckConfig{}
+			err := result.UnmarshalJSON([]byte(test.input))
+			checkErr(t, test.err, err)
+			if err != nil {
+				return
+			}
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestDeckConfigs(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repo{user: "bob", local: newFakeClient("user-bob")}
+
+	confs, err := repo.DeckConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(confs) != 1 || confs[0].ID != DefaultDeckConfigID {
+		t.Fatalf("Expected only the default config, got %v", confs)
+	}
+
+	zed, err := repo.CreateDeckConfig(ctx, "Zed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(zed.ID, deckConfigPrefix) {
+		t.Errorf("Unexpected ID: %s", zed.ID)
+	}
+	if zed.Rev == "" {
+		t.Errorf("Expected rev to be set")
+	}
+	if _, err = repo.CreateDeckConfig(ctx, "Alpha"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.CreateDeckConfig(ctx, ""); err == nil {
+		t.Errorf("Expected an error for an empty name")
+	}
+
+	confs, err = repo.DeckConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(confs))
+	for i, conf := range confs {
+		names[i] = conf.Name
+	}
+	if d := diff.Interface([]string{"Default", "Alpha", "Zed"}, names); d != nil {
+		t.Error(d)
+	}
+
+	zed.ReviewMode = ReviewModeAuto
+	if err = repo.SaveDeckConfig(ctx, zed); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repo.GetDeckConfig(ctx, zed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReviewMode != ReviewModeAuto {
+		t.Errorf("Expected the review mode to be updated, got %s", stored.ReviewMode)
+	}
+}
+
+func TestGetDeckConfig(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repo{user: "bob", local: newFakeClient("user-bob")}
+	t.Run("default", func(t *testing.T) {
+		conf, err := repo.GetDeckConfig(ctx, DefaultDeckConfigID)
+		checkErr(t, nil, err)
+		if d := diff.Interface(defaultDeckConfig(), conf); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		_, err := repo.GetDeckConfig(ctx, "dconf-missing")
+		if kivik.StatusCode(err) != kivik.StatusNotFound {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	})
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).GetDeckConfig(ctx, DefaultDeckConfigID)
+		checkErr(t, "not logged in", err)
+	})
+}
+
+func TestSetDeckConfig(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repo{user: "bob", local: newFakeClient("user-bob")}
+
+	conf, err := repo.DeckConfigForDeck(ctx, "deck-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ID != DefaultDeckConfigID {
+		t.Errorf("Expected default config, got %s", conf.ID)
+	}
+
+	err = repo.SetDeckConfig(ctx, "deck-foo", "dconf-missing")
+	checkErr(t, "deck config: missing", err)
+
+	custom, err := repo.CreateDeckConfig(ctx, "Custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, deckID := range []string{"deck-foo", "deck-bar"} {
+		if e := repo.SetDeckConfig(ctx, deckID, custom.ID); e != nil {
+			t.Fatal(e)
+		}
+	}
+	// Setting a second time updates the existing reference
+	if e := repo.SetDeckConfig(ctx, "deck-foo", custom.ID); e != nil {
+		t.Fatal(e)
+	}
+	conf, err = repo.DeckConfigForDeck(ctx, "deck-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ID != custom.ID {
+		t.Errorf("Expected custom config, got %s", conf.ID)
+	}
+
+	checkErr(t, "the default deck config cannot be deleted", repo.DeleteDeckConfig(ctx, DefaultDeckConfigID))
+	if e := repo.DeleteDeckConfig(ctx, custom.ID); e != nil {
+		t.Fatal(e)
+	}
+	for _, deckID := range []string{"deck-foo", "deck-bar"} {
+		conf, err = repo.DeckConfigForDeck(ctx, deckID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.ID != DefaultDeckConfigID {
+			t.Errorf("%s: Expected default config after delete, got %s", deckID, conf.ID)
+		}
+	}
+}
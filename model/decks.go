@@ -24,13 +24,24 @@ type Deck struct {
 	SuspendedCards int
 }
 
-// DeckList returns a complete list of decks available for study.
+// DeckList returns a complete list of decks available for study. Where
+// possible, the list is calculated from a cache which is updated incrementally
+// from the changes feed, rather than by querying the index.
 func (r *Repo) DeckList(ctx context.Context) ([]*Deck, error) {
 	defer profile("deck list")()
 	udb, err := r.userDB(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if r.state != nil {
+		decks, e := r.cachedDeckList(ctx, udb)
+		if kivik.StatusCode(e) != kivik.StatusNotImplemented {
+			if e != nil {
+				return nil, e
+			}
+			return summarizeDecks(decks), nil
+		}
+	}
 	if e := checkDDocVersion(ctx, udb); e != nil {
 		return nil, e
 	}
@@ -43,6 +54,11 @@ func (r *Repo) DeckList(ctx context.Context) ([]*Deck, error) {
 	if err := fleshenDecks(ctx, udb, decks); err != nil {
 		return nil, err
 	}
+	return summarizeDecks(decks), nil
+}
+
+// summarizeDecks sorts decks by name, and prepends the aggregate 'all' deck.
+func summarizeDecks(decks []*Deck) []*Deck {
 	sort.Slice(decks, func(i, j int) bool {
 		return decks[i].Name < decks[j].Name
 	})
@@ -58,7 +74,7 @@ func (r *Repo) DeckList(ctx context.Context) ([]*Deck, error) {
 		allDeck.NewCards += deck.NewCards
 		allDeck.SuspendedCards += deck.SuspendedCards
 	}
-	return append([]*Deck{allDeck}, decks...), nil
+	return append([]*Deck{allDeck}, decks...)
 }
 
 func fleshenDecks(ctx context.Context, db kivikDB, decks []*Deck) error {
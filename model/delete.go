@@ -0,0 +1,214 @@
+package model
+
+import (
+	"context"
+
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// DeleteNotes deletes the requested notes, along with their cards and tags,
+// and the links to the notes they were cloned from, if any. The cards are also
+// removed from any decks which contain them. The notes' attachments are stored
+// inline, so are removed along with the notes. If prune is true, models which
+// are no longer used by any note are removed from their themes, and themes
+// left with no models are deleted.
+//
+// Documents are deleted, rather than purged, so that the deletions are
+// replicated to the server, and to other devices, by Sync.
+func (r *Repo) DeleteNotes(ctx context.Context, noteIDs []string, prune bool) error {
+	defer profile("DeleteNotes")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	for _, noteID := range noteIDs {
+		bundle, bdb, note, err := r.findNote(ctx, udb, noteID)
+		if err != nil {
+			return err
+		}
+		cards, err := noteCards(ctx, udb, bundle.ID, note.ID)
+		if err != nil {
+			return err
+		}
+		if e := deleteCards(ctx, udb, cards, udb, bdb); e != nil {
+			return e
+		}
+		if e := deleteTags(ctx, bdb, note.ID); e != nil {
+			return e
+		}
+		if e := deleteNoteLink(ctx, bdb, note.ID); e != nil {
+			return e
+		}
+		if _, e := bdb.Delete(ctx, note.ID, note.Rev); e != nil {
+			return errors.Wrapf(e, "failed to delete %s", note.ID)
+		}
+		if !prune {
+			continue
+		}
+		if e := pruneModel(ctx, bdb, note.ThemeID, note.ModelID); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// DeleteCards deletes the requested cards from the user database, and removes
+// them from any decks which contain them. Decks stored in a bundle database
+// are only updated if the bundle belongs to the current user. The cards' notes
+// are not affected.
+func (r *Repo) DeleteCards(ctx context.Context, cardIDs []string) error {
+	defer profile("DeleteCards")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	byBundle := make(map[string][]*fb.Card)
+	for _, id := range cardIDs {
+		card := &fb.Card{}
+		if e := getDoc(ctx, udb, id, card); e != nil {
+			return wrapStatus(e, id)
+		}
+		byBundle[card.BundleID()] = append(byBundle[card.BundleID()], card)
+	}
+	for bundleID, cards := range byBundle {
+		dbs := []kivikDB{udb}
+		_, err := r.ownedBundle(ctx, udb, bundleID)
+		switch kivik.StatusCode(err) {
+		case 0:
+			bdb, e := r.newDB(ctx, bundleID)
+			if e != nil {
+				return e
+			}
+			dbs = append(dbs, bdb)
+		case kivik.StatusForbidden:
+		default:
+			return err
+		}
+		if e := deleteCards(ctx, udb, cards, dbs...); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// deleteCards removes the cards from all decks found in deckDBs, then deletes
+// them, and their tags, from udb.
+func deleteCards(ctx context.Context, udb getPutDeleter, cards []*fb.Card, deckDBs ...kivikDB) error {
+	if len(cards) == 0 {
+		return nil
+	}
+	remove := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		remove[card.ID] = true
+	}
+	for _, db := range deckDBs {
+		if e := removeCardsFromAllDecks(ctx, db, remove); e != nil {
+			return e
+		}
+	}
+	for _, card := range cards {
+		if e := deleteTags(ctx, udb, card.ID); e != nil {
+			return e
+		}
+		if _, e := udb.Delete(ctx, card.ID, card.Rev); e != nil {
+			return errors.Wrapf(e, "failed to delete %s", card.ID)
+		}
+	}
+	return nil
+}
+
+type allDocPutter interface {
+	allDocer
+	putter
+}
+
+// removeCardsFromAllDecks removes the cards from every deck in db.
+func removeCardsFromAllDecks(ctx context.Context, db allDocPutter, remove map[string]bool) error {
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    "deck-",
+		"end_key":      "deck-" + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch decks")
+	}
+	defer func() { _ = rows.Close() }()
+	decks := make([]*fb.Deck, 0)
+	for rows.Next() {
+		deck := &fb.Deck{}
+		if e := rows.ScanDoc(deck); e != nil {
+			return errors.Wrap(e, "scan deck")
+		}
+		if removeCards(deck, remove) {
+			decks = append(decks, deck)
+		}
+	}
+	if e := rows.Err(); e != nil {
+		return e
+	}
+	for _, deck := range decks {
+		if _, e := db.Put(ctx, deck.ID, deck); e != nil {
+			return errors.Wrap(e, "failed to update deck")
+		}
+	}
+	return nil
+}
+
+// pruneModel removes the model from its theme, if it is no longer used by any
+// note in db. If the theme is then left with no models, it is deleted, along
+// with the link to its parent, if it is a clone.
+func pruneModel(ctx context.Context, db kivikDB, themeID string, modelID uint32) error {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": map[string]interface{}{
+			"type":  "note",
+			"theme": themeID,
+			"model": modelID,
+		},
+		"fields": []string{"_id"},
+		"limit":  1,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to find notes")
+	}
+	used := rows.Next()
+	_ = rows.Close()
+	if used {
+		return nil
+	}
+	theme := &fb.Theme{}
+	if e := getDoc(ctx, db, themeID, theme); e != nil {
+		if kivik.StatusCode(e) == kivik.StatusNotFound {
+			return nil
+		}
+		return errors.Wrap(e, "theme")
+	}
+	model, err := themeModel(theme, modelID)
+	if err != nil {
+		// Already pruned
+		return nil
+	}
+	if len(theme.Models) == 1 {
+		if _, e := db.Delete(ctx, theme.ID, theme.Rev); e != nil {
+			return errors.Wrapf(e, "failed to delete %s", theme.ID)
+		}
+		return deleteThemeLink(ctx, db, theme.ID)
+	}
+	for i, m := range theme.Models {
+		if m == model {
+			theme.Models = append(theme.Models[:i], theme.Models[i+1:]...)
+			break
+		}
+	}
+	// Removes the model's files from the theme's attachments
+	if e := theme.Attachments.RemoveView(model.Files); e != nil {
+		return errors.Wrap(e, "failed to remove model files")
+	}
+	theme.Modified = now().UTC()
+	if _, e := db.Put(ctx, theme.ID, theme); e != nil {
+		return errors.Wrap(e, "failed to update theme")
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func checkDeckCards(t *testing.T, db kivikDB, expected []string) {
+	t.Helper()
+	deck, err := getDeck(context.Background(), db, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diff.Interface(expected, deck.Cards.All()); d != nil {
+		t.Errorf("%s deck: %s", db.Name(), d)
+	}
+}
+
+func checkDeleted(t *testing.T, db kivikDB, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		_, err := db.Get(context.Background(), id)
+		if status := kivik.StatusCode(err); status != kivik.StatusNotFound {
+			t.Errorf("Expected %s to be deleted, got status %d", id, status)
+		}
+	}
+}
+
+func TestDeleteNotes(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	note1, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "foo", "Back": "bar"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idSource = bytes.NewReader(bytes.Repeat([]byte{1}, 8))
+	note2, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "baz"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		err := repo.DeleteNotes(ctx, []string{"note-Zm9v"}, false)
+		checkErr(t, "note not found", err)
+	})
+	t.Run("model in use", func(t *testing.T) {
+		if err := repo.DeleteNotes(ctx, []string{note1.ID}, true); err != nil {
+			t.Fatal(err)
+		}
+		checkDeleted(t, udb, "card-mjxwe.Ym9iAAAAAAAAAAA.0", "card-mjxwe.Ym9iAAAAAAAAAAA.1")
+		checkDeleted(t, bdb, note1.ID)
+		checkDeckCards(t, udb, []string{"card-mjxwe.Ym9iAQEBAQEBAQE.0"})
+		checkDeckCards(t, bdb, []string{"card-mjxwe.Ym9iAQEBAQEBAQE.0"})
+		if _, err := fetchModel(ctx, bdb, testModelID); err != nil {
+			t.Errorf("Model should not have been pruned: %s", err)
+		}
+	})
+	t.Run("prune theme", func(t *testing.T) {
+		if err := repo.DeleteNotes(ctx, []string{note2.ID}, true); err != nil {
+			t.Fatal(err)
+		}
+		checkDeleted(t, udb, "card-mjxwe.Ym9iAQEBAQEBAQE.0")
+		checkDeleted(t, bdb, note2.ID, testThemeID)
+		checkDeckCards(t, udb, []string{})
+		checkDeckCards(t, bdb, []string{})
+	})
+}
+
+func TestDeleteNotesNoPrune(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	_, bdb := testDBs(t, client)
+	note, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "foo"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := repo.DeleteNotes(ctx, []string{note.ID}, false); e != nil {
+		t.Fatal(e)
+	}
+	checkDeleted(t, bdb, note.ID)
+	if _, err := fetchModel(ctx, bdb, testModelID); err != nil {
+		t.Errorf("Model should not have been pruned: %s", err)
+	}
+}
+
+func TestPruneModel(t *testing.T) {
+	_, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	_, bdb := testDBs(t, client)
+	theme := &fb.Theme{}
+	if err := getDoc(ctx, bdb, testThemeID, theme); err != nil {
+		t.Fatal(err)
+	}
+	model, err := theme.NewModel("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := model.AddFile("other.txt", "text/plain", []byte("x")); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := bdb.Put(ctx, theme.ID, theme); e != nil {
+		t.Fatal(e)
+	}
+	if e := pruneModel(ctx, bdb, testThemeID, 0); e != nil {
+		t.Fatal(e)
+	}
+	theme = &fb.Theme{}
+	if err := getDoc(ctx, bdb, testThemeID, theme); err != nil {
+		t.Fatal(err)
+	}
+	if len(theme.Models) != 1 || theme.Models[0].ID != 1 {
+		t.Fatalf("Unexpected models remaining: %v", theme.Models)
+	}
+	if _, ok := theme.Attachments.GetFile("$template.0.html"); ok {
+		t.Errorf("Pruned model's files should have been removed")
+	}
+	if _, ok := theme.Attachments.GetFile("other.txt"); !ok {
+		t.Errorf("Remaining model's files should have been kept")
+	}
+}
+
+func TestDeleteCards(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	note, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "foo", "Back": "bar"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		err := repo.DeleteCards(ctx, []string{"card-mjxwe.Zm9v.0"})
+		checkErr(t, "card-mjxwe.Zm9v.0: missing", err)
+		if status := kivik.StatusCode(err); status != kivik.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+	t.Run("owned", func(t *testing.T) {
+		if err := repo.DeleteCards(ctx, []string{"card-mjxwe.Ym9iAAAAAAAAAAA.1"}); err != nil {
+			t.Fatal(err)
+		}
+		checkDeleted(t, udb, "card-mjxwe.Ym9iAAAAAAAAAAA.1")
+		checkDeckCards(t, udb, []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0"})
+		checkDeckCards(t, bdb, []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0"})
+		if _, err := bdb.Get(ctx, note.ID); err != nil {
+			t.Errorf("Note should not have been deleted: %s", err)
+		}
+	})
+	t.Run("not owned", func(t *testing.T) {
+		bundle := &fb.Bundle{}
+		if err := getDoc(ctx, udb, testBundleID, bundle); err != nil {
+			t.Fatal(err)
+		}
+		bundle.Owner = "nrqwe"
+		if _, err := udb.Put(ctx, bundle.ID, bundle); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.DeleteCards(ctx, []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0"}); err != nil {
+			t.Fatal(err)
+		}
+		checkDeleted(t, udb, "card-mjxwe.Ym9iAAAAAAAAAAA.0")
+		checkDeckCards(t, udb, []string{})
+		checkDeckCards(t, bdb, []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0"})
+	})
+}
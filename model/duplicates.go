@@ -0,0 +1,255 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// DuplicateOptions configures FindDuplicates.
+type DuplicateOptions struct {
+	// BundleID limits the search to a single bundle. Otherwise, notes in all
+	// of the user's bundles are compared.
+	BundleID string
+	// ModelID limits the search to notes of a single model, in the format
+	// theme-<theme>/<model>. Otherwise, notes of all models are compared.
+	ModelID string
+	// Field is the name of the field to compare. Notes whose model has no
+	// such field are ignored. If empty, each note's first field is compared.
+	Field string
+}
+
+// DuplicateNote is a member of a DuplicateGroup.
+type DuplicateNote struct {
+	BundleID string    `json:"bundle"`
+	NoteID   string    `json:"note"`
+	Created  time.Time `json:"created"`
+}
+
+// DuplicateGroup is a set of notes whose compared fields are equal, after
+// normalization. The oldest note is listed first.
+type DuplicateGroup struct {
+	// Text is the normalized text shared by the group.
+	Text  string           `json:"text"`
+	Notes []*DuplicateNote `json:"notes"`
+}
+
+// FindDuplicates finds groups of notes which have the same value in the
+// compared field, once HTML markup is removed, and whitespace, case and
+// Unicode representation are normalized. The groups are sorted by text.
+func (r *Repo) FindDuplicates(ctx context.Context, opts DuplicateOptions) ([]*DuplicateGroup, error) {
+	defer profile("FindDuplicates")()
+	var themeID string
+	var modelID uint32
+	if opts.ModelID != "" {
+		var err error
+		if themeID, modelID, err = parseModelID(opts.ModelID); err != nil {
+			return nil, err
+		}
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundleIDs := []string{opts.BundleID}
+	if opts.BundleID == "" {
+		if bundleIDs, err = getBundleIDs(ctx, udb); err != nil {
+			return nil, err
+		}
+	}
+	groups := make(map[string]*DuplicateGroup)
+	for _, bundleID := range bundleIDs {
+		bdb, err := r.newDB(ctx, bundleID)
+		if err != nil {
+			return nil, err
+		}
+		notes, err := bundleNotes(ctx, bdb)
+		if err != nil {
+			return nil, err
+		}
+		models := make(map[string]*fb.Model)
+		for _, note := range notes {
+			if themeID != "" && (note.ThemeID != themeID || note.ModelID != modelID) {
+				continue
+			}
+			id := fmt.Sprintf("%s/%d", note.ThemeID, note.ModelID)
+			model, ok := models[id]
+			if !ok {
+				if model, err = fetchModel(ctx, bdb, id); err != nil {
+					return nil, err
+				}
+				models[id] = model
+			}
+			i := 0
+			if opts.Field != "" {
+				if i = fieldIndex(model, opts.Field); i < 0 {
+					continue
+				}
+			}
+			if i >= len(note.FieldValues) || note.FieldValues[i] == nil {
+				continue
+			}
+			if t := model.Fields[i].Type; t != fb.TextField && t != fb.AnkiField {
+				continue
+			}
+			text, err := normalizeFieldText(note.FieldValues[i].Text)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s", note.ID)
+			}
+			if text == "" {
+				continue
+			}
+			group, ok := groups[text]
+			if !ok {
+				group = &DuplicateGroup{Text: text}
+				groups[text] = group
+			}
+			group.Notes = append(group.Notes, &DuplicateNote{BundleID: bundleID, NoteID: note.ID, Created: note.Created})
+		}
+	}
+	result := make([]*DuplicateGroup, 0)
+	for _, group := range groups {
+		if len(group.Notes) < 2 {
+			continue
+		}
+		sort.Slice(group.Notes, func(i, j int) bool {
+			a, b := group.Notes[i], group.Notes[j]
+			if !a.Created.Equal(b.Created) {
+				return a.Created.Before(b.Created)
+			}
+			return a.BundleID+a.NoteID < b.BundleID+b.NoteID
+		})
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Text < result[j].Text })
+	return result, nil
+}
+
+// bundleNotes returns all notes in the bundle database.
+func bundleNotes(ctx context.Context, db allDocer) ([]*fb.Note, error) {
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    "note-",
+		"end_key":      "note-" + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch notes")
+	}
+	defer func() { _ = rows.Close() }()
+	notes := make([]*fb.Note, 0)
+	for rows.Next() {
+		note := &fb.Note{}
+		if e := rows.ScanDoc(note); e != nil {
+			return nil, errors.Wrap(e, "scan note")
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// normalizeFieldText returns the text content of an HTML field value, in
+// lower case, NFC normalized, and with runs of whitespace collapsed.
+func normalizeFieldText(value string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(value))
+	if err != nil {
+		return "", err
+	}
+	text := norm.NFC.String(doc.Text())
+	return strings.ToLower(strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")), nil
+}
+
+// DuplicateAction is a means of resolving a group of duplicate notes.
+type DuplicateAction int
+
+const (
+	// SuspendDuplicates suspends the cards of every note in the group but
+	// the first.
+	SuspendDuplicates DuplicateAction = iota
+	// MergeDuplicates merges the review histories of the cards of every note
+	// in the group into the corresponding cards (by template) of the first
+	// note, then suspends them as with SuspendDuplicates.
+	MergeDuplicates
+)
+
+// ResolveDuplicates resolves a group of duplicate notes, as returned by
+// FindDuplicates, keeping the first note. The group may be reordered to keep a
+// different note. Only cards are modified, so the notes' bundles need not be
+// owned by the current user.
+func (r *Repo) ResolveDuplicates(ctx context.Context, group *DuplicateGroup, action DuplicateAction) error {
+	defer profile("ResolveDuplicates")()
+	if len(group.Notes) < 2 {
+		return kerrors.Status(kivik.StatusBadRequest, "at least two notes required")
+	}
+	if action != SuspendDuplicates && action != MergeDuplicates {
+		return kerrors.Statusf(kivik.StatusBadRequest, "unknown action %d", action)
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	cardSets := make([][]*fb.Card, len(group.Notes))
+	for i, note := range group.Notes {
+		if cardSets[i], err = noteCards(ctx, udb, note.BundleID, note.NoteID); err != nil {
+			return err
+		}
+	}
+	ts := now().UTC()
+	if action == MergeDuplicates {
+		keep := make(map[uint32]*fb.Card, len(cardSets[0]))
+		for _, card := range cardSets[0] {
+			keep[card.TemplateID()] = card
+		}
+		for _, cards := range cardSets[1:] {
+			for _, card := range cards {
+				if target, ok := keep[card.TemplateID()]; ok {
+					mergeReviews(target, card)
+				}
+			}
+		}
+		for _, card := range cardSets[0] {
+			card.Modified = ts
+			if _, e := udb.Put(ctx, card.ID, card); e != nil {
+				return errors.Wrapf(e, "failed to save %s", card.ID)
+			}
+		}
+	}
+	for _, cards := range cardSets[1:] {
+		for _, card := range cards {
+			if card.Suspended {
+				continue
+			}
+			card.Suspended = true
+			card.Modified = ts
+			if _, e := udb.Put(ctx, card.ID, card); e != nil {
+				return errors.Wrapf(e, "failed to save %s", card.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeReviews merges the review history of src into dst. The schedule of
+// whichever card was reviewed most recently is kept, including its review
+// count, which the scheduler treats as the number of consecutive successful
+// reviews.
+func mergeReviews(dst, src *fb.Card) {
+	if src.LastReview.After(dst.LastReview) {
+		dst.LastReview = src.LastReview
+		dst.Due = src.Due
+		dst.Interval = src.Interval
+		dst.EaseFactor = src.EaseFactor
+		dst.BuriedUntil = src.BuriedUntil
+		dst.ReviewCount = src.ReviewCount
+	}
+}
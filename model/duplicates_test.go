@@ -0,0 +1,191 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestNormalizeFieldText(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "", expected: ""},
+		{input: "<b>Hola</b>", expected: "hola"},
+		{input: "  hola\n <i>mundo</i>&nbsp;", expected: "hola mundo"},
+		{input: "café", expected: "café"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			result, err := normalizeFieldText(test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	note1, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "<b>Hola</b>", "Back": "hello"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idSource = bytes.NewReader(bytes.Repeat([]byte{1}, 8))
+	note2, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "hola ", "Back": "hi"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idSource = bytes.NewReader(bytes.Repeat([]byte{2}, 8))
+	if _, _, e := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "adios", "Back": "hi"}, testDeckID); e != nil {
+		t.Fatal(e)
+	}
+	dupe := func(noteID string) *DuplicateNote {
+		note := &fb.Note{}
+		if e := getDoc(ctx, bdb, noteID, note); e != nil {
+			t.Fatal(e)
+		}
+		return &DuplicateNote{BundleID: testBundleID, NoteID: noteID, Created: note.Created}
+	}
+
+	t.Run("Find", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			opts     DuplicateOptions
+			expected []*DuplicateGroup
+			err      string
+			status   int
+		}{
+			{
+				name: "first field",
+				opts: DuplicateOptions{},
+				expected: []*DuplicateGroup{
+					{Text: "hola", Notes: []*DuplicateNote{
+						dupe(note1.ID),
+						dupe(note2.ID),
+					}},
+				},
+			},
+			{
+				name: "named field",
+				opts: DuplicateOptions{BundleID: testBundleID, ModelID: testModelID, Field: "Back"},
+				expected: []*DuplicateGroup{
+					{Text: "hi", Notes: []*DuplicateNote{
+						dupe(note2.ID),
+						dupe("note-Ym9iAgICAgICAgI"),
+					}},
+				},
+			},
+			{
+				name:     "unknown field",
+				opts:     DuplicateOptions{Field: "Foo"},
+				expected: []*DuplicateGroup{},
+			},
+			{
+				name:   "invalid model",
+				opts:   DuplicateOptions{ModelID: "foo"},
+				err:    "invalid model ID",
+				status: kivik.StatusBadRequest,
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				result, err := repo.FindDuplicates(ctx, test.opts)
+				checkErr(t, test.err, err)
+				if err != nil {
+					if status := kivik.StatusCode(err); status != test.status {
+						t.Errorf("Unexpected status: %d", status)
+					}
+					return
+				}
+				if d := diff.Interface(test.expected, result); d != nil {
+					t.Error(d)
+				}
+			})
+		}
+	})
+
+	t.Run("Resolve", func(t *testing.T) {
+		const (
+			card10 = "card-mjxwe.Ym9iAAAAAAAAAAA.0"
+			card20 = "card-mjxwe.Ym9iAQEBAQEBAQE.0"
+			card21 = "card-mjxwe.Ym9iAQEBAQEBAQE.1"
+		)
+		update := func(id string, fn func(*fb.Card)) {
+			card := &fb.Card{}
+			if e := getDoc(ctx, udb, id, card); e != nil {
+				t.Fatal(e)
+			}
+			fn(card)
+			if _, e := udb.Put(ctx, card.ID, card); e != nil {
+				t.Fatal(e)
+			}
+		}
+		update(card10, func(card *fb.Card) {
+			card.ReviewCount = 2
+			card.LastReview = parseTime(t, "2016-12-01T00:00:00Z")
+			card.Due = parseDue(t, "2016-12-05")
+			card.Interval = 4 * fb.Day
+		})
+		update(card20, func(card *fb.Card) {
+			card.ReviewCount = 3
+			card.LastReview = parseTime(t, "2016-12-20T00:00:00Z")
+			card.Due = parseDue(t, "2017-01-10")
+			card.Interval = 21 * fb.Day
+			card.EaseFactor = 2.2
+		})
+
+		group := &DuplicateGroup{Notes: []*DuplicateNote{{BundleID: testBundleID, NoteID: note1.ID}}}
+		err := repo.ResolveDuplicates(ctx, group, MergeDuplicates)
+		checkErr(t, "at least two notes required", err)
+
+		group.Notes = append(group.Notes, &DuplicateNote{BundleID: testBundleID, NoteID: note2.ID})
+		if e := repo.ResolveDuplicates(ctx, group, MergeDuplicates); e != nil {
+			t.Fatal(e)
+		}
+		kept := &fb.Card{}
+		if e := getDoc(ctx, udb, card10, kept); e != nil {
+			t.Fatal(e)
+		}
+		if kept.ReviewCount != 3 {
+			t.Errorf("Unexpected review count: %d", kept.ReviewCount)
+		}
+		if kept.Interval != 21*fb.Day || kept.EaseFactor != 2.2 {
+			t.Errorf("Unexpected schedule: %v, %v", kept.Interval, kept.EaseFactor)
+		}
+		if kept.Suspended {
+			t.Error("Kept card should not be suspended")
+		}
+		for _, id := range []string{card20, card21} {
+			card := &fb.Card{}
+			if e := getDoc(ctx, udb, id, card); e != nil {
+				t.Fatal(e)
+			}
+			if !card.Suspended {
+				t.Errorf("%s should be suspended", id)
+			}
+		}
+	})
+}
+
+func TestMergeReviews(t *testing.T) {
+	recent := &fb.Card{LastReview: parseTime(t, "2016-12-20T00:00:00Z"), Interval: fb.Day, ReviewCount: 0}
+	older := &fb.Card{LastReview: parseTime(t, "2016-12-01T00:00:00Z"), Interval: 30 * fb.Day, ReviewCount: 6}
+	mergeReviews(recent, older)
+	// The recent lapse resets the count, which must not be combined
+	if recent.ReviewCount != 0 || recent.Interval != fb.Day {
+		t.Errorf("Unexpected schedule: count %d, interval %v", recent.ReviewCount, recent.Interval)
+	}
+}
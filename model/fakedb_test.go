@@ -0,0 +1,545 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/flimzy/kivik"
+	"github.com/flimzy/kivik/errors"
+)
+
+// fakeClient is a minimal in-memory kivikClient, which, unlike the memory
+// driver, supports revisions, attachments, and AllDocs ranges.
+type fakeClient struct {
+	kivikClient
+	mu  sync.Mutex
+	dbs map[string]*fakeDB
+}
+
+var _ kivikClient = &fakeClient{}
+
+func newFakeClient(dbNames ...string) *fakeClient {
+	c := &fakeClient{dbs: make(map[string]*fakeDB)}
+	for _, name := range dbNames {
+		_ = c.CreateDB(context.Background(), name)
+	}
+	return c
+}
+
+func (c *fakeClient) CreateDB(_ context.Context, dbName string, _ ...kivik.Options) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.dbs[dbName]; ok {
+		return errors.Status(kivik.StatusPreconditionFailed, "database exists")
+	}
+	c.dbs[dbName] = &fakeDB{
+		name:   dbName,
+		client: c,
+		docs:   make(map[string]*fakeDoc),
+	}
+	return nil
+}
+
+func (c *fakeClient) DB(_ context.Context, dbName string, _ ...kivik.Options) (kivikDB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	db, ok := c.dbs[dbName]
+	if !ok {
+		return nil, errors.Status(kivik.StatusNotFound, "database does not exist")
+	}
+	return db, nil
+}
+
+func (c *fakeClient) DestroyDB(_ context.Context, dbName string, _ ...kivik.Options) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.dbs[dbName]; !ok {
+		return errors.Status(kivik.StatusNotFound, "database does not exist")
+	}
+	delete(c.dbs, dbName)
+	return nil
+}
+
+func (c *fakeClient) DSN() string { return "" }
+
+type fakeDoc struct {
+	seq     int
+	rev     int
+	deleted bool
+	doc     map[string]interface{}
+}
+
+func (d *fakeDoc) revID() string {
+	return fmt.Sprintf("%d-%032x", d.rev, d.rev)
+}
+
+type fakeDB struct {
+	kivikDB
+	name   string
+	client *fakeClient
+	mu     sync.Mutex
+	seq    int
+	docs   map[string]*fakeDoc
+}
+
+var _ kivikDB = &fakeDB{}
+
+func toMap(doc interface{}) (map[string]interface{}, error) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (db *fakeDB) Client() kivikClient { return db.client }
+func (db *fakeDB) Name() string        { return db.name }
+
+func (db *fakeDB) Stats(_ context.Context) (*kivik.DBStats, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var count, deleted int64
+	for _, d := range db.docs {
+		if d.deleted {
+			deleted++
+			continue
+		}
+		count++
+	}
+	return &kivik.DBStats{
+		Name:         db.name,
+		DocCount:     count,
+		DeletedCount: deleted,
+		UpdateSeq:    strconv.Itoa(db.seq),
+	}, nil
+}
+
+// doc returns the stored document, with _id and _rev set.
+func (db *fakeDB) doc(id string) (map[string]interface{}, bool) {
+	d, ok := db.docs[id]
+	if !ok || d.deleted {
+		return nil, false
+	}
+	doc := make(map[string]interface{}, len(d.doc)+2)
+	for k, v := range d.doc {
+		doc[k] = v
+	}
+	doc["_id"] = id
+	doc["_rev"] = d.revID()
+	return doc, true
+}
+
+func (db *fakeDB) Get(_ context.Context, docID string, _ ...kivik.Options) (kivikRow, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	doc, ok := db.doc(docID)
+	if !ok {
+		return nil, errors.Status(kivik.StatusNotFound, "missing")
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return mockRow(body), nil
+}
+
+func (db *fakeDB) put(docID string, doc interface{}) (string, error) {
+	m, err := toMap(doc)
+	if err != nil {
+		return "", err
+	}
+	if docID == "" {
+		docID, _ = m["_id"].(string)
+	}
+	if docID == "" {
+		return "", errors.Status(kivik.StatusBadRequest, "document id required")
+	}
+	rev, _ := m["_rev"].(string)
+	delete(m, "_id")
+	delete(m, "_rev")
+	d, ok := db.docs[docID]
+	switch {
+	case !ok:
+		if rev != "" {
+			return "", errors.Status(kivik.StatusConflict, "document update conflict")
+		}
+		d = &fakeDoc{}
+		db.docs[docID] = d
+	case d.deleted:
+		if rev != "" && rev != d.revID() {
+			return "", errors.Status(kivik.StatusConflict, "document update conflict")
+		}
+	case rev != d.revID():
+		return "", errors.Status(kivik.StatusConflict, "document update conflict")
+	}
+	d.rev++
+	d.deleted = false
+	d.doc = m
+	db.seq++
+	d.seq = db.seq
+	return d.revID(), nil
+}
+
+func (db *fakeDB) Put(_ context.Context, docID string, doc interface{}) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.put(docID, doc)
+}
+
+func (db *fakeDB) Delete(_ context.Context, docID, rev string) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	d, ok := db.docs[docID]
+	if !ok || d.deleted {
+		return "", errors.Status(kivik.StatusNotFound, "missing")
+	}
+	if rev != d.revID() {
+		return "", errors.Status(kivik.StatusConflict, "document update conflict")
+	}
+	d.rev++
+	d.deleted = true
+	d.doc = nil
+	db.seq++
+	d.seq = db.seq
+	return d.revID(), nil
+}
+
+func (db *fakeDB) BulkDocs(_ context.Context, docs interface{}) (kivikBulkResults, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	v := reflect.ValueOf(docs)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, errors.Status(kivik.StatusBadRequest, "docs must be an array")
+	}
+	results := &mockBulkResults{}
+	for i := 0; i < v.Len(); i++ {
+		_, err := db.put("", v.Index(i).Interface())
+		results.errs = append(results.errs, err)
+	}
+	return results, nil
+}
+
+func (db *fakeDB) GetAttachment(_ context.Context, docID, _, filename string) (*kivik.Attachment, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	doc, ok := db.doc(docID)
+	if !ok {
+		return nil, errors.Status(kivik.StatusNotFound, "missing")
+	}
+	atts, _ := doc["_attachments"].(map[string]interface{})
+	att, ok := atts[filename].(map[string]interface{})
+	if !ok {
+		return nil, errors.Status(kivik.StatusNotFound, "missing")
+	}
+	data, _ := att["data"].(string)
+	content, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	contentType, _ := att["content_type"].(string)
+	return kivik.NewAttachment(filename, contentType, ioutil.NopCloser(bytes.NewReader(content))), nil
+}
+
+func (db *fakeDB) Query(_ context.Context, _, _ string, _ ...kivik.Options) (kivikRows, error) {
+	return nil, errors.Status(kivik.StatusNotImplemented, "views not supported")
+}
+
+// sortedIDs returns the IDs of all live documents, in collation order.
+func (db *fakeDB) sortedIDs() []string {
+	ids := make([]string, 0, len(db.docs))
+	for id, d := range db.docs {
+		if !d.deleted {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (db *fakeDB) AllDocs(_ context.Context, options ...kivik.Options) (kivikRows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	opts := kivik.Options{}
+	for _, o := range options {
+		for k, v := range o {
+			opts[k] = v
+		}
+	}
+	for _, alias := range [][2]string{{"start_key", "startkey"}, {"end_key", "endkey"}} {
+		if v, ok := opts[alias[0]]; ok {
+			opts[alias[1]] = v
+		}
+	}
+	startKey, _ := opts["startkey"].(string)
+	endKey, hasEnd := opts["endkey"].(string)
+	includeDocs, _ := opts["include_docs"].(bool)
+	rows := &fakeRows{}
+	for _, id := range db.sortedIDs() {
+		if id < startKey || (hasEnd && id > endKey) {
+			continue
+		}
+		doc, _ := db.doc(id)
+		row := fakeRow{id: id, key: id}
+		row.value, _ = json.Marshal(map[string]string{"rev": doc["_rev"].(string)})
+		if includeDocs {
+			row.doc, _ = json.Marshal(doc)
+		}
+		rows.rows = append(rows.rows, row)
+	}
+	return rows, nil
+}
+
+func (db *fakeDB) Find(_ context.Context, query interface{}) (kivikRows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	q, err := toMap(query)
+	if err != nil {
+		return nil, err
+	}
+	selector, _ := q["selector"].(map[string]interface{})
+	rows := &fakeRows{}
+	for _, id := range db.sortedIDs() {
+		doc, _ := db.doc(id)
+		if !fakeMatch(selector, doc) {
+			continue
+		}
+		row := fakeRow{id: id, key: id}
+		row.doc, _ = json.Marshal(doc)
+		rows.rows = append(rows.rows, row)
+	}
+	return rows, nil
+}
+
+// fakeMatch evaluates a simple Mango selector against doc.
+func fakeMatch(selector map[string]interface{}, doc map[string]interface{}) bool {
+	for field, cond := range selector {
+		switch field {
+		case "$and":
+			for _, sub := range cond.([]interface{}) {
+				if !fakeMatch(sub.(map[string]interface{}), doc) {
+					return false
+				}
+			}
+			continue
+		case "$or":
+			var matched bool
+			for _, sub := range cond.([]interface{}) {
+				if fakeMatch(sub.(map[string]interface{}), doc) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+			continue
+		case "$not":
+			if fakeMatch(cond.(map[string]interface{}), doc) {
+				return false
+			}
+			continue
+		}
+		value, exists := fakeField(doc, field)
+		ops, ok := cond.(map[string]interface{})
+		if !ok {
+			ops = map[string]interface{}{"$eq": cond}
+		}
+		for op, arg := range ops {
+			if !fakeCompare(op, value, exists, arg) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fakeIsOperators returns true if every key of sel is an operator.
+func fakeIsOperators(sel map[string]interface{}) bool {
+	for key := range sel {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+func fakeField(doc map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		if list, ok := cur.([]interface{}); ok {
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(list) {
+				return nil, false
+			}
+			cur = list[i]
+			continue
+		}
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func fakeCompare(op string, value interface{}, exists bool, arg interface{}) bool {
+	switch op {
+	case "$exists":
+		return exists == arg.(bool)
+	case "$eq":
+		return exists && reflect.DeepEqual(value, arg)
+	case "$ne":
+		return !reflect.DeepEqual(value, arg)
+	case "$in":
+		for _, a := range arg.([]interface{}) {
+			if reflect.DeepEqual(value, a) {
+				return true
+			}
+		}
+		return false
+	case "$elemMatch":
+		list, _ := value.([]interface{})
+		sel := arg.(map[string]interface{})
+		for _, elem := range list {
+			if m, ok := elem.(map[string]interface{}); ok && !fakeIsOperators(sel) {
+				if fakeMatch(sel, m) {
+					return true
+				}
+				continue
+			}
+			if fakeMatch(map[string]interface{}{"elem": arg}, map[string]interface{}{"elem": elem}) {
+				return true
+			}
+		}
+		return false
+	case "$regex":
+		str, ok := value.(string)
+		return ok && regexp.MustCompile(arg.(string)).MatchString(str)
+	}
+	if !exists {
+		return false
+	}
+	var cmp int
+	switch v := value.(type) {
+	case string:
+		a, ok := arg.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(v, a)
+	case float64:
+		a, ok := arg.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case v < a:
+			cmp = -1
+		case v > a:
+			cmp = 1
+		}
+	default:
+		return false
+	}
+	switch op {
+	case "$lt":
+		return cmp < 0
+	case "$lte":
+		return cmp <= 0
+	case "$gt":
+		return cmp > 0
+	case "$gte":
+		return cmp >= 0
+	}
+	panic("unsupported operator " + op)
+}
+
+func (db *fakeDB) Changes(_ context.Context, options ...kivik.Options) (kivikChanges, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var since int
+	for _, o := range options {
+		if s, ok := o["since"].(string); ok {
+			since, _ = strconv.Atoi(s)
+		}
+	}
+	ids := make([]string, 0, len(db.docs))
+	for id, d := range db.docs {
+		if d.seq > since {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return db.docs[ids[i]].seq < db.docs[ids[j]].seq
+	})
+	changes := &fakeChanges{}
+	for _, id := range ids {
+		d := db.docs[id]
+		doc, ok := db.doc(id)
+		if !ok {
+			doc = map[string]interface{}{"_id": id, "_rev": d.revID(), "_deleted": true}
+		}
+		body, _ := json.Marshal(doc)
+		changes.rows = append(changes.rows, fakeRow{id: id, doc: body})
+		changes.deleted = append(changes.deleted, d.deleted)
+	}
+	return changes, nil
+}
+
+type fakeChanges struct {
+	fakeRows
+	deleted []bool
+}
+
+var _ kivikChanges = &fakeChanges{}
+
+func (c *fakeChanges) Deleted() bool { return c.deleted[c.i-1] }
+
+type fakeRow struct {
+	id, key    string
+	value, doc json.RawMessage
+}
+
+type fakeRows struct {
+	rows []fakeRow
+	i    int
+}
+
+var _ kivikRows = &fakeRows{}
+
+func (r *fakeRows) Close() error     { return nil }
+func (r *fakeRows) Err() error       { return nil }
+func (r *fakeRows) TotalRows() int64 { return int64(len(r.rows)) }
+func (r *fakeRows) Next() bool {
+	r.i++
+	return r.i <= len(r.rows)
+}
+func (r *fakeRows) ID() string  { return r.rows[r.i-1].id }
+func (r *fakeRows) Key() string { return r.rows[r.i-1].key }
+func (r *fakeRows) ScanDoc(d interface{}) error {
+	return json.Unmarshal(r.rows[r.i-1].doc, d)
+}
+func (r *fakeRows) ScanValue(d interface{}) error {
+	return json.Unmarshal(r.rows[r.i-1].value, d)
+}
+func (r *fakeRows) ScanKey(d interface{}) error {
+	key, _ := json.Marshal(r.rows[r.i-1].key)
+	return json.Unmarshal(key, d)
+}
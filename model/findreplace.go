@@ -0,0 +1,258 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// FindReplaceOptions configures FindReplace. Exactly one of BundleID, DeckID
+// or NoteIDs selects the notes to be modified.
+type FindReplaceOptions struct {
+	// BundleID selects all notes in the bundle.
+	BundleID string
+	// DeckID selects the notes of all cards in the deck.
+	DeckID string
+	// NoteIDs selects specific notes, such as those returned by Search.
+	NoteIDs []string
+
+	// Find is the text to find. If Regexp is true, it is a regular expression,
+	// and Replace may refer to submatches, as with regexp.Expand.
+	Find    string
+	Replace string
+	Regexp  bool
+	// IgnoreCase makes matching case-insensitive.
+	IgnoreCase bool
+	// Field limits replacement to the named field. If empty, all text fields
+	// are considered.
+	Field string
+	// DryRun reports the changes which would be made, without making them.
+	DryRun bool
+}
+
+// FieldChange describes a single field value changed by FindReplace.
+type FieldChange struct {
+	NoteID string `json:"note"`
+	Field  string `json:"field"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+func (o *FindReplaceOptions) replacer() (func(string) string, error) {
+	if o.Find == "" {
+		return nil, kerrors.Status(kivik.StatusBadRequest, "search text required")
+	}
+	expr := o.Find
+	if !o.Regexp {
+		if !o.IgnoreCase {
+			return func(s string) string { return strings.Replace(s, o.Find, o.Replace, -1) }, nil
+		}
+		expr = regexp.QuoteMeta(expr)
+	}
+	if o.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, kerrors.WrapStatus(kivik.StatusBadRequest, errors.Wrap(err, "invalid regular expression"))
+	}
+	if !o.Regexp {
+		return func(s string) string { return re.ReplaceAllLiteralString(s, o.Replace) }, nil
+	}
+	return func(s string) string { return re.ReplaceAllString(s, o.Replace) }, nil
+}
+
+// FindReplace finds and replaces text in the text fields of the selected
+// notes, and returns the list of changes made, ordered by note. Only notes in
+// bundles owned by the current user are modified; others are skipped. Cards
+// are regenerated as by UpdateNote, and each modified note's modification
+// time is updated. In dry-run mode, the changes are reported, but not made.
+//
+// Every modified note is checked before any is saved, so if a replacement
+// would leave a note producing no cards, nothing is changed.
+func (r *Repo) FindReplace(ctx context.Context, opts FindReplaceOptions) ([]*FieldChange, error) {
+	defer profile("FindReplace")()
+	replace, err := opts.replacer()
+	if err != nil {
+		return nil, err
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	notesByBundle, err := r.findReplaceNotes(ctx, udb, &opts)
+	if err != nil {
+		return nil, err
+	}
+	bundleIDs := make([]string, 0, len(notesByBundle))
+	for bundleID := range notesByBundle {
+		bundleIDs = append(bundleIDs, bundleID)
+	}
+	sort.Strings(bundleIDs)
+
+	changes := make([]*FieldChange, 0)
+	edits := make([]*noteEdit, 0)
+	for _, bundleID := range bundleIDs {
+		bundle, err := r.ownedBundle(ctx, udb, bundleID)
+		if err != nil {
+			if kivik.StatusCode(err) == kivik.StatusForbidden {
+				continue
+			}
+			return nil, err
+		}
+		bdb, err := r.newDB(ctx, bundleID)
+		if err != nil {
+			return nil, err
+		}
+		models := make(map[string]*fb.Model)
+		noteIDs := notesByBundle[bundleID]
+		sort.Strings(noteIDs)
+		for _, noteID := range noteIDs {
+			note := &fb.Note{}
+			if e := getDoc(ctx, bdb, noteID, note); e != nil {
+				return nil, wrapStatus(e, noteID)
+			}
+			modelID := fmt.Sprintf("%s/%d", note.ThemeID, note.ModelID)
+			model, ok := models[modelID]
+			if !ok {
+				if model, err = fetchModel(ctx, bdb, modelID); err != nil {
+					return nil, err
+				}
+				models[modelID] = model
+			}
+			if e := note.SetModel(model); e != nil {
+				return nil, errors.Wrapf(e, "invalid note %s", noteID)
+			}
+			noteChanges := replaceFields(note, model, opts.Field, replace)
+			if len(noteChanges) == 0 {
+				continue
+			}
+			generated, err := r.generateCards(ctx, bundle, &fbModel{Model: model, db: bdb}, note)
+			if err != nil {
+				return nil, wrapStatus(err, noteID)
+			}
+			if len(generated) == 0 {
+				return nil, kerrors.Status(kivik.StatusBadRequest, fmt.Sprintf("%s: note produces no cards", noteID))
+			}
+			changes = append(changes, noteChanges...)
+			edits = append(edits, &noteEdit{bundle: bundle, bdb: bdb, model: model, note: note})
+		}
+	}
+	if opts.DryRun {
+		return changes, nil
+	}
+	for _, edit := range edits {
+		if _, e := r.regenerateCards(ctx, udb, edit.bdb, edit.bundle, edit.model, edit.note, true); e != nil {
+			return nil, wrapStatus(e, edit.note.ID)
+		}
+	}
+	return changes, nil
+}
+
+// noteEdit is a note modified by FindReplace, to be saved.
+type noteEdit struct {
+	bundle *fb.Bundle
+	bdb    kivikDB
+	model  *fb.Model
+	note   *fb.Note
+}
+
+// replaceFields applies replace to the note's text fields, or only to the
+// named field, if field is not empty, and returns the changes made.
+func replaceFields(note *fb.Note, model *fb.Model, field string, replace func(string) string) []*FieldChange {
+	changes := make([]*FieldChange, 0)
+	for i, f := range model.Fields {
+		if field != "" && f.Name != field {
+			continue
+		}
+		if f.Type != fb.TextField && f.Type != fb.AnkiField {
+			continue
+		}
+		if i >= len(note.FieldValues) || note.FieldValues[i] == nil {
+			continue
+		}
+		fv := note.FieldValues[i]
+		text := replace(fv.Text)
+		if text == fv.Text {
+			continue
+		}
+		changes = append(changes, &FieldChange{NoteID: note.ID, Field: f.Name, Old: fv.Text, New: text})
+		fv.Text = text
+	}
+	return changes
+}
+
+// findReplaceNotes returns the IDs of the notes selected by opts, grouped by
+// bundle ID.
+func (r *Repo) findReplaceNotes(ctx context.Context, udb kivikDB, opts *FindReplaceOptions) (map[string][]string, error) {
+	var scopes int
+	for _, set := range []bool{opts.BundleID != "", opts.DeckID != "", len(opts.NoteIDs) > 0} {
+		if set {
+			scopes++
+		}
+	}
+	if scopes != 1 {
+		return nil, kerrors.Status(kivik.StatusBadRequest, "exactly one of bundle, deck or notes required")
+	}
+	notes := make(map[string][]string)
+	switch {
+	case opts.BundleID != "":
+		// Unlike notes selected by deck, or individually, a whole bundle
+		// owned by someone else is an error.
+		if _, err := r.ownedBundle(ctx, udb, opts.BundleID); err != nil {
+			return nil, err
+		}
+		bdb, err := r.newDB(ctx, opts.BundleID)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := bdb.AllDocs(ctx, kivik.Options{
+			"start_key": "note-",
+			"end_key":   "note-" + kivik.EndKeySuffix,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch notes")
+		}
+		defer func() { _ = rows.Close() }()
+		for rows.Next() {
+			notes[opts.BundleID] = append(notes[opts.BundleID], rows.Key())
+		}
+		return notes, rows.Err()
+	case opts.DeckID != "":
+		cardIDs, err := findIDs(ctx, udb, map[string]interface{}{"type": "card", "deck": opts.DeckID})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch cards")
+		}
+		seen := make(map[string]bool)
+		for _, id := range cardIDs {
+			card := &fb.Card{ID: id}
+			key := card.BundleID() + "/" + card.NoteID()
+			if !seen[key] {
+				seen[key] = true
+				notes[card.BundleID()] = append(notes[card.BundleID()], card.NoteID())
+			}
+		}
+		return notes, nil
+	}
+	seen := make(map[string]bool, len(opts.NoteIDs))
+	for _, noteID := range opts.NoteIDs {
+		if seen[noteID] {
+			continue
+		}
+		seen[noteID] = true
+		bundleID, _, _, err := r.noteDB(ctx, udb, noteID)
+		if err != nil {
+			return nil, wrapStatus(err, noteID)
+		}
+		notes[bundleID] = append(notes[bundleID], noteID)
+	}
+	return notes, nil
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestFindReplace(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	note1, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "colour <b>red</b>", "Back": "the Colour"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idSource = bytes.NewReader(bytes.Repeat([]byte{1}, 8))
+	note2, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "<b>blue</b>"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origNow := now
+	defer func() { now = origNow }()
+	now = func() time.Time { return origNow().Add(time.Hour) }
+
+	fieldText := func(t *testing.T, noteID string, field int) string {
+		t.Helper()
+		note := &fb.Note{}
+		if e := getDoc(ctx, bdb, noteID, note); e != nil {
+			t.Fatal(e)
+		}
+		return note.FieldValues[field].Text
+	}
+
+	tests := []struct {
+		name     string
+		opts     FindReplaceOptions
+		expected []*FieldChange
+		err      string
+		status   int
+	}{
+		{
+			name:   "no search text",
+			opts:   FindReplaceOptions{BundleID: testBundleID},
+			err:    "search text required",
+			status: kivik.StatusBadRequest,
+		},
+		{
+			name:   "no scope",
+			opts:   FindReplaceOptions{Find: "x"},
+			err:    "exactly one of bundle, deck or notes required",
+			status: kivik.StatusBadRequest,
+		},
+		{
+			name:   "invalid regexp",
+			opts:   FindReplaceOptions{BundleID: testBundleID, Find: "(", Regexp: true},
+			err:    "invalid regular expression: error parsing regexp: missing closing ): `(`",
+			status: kivik.StatusBadRequest,
+		},
+		{
+			name:   "unknown note",
+			opts:   FindReplaceOptions{NoteIDs: []string{"note-Zm9v"}, Find: "x"},
+			err:    "note-Zm9v: note not found",
+			status: kivik.StatusNotFound,
+		},
+		{
+			name: "dry run",
+			opts: FindReplaceOptions{BundleID: testBundleID, Find: "colour", Replace: "color", IgnoreCase: true, DryRun: true},
+			expected: []*FieldChange{
+				{NoteID: note1.ID, Field: "Front", Old: "colour <b>red</b>", New: "color <b>red</b>"},
+				{NoteID: note1.ID, Field: "Back", Old: "the Colour", New: "the color"},
+			},
+		},
+		{
+			name: "case sensitive",
+			opts: FindReplaceOptions{DeckID: testDeckID, Find: "colour", Replace: "color"},
+			expected: []*FieldChange{
+				{NoteID: note1.ID, Field: "Front", Old: "colour <b>red</b>", New: "color <b>red</b>"},
+			},
+		},
+		{
+			name: "regexp in one field",
+			opts: FindReplaceOptions{NoteIDs: []string{note1.ID, note2.ID}, Find: `<b>(.*?)</b>`, Replace: "<i>$1</i>", Regexp: true, Field: "Front"},
+			expected: []*FieldChange{
+				{NoteID: note1.ID, Field: "Front", Old: "color <b>red</b>", New: "color <i>red</i>"},
+				{NoteID: note2.ID, Field: "Front", Old: "<b>blue</b>", New: "<i>blue</i>"},
+			},
+		},
+		{
+			name:   "note left without cards",
+			opts:   FindReplaceOptions{BundleID: testBundleID, Find: ".+", Regexp: true, Field: "Front"},
+			err:    note2.ID + ": note produces no cards",
+			status: kivik.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := repo.FindReplace(ctx, test.opts)
+			checkErr(t, test.err, err)
+			if err != nil {
+				if status := kivik.StatusCode(err); status != test.status {
+					t.Errorf("Unexpected status: %d", status)
+				}
+				return
+			}
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+
+	if text := fieldText(t, note1.ID, 1); text != "the Colour" {
+		t.Errorf("Dry run should not have modified the note: %s", text)
+	}
+	if text := fieldText(t, note1.ID, 0); text != "color <i>red</i>" {
+		t.Errorf("Failed replacement should not have modified any note: %s", text)
+	}
+	if text := fieldText(t, note2.ID, 0); text != "<i>blue</i>" {
+		t.Errorf("Unexpected stored text: %s", text)
+	}
+	stored := &fb.Note{}
+	if e := getDoc(ctx, bdb, note1.ID, stored); e != nil {
+		t.Fatal(e)
+	}
+	if !stored.Modified.Equal(now().UTC()) {
+		t.Errorf("Modified time not updated: %s", stored.Modified)
+	}
+	// Cards are unaffected, as both fields still have content
+	cards, err := noteCards(ctx, udb, testBundleID, note1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 {
+		t.Errorf("Unexpected number of cards: %d", len(cards))
+	}
+}
+
+func TestFindReplaceNotOwner(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, _ := testDBs(t, client)
+	note, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "foo"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := &fb.Bundle{}
+	if e := getDoc(ctx, udb, testBundleID, bundle); e != nil {
+		t.Fatal(e)
+	}
+	bundle.Owner = "nrqwe"
+	if _, e := udb.Put(ctx, bundle.ID, bundle); e != nil {
+		t.Fatal(e)
+	}
+
+	t.Run("bundle", func(t *testing.T) {
+		_, err := repo.FindReplace(ctx, FindReplaceOptions{BundleID: testBundleID, Find: "foo"})
+		checkErr(t, "bundle not owned by current user", err)
+	})
+	t.Run("notes", func(t *testing.T) {
+		changes, err := repo.FindReplace(ctx, FindReplaceOptions{NoteIDs: []string{note.ID}, Find: "foo", Replace: "bar"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("Notes in other users' bundles should be skipped: %v", changes)
+		}
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/flimzy/kivik"
 	"github.com/flimzy/log"
@@ -52,10 +54,14 @@ func (r *Repo) Import(ctx context.Context, f io.Reader, reporter progress.Report
 	if err != nil {
 		return err
 	}
-	pkg := &fb.Package{}
-	if e := json.NewDecoder(f).Decode(pkg); e != nil {
+	doc := &importPackage{}
+	if e := json.NewDecoder(f).Decode(doc); e != nil {
 		return errors.Wrap(e, "Unable to decode JSON")
 	}
+	pkg, tags, err := doc.split()
+	if err != nil {
+		return errors.Wrap(err, "Unable to decode JSON")
+	}
 	steps.Increment(1)
 	if e := pkg.Validate(); e != nil {
 		return e
@@ -101,6 +107,10 @@ func (r *Repo) Import(ctx context.Context, f io.Reader, reporter progress.Report
 		}
 	}
 
+	if e := importTags(ctx, udb, bdb, tags); e != nil {
+		return e
+	}
+
 	log.Printf("Imported:\n%d Bundles\n%d Themes\n%d Decks\n%d Notes\n%d Cards\n",
 		1, len(pkg.Themes), len(pkg.Decks), len(pkg.Notes), len(pkg.Cards))
 	return nil
@@ -108,6 +118,148 @@ func (r *Repo) Import(ctx context.Context, f io.Reader, reporter progress.Report
 
 const bulkBatchSize = 50
 
+type taggedDoc struct {
+	ID   string   `json:"_id"`
+	Tags []string `json:"tags"`
+}
+
+// packageTags holds the tags of the notes and cards in a package, as for
+// example, Anki notes' tags.
+type packageTags struct {
+	Cards []taggedDoc
+	Notes []taggedDoc
+}
+
+// importPackage is a package, as read by Import. It differs from fb.Package
+// only in that the tags of its notes and cards, which fb.Note and fb.Card
+// discard, are read along with them.
+type importPackage struct {
+	Version  int           `json:"version"`
+	Created  time.Time     `json:"created"`
+	Modified time.Time     `json:"modified"`
+	Bundle   *fb.Bundle    `json:"bundle,omitempty"`
+	Cards    []*taggedCard `json:"cards,omitempty"`
+	Notes    []*taggedNote `json:"notes,omitempty"`
+	Decks    []*fb.Deck    `json:"decks,omitempty"`
+	Themes   []*fb.Theme   `json:"themes,omitempty"`
+	Reviews  []*fb.Review  `json:"reviews,omitempty"`
+}
+
+// split returns the package, and the tags of its notes and cards.
+func (p *importPackage) split() (*fb.Package, *packageTags, error) {
+	if p.Version < fb.LowestVersion {
+		return nil, nil, errors.Errorf("package version %d < %d", p.Version, fb.LowestVersion)
+	}
+	pkg := &fb.Package{
+		Created:  p.Created,
+		Modified: p.Modified,
+		Bundle:   p.Bundle,
+		Decks:    p.Decks,
+		Themes:   p.Themes,
+		Reviews:  p.Reviews,
+	}
+	tags := &packageTags{}
+	for _, card := range p.Cards {
+		pkg.Cards = append(pkg.Cards, card.Card)
+		tags.Cards = append(tags.Cards, card.tags)
+	}
+	for _, note := range p.Notes {
+		pkg.Notes = append(pkg.Notes, note.Note)
+		tags.Notes = append(tags.Notes, note.tags)
+	}
+	return pkg, tags, nil
+}
+
+// taggedCard is a card in a package, along with its tags.
+type taggedCard struct {
+	*fb.Card
+	tags taggedDoc
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (c *taggedCard) UnmarshalJSON(data []byte) error {
+	c.Card = &fb.Card{}
+	return unmarshalTagged(data, c.Card, &c.tags)
+}
+
+// taggedNote is a note in a package, along with its tags.
+type taggedNote struct {
+	*fb.Note
+	tags taggedDoc
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (n *taggedNote) UnmarshalJSON(data []byte) error {
+	n.Note = &fb.Note{}
+	return unmarshalTagged(data, n.Note, &n.tags)
+}
+
+// unmarshalTagged unmarshals a single document into doc, and its ID and tags
+// into tags.
+func unmarshalTagged(data []byte, doc interface{}, tags *taggedDoc) error {
+	if err := json.Unmarshal(data, doc); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, tags)
+}
+
+// importTags adds the imported tags to any existing tags of each note and
+// card, with a single bulk update of each database.
+func importTags(ctx context.Context, udb, bdb kivikDB, tags *packageTags) error {
+	for _, set := range []struct {
+		db   kivikDB
+		docs []taggedDoc
+	}{{udb, tags.Cards}, {bdb, tags.Notes}} {
+		// Existing tags are usually few, if any, so only those to be merged
+		// with are fetched.
+		existing, err := tagsDocIDs(ctx, set.db)
+		if err != nil {
+			return err
+		}
+		tagsDocs := make(map[string]*tagsDoc)
+		changed := make(map[string]bool)
+		updates := make([]*tagsDoc, 0)
+		for _, doc := range set.docs {
+			// Anki tags may not contain spaces, but a space-separated list
+			// of tags is its storage format.
+			imported := strings.Fields(strings.Join(doc.Tags, " "))
+			if len(imported) == 0 {
+				continue
+			}
+			id := tagsPrefix + doc.ID
+			tdoc, ok := tagsDocs[id]
+			if !ok {
+				tdoc = &tagsDoc{ID: id, Type: "tags"}
+				if existing[id] {
+					if e := getDoc(ctx, set.db, id, tdoc); e != nil {
+						return errors.Wrapf(e, "failed to import tags for %s", doc.ID)
+					}
+				}
+				tagsDocs[id] = tdoc
+			}
+			tagSet := make(map[string]bool, len(tdoc.Tags)+len(imported))
+			addTags(tagSet, tdoc.Tags)
+			addTags(tagSet, imported)
+			merged := tagList(tagSet)
+			if strings.Join(merged, " ") == strings.Join(tdoc.Tags, " ") {
+				continue
+			}
+			tdoc.Tags = merged
+			if !changed[id] {
+				changed[id] = true
+				updates = append(updates, tdoc)
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if e := updateDocs(ctx, set.db, updates); e != nil {
+			return errors.Wrap(e, "failed to import tags")
+		}
+	}
+	return nil
+}
+
 func importBundleDocs(ctx context.Context, db kivikDB, pkg *fb.Package, prog *progress.Component) error {
 	docCount := len(pkg.Themes) + len(pkg.Notes) + len(pkg.Decks)
 	prog.Total(uint64(docCount*2 + 1))
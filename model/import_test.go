@@ -45,7 +45,8 @@ var sampleImportData = `{
 				{
 					"text": "cat"
 				}
-			]
+			],
+			"tags": ["animals", "leech"]
 		}
 	],
 	"decks": [
@@ -175,7 +176,7 @@ func TestImportFile(t *testing.T) {
 				0x1f, 0x8b, 0x08, 0x08, 0xe2, 0x20, 0x71, 0x59,
 				0x00, 0x03, 0x78, 0x00, 0x33, 0xe4, 0x02, 0x00,
 				0x53, 0xfc, 0x51, 0x67, 0x02, 0x00, 0x00, 0x00}},
-			err: "Unable to decode JSON: json: cannot unmarshal number into Go value of type fb.jsonPackage",
+			err: "Unable to decode JSON: json: cannot unmarshal number into Go value of type model.importPackage",
 		},
 	}
 	for _, test := range tests {
@@ -329,6 +330,12 @@ func TestImport(t *testing.T) {
 					"imported":    "2016-08-02T15:08:24.730156517Z",
 					"modified":    "2016-07-31T15:08:24.730156517Z",
 				},
+				map[string]interface{}{
+					"_id":  "tags-note-VGVzdCBOb3Rl",
+					"_rev": "1",
+					"type": "tags",
+					"tags": []string{"animals", "leech"},
+				},
 			},
 		},
 	}
@@ -415,6 +422,41 @@ func (f *failBulkDocs) Get(_ context.Context, _ string, _ ...kivik.Options) (kiv
 	return nil, nil
 }
 
+func TestImportTags(t *testing.T) {
+	_, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	const cardID, noteID = "card-mjxwe.Zm9v.0", "note-Zm9v"
+	existing := &tagsDoc{ID: tagsPrefix + cardID, Type: "tags", Tags: []string{"verb"}}
+	if _, err := udb.Put(ctx, existing.ID, existing); err != nil {
+		t.Fatal(err)
+	}
+	tags := &packageTags{
+		Cards: []taggedDoc{{ID: cardID, Tags: []string{"leech verb"}}, {ID: "card-mjxwe.YmFy.0"}},
+		Notes: []taggedDoc{{ID: noteID, Tags: []string{"animals"}}, {ID: noteID, Tags: []string{"cats"}}},
+	}
+	if err := importTags(ctx, udb, bdb, tags); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		db       kivikDB
+		id       string
+		expected []string
+	}{
+		{udb, cardID, []string{"leech", "verb"}},
+		{udb, "card-mjxwe.YmFy.0", []string{}},
+		{bdb, noteID, []string{"animals", "cats"}},
+	} {
+		result, err := getTags(ctx, test.db, test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface(test.expected, result); d != nil {
+			t.Errorf("%s: %s", test.id, d)
+		}
+	}
+}
+
 func TestBulkInsert(t *testing.T) {
 	type biTest struct {
 		name     string
@@ -153,6 +153,18 @@ func (db *mockQueryGetter) Get(_ context.Context, docID string, _ ...kivik.Optio
 	return db.row, db.err
 }
 
+type mockFinder struct {
+	kivikDB
+	rows kivikRows
+	err  error
+}
+
+var _ finder = &mockFinder{}
+
+func (db *mockFinder) Find(_ context.Context, _ interface{}) (kivikRows, error) {
+	return db.rows, db.err
+}
+
 type mockRow string
 
 var _ kivikRow = mockRow("")
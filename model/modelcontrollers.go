@@ -35,6 +35,33 @@ type FuncMapper interface {
 	FuncMap(card *Card, face int) template.FuncMap
 }
 
+// Lessoner is an optional interface that a ModelController may fulfill, to
+// mark its cards as lessons. Lesson cards are shown once, and are not
+// scheduled for review. A new lesson card is shown before any new card which
+// follows it in its deck.
+type Lessoner interface {
+	// Lesson returns true if the model's cards are lessons.
+	Lesson() bool
+}
+
+// Clozer is an optional interface that a ModelController may fulfill, for
+// cloze models. A note of a cloze model has a card for each cloze number used
+// in its fields, whose template ID is the cloze number minus one, rather than
+// a card for each of the model's templates. All of the cards are rendered by
+// the model's first template.
+type Clozer interface {
+	// ClozeNumbers returns the distinct cloze numbers used in the fields'
+	// text, in increasing order.
+	ClozeNumbers(fields []string) []int
+}
+
+// isLessonType returns true if mType is the type of a registered model
+// controller whose cards are lessons.
+func isLessonType(mType string) bool {
+	l, ok := modelControllers[mType].(Lessoner)
+	return ok && l.Lesson()
+}
+
 var modelControllers = map[string]ModelController{}
 var modelControllerTypes = []string{}
 
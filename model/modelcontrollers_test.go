@@ -72,3 +72,24 @@ func TestGetModelController(t *testing.T) {
 		}
 	})
 }
+
+type lessonMC struct {
+	mockMC
+}
+
+func (mc *lessonMC) Lesson() bool { return true }
+
+func TestIsLessonType(t *testing.T) {
+	// Not registered, so as not to affect the list of registered controllers
+	modelControllers["lesson"] = &lessonMC{mockMC{t: "lesson"}}
+	defer delete(modelControllers, "lesson")
+	if !isLessonType("lesson") {
+		t.Error("Expected a lesson type")
+	}
+	if isLessonType(testMType) {
+		t.Error("Unexpected lesson type")
+	}
+	if isLessonType("not found") {
+		t.Error("Unregistered type should not be a lesson")
+	}
+}
@@ -0,0 +1,301 @@
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// modelEdit describes a change to a model.
+type modelEdit struct {
+	// model applies the change to the model, which is stored in db.
+	model func(model *fb.Model, db kivikDB) error
+	// note applies the corresponding change to each of the model's notes, if
+	// necessary, before they are associated with the modified model.
+	note func(*fb.Note) error
+	// regenerate is true if the change may affect which cards are generated.
+	regenerate bool
+}
+
+// AddField appends a new, empty, field to the model (in the format
+// theme-<theme>/<model>), in a bundle owned by the current user. Existing
+// notes are given an empty value for the new field.
+func (r *Repo) AddField(ctx context.Context, bundleID, modelID string, fieldType fb.FieldType, name string) error {
+	defer profile("AddField")()
+	return r.editModel(ctx, bundleID, modelID, modelEdit{
+		model: func(model *fb.Model, _ kivikDB) error {
+			if fieldIndex(model, name) >= 0 {
+				return kerrors.Status(kivik.StatusConflict, fmt.Sprintf("field '%s' already exists", name))
+			}
+			if err := model.AddField(fieldType, name); err != nil {
+				return kerrors.WrapStatus(kivik.StatusBadRequest, err)
+			}
+			return nil
+		},
+		note: func(note *fb.Note) error {
+			note.FieldValues = append(note.FieldValues, &fb.FieldValue{})
+			return nil
+		},
+	})
+}
+
+// RemoveField removes the named field from the model, along with each note's
+// value for the field, and any files attached to it. The model's templates
+// must no longer refer to the field. Cards are regenerated as by UpdateNote,
+// except that notes which would produce no cards keep their existing cards.
+func (r *Repo) RemoveField(ctx context.Context, bundleID, modelID, name string) error {
+	defer profile("RemoveField")()
+	var index int
+	return r.editModel(ctx, bundleID, modelID, modelEdit{
+		model: func(model *fb.Model, db kivikDB) error {
+			if index = fieldIndex(model, name); index < 0 {
+				return kerrors.Status(kivik.StatusNotFound, fmt.Sprintf("unknown field '%s'", name))
+			}
+			if len(model.Fields) == 1 {
+				return kerrors.Status(kivik.StatusBadRequest, "cannot remove the only field")
+			}
+			model.Fields = append(model.Fields[:index], model.Fields[index+1:]...)
+			return lintTemplates(ctx, &fbModel{Model: model, db: db})
+		},
+		note: func(note *fb.Note) error {
+			if index >= len(note.FieldValues) {
+				return errors.New("too few field values")
+			}
+			if fv := note.FieldValues[index]; fv != nil {
+				for _, file := range fieldFiles(fv) {
+					note.Attachments.RemoveFile(file)
+				}
+			}
+			note.FieldValues = append(note.FieldValues[:index], note.FieldValues[index+1:]...)
+			return nil
+		},
+		regenerate: true,
+	})
+}
+
+// MoveField moves the named field to the requested position in the model's
+// field list. Each note's field values are reordered to match.
+func (r *Repo) MoveField(ctx context.Context, bundleID, modelID, name string, position int) error {
+	defer profile("MoveField")()
+	var index int
+	return r.editModel(ctx, bundleID, modelID, modelEdit{
+		model: func(model *fb.Model, _ kivikDB) error {
+			if index = fieldIndex(model, name); index < 0 {
+				return kerrors.Status(kivik.StatusNotFound, fmt.Sprintf("unknown field '%s'", name))
+			}
+			if position < 0 || position >= len(model.Fields) {
+				return kerrors.Status(kivik.StatusBadRequest, fmt.Sprintf("invalid field position %d", position))
+			}
+			fields := make([]*fb.Field, len(model.Fields))
+			for i, j := range movedOrder(len(fields), index, position) {
+				fields[i] = model.Fields[j]
+			}
+			model.Fields = fields
+			return nil
+		},
+		note: func(note *fb.Note) error {
+			if index >= len(note.FieldValues) || position >= len(note.FieldValues) {
+				return errors.New("too few field values")
+			}
+			values := make([]*fb.FieldValue, len(note.FieldValues))
+			for i, j := range movedOrder(len(values), index, position) {
+				values[i] = note.FieldValues[j]
+			}
+			note.FieldValues = values
+			return nil
+		},
+	})
+}
+
+// movedOrder returns the indexes of a list of length n, in the order which
+// results from moving the element at index from to index to.
+func movedOrder(n, from, to int) []int {
+	order := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if i != from {
+			order = append(order, i)
+		}
+	}
+	order = append(order, 0)
+	copy(order[to+1:], order[to:])
+	order[to] = from
+	return order
+}
+
+// AddTemplate appends a new, named, template to the model. The model's HTML
+// template must already contain the question and answer divs for the new
+// template, whose ID is its index in the model's template list. Cards are
+// generated for existing notes, where the new template produces content.
+func (r *Repo) AddTemplate(ctx context.Context, bundleID, modelID, name string) error {
+	defer profile("AddTemplate")()
+	return r.editModel(ctx, bundleID, modelID, modelEdit{
+		model: func(model *fb.Model, db kivikDB) error {
+			if name == "" {
+				return kerrors.Status(kivik.StatusBadRequest, "template name required")
+			}
+			model.Templates = append(model.Templates, name)
+			return lintTemplates(ctx, &fbModel{Model: model, db: db})
+		},
+		regenerate: true,
+	})
+}
+
+// SetModelTemplate replaces the model's HTML template ($template.<id>.html).
+// The new template must pass validation, as by ValidateModel. Cards are
+// regenerated for all of the model's notes, as for RemoveField.
+func (r *Repo) SetModelTemplate(ctx context.Context, bundleID, modelID, html string) error {
+	defer profile("SetModelTemplate")()
+	return r.editModel(ctx, bundleID, modelID, modelEdit{
+		model: func(model *fb.Model, db kivikDB) error {
+			model.Files.SetFile(fmt.Sprintf("$template.%d.html", model.ID), fb.TemplateContentType, []byte(html))
+			return lintTemplates(ctx, &fbModel{Model: model, db: db})
+		},
+		regenerate: true,
+	})
+}
+
+// SetThemeCSS replaces the stylesheet ($main.css) shared by all of the
+// theme's models.
+func (r *Repo) SetThemeCSS(ctx context.Context, bundleID, themeID, css string) error {
+	defer profile("SetThemeCSS")()
+	_, _, _, err := r.editTheme(ctx, bundleID, themeID, func(theme *fb.Theme, _ kivikDB) error {
+		theme.SetFile(mainCSS, "text/css", []byte(css))
+		return nil
+	})
+	return err
+}
+
+// editTheme applies fn to the requested theme, in a bundle owned by the
+// current user, and saves the result. The theme's new revision invalidates any
+// templates cached for its models.
+func (r *Repo) editTheme(ctx context.Context, bundleID, themeID string, fn func(*fb.Theme, kivikDB) error) (*fb.Bundle, kivikDB, *fb.Theme, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	bundle, err := r.ownedBundle(ctx, udb, bundleID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	bdb, err := r.bundleDB(ctx, bundle)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	theme := &fb.Theme{}
+	if e := getDocAttachments(ctx, bdb, themeID, theme); e != nil {
+		return nil, nil, nil, wrapStatus(e, "theme")
+	}
+	if e := fn(theme, bdb); e != nil {
+		return nil, nil, nil, e
+	}
+	theme.Modified = now().UTC()
+	rev, err := bdb.Put(ctx, theme.ID, theme)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to save theme")
+	}
+	theme.Rev = rev
+	return bundle, bdb, theme, nil
+}
+
+// editModel applies edit to the requested model, and to each of its notes.
+// The notes are saved with a single bulk update, before the theme, so that a
+// failure to save them leaves the model unchanged.
+func (r *Repo) editModel(ctx context.Context, bundleID, modelID string, edit modelEdit) error {
+	themeID, id, err := parseModelID(modelID)
+	if err != nil {
+		return err
+	}
+	var model *fb.Model
+	var notes []*fb.Note
+	bundle, bdb, _, err := r.editTheme(ctx, bundleID, themeID, func(theme *fb.Theme, db kivikDB) error {
+		var e error
+		if model, e = themeModel(theme, id); e != nil {
+			return e
+		}
+		if e = edit.model(model, db); e != nil {
+			return e
+		}
+		if edit.note == nil && !edit.regenerate {
+			return nil
+		}
+		notes, e = editModelNotes(ctx, db, model, edit.note)
+		return e
+	})
+	if err != nil || !edit.regenerate {
+		return err
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	return r.regenerateModelCards(ctx, udb, bdb, bundle, model, notes)
+}
+
+// regenerateModelCards regenerates the cards of the model's notes, except
+// that notes which would produce no cards keep their existing cards. The
+// model's theme must already be saved, as its new revision invalidates any
+// cached templates.
+func (r *Repo) regenerateModelCards(ctx context.Context, udb, bdb kivikDB, bundle *fb.Bundle, model *fb.Model, notes []*fb.Note) error {
+	for _, note := range notes {
+		if _, e := r.regenerateCards(ctx, udb, bdb, bundle, model, note, false); e != nil {
+			if kivik.StatusCode(e) != kivik.StatusBadRequest {
+				return errors.Wrapf(e, "failed to regenerate cards for %s", note.ID)
+			}
+			// The note produces no cards; keep the ones it has.
+		}
+	}
+	return nil
+}
+
+// editModelNotes returns the model's notes in db, associated with the
+// modified model. If fn is not nil, it is applied to each note first, and the
+// notes are saved.
+func editModelNotes(ctx context.Context, db kivikDB, model *fb.Model, fn func(*fb.Note) error) ([]*fb.Note, error) {
+	noteIDs, err := findIDs(ctx, db, map[string]interface{}{
+		"type":  "note",
+		"theme": model.Theme.ID,
+		"model": model.ID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find notes")
+	}
+	notes := make([]*fb.Note, 0, len(noteIDs))
+	for _, noteID := range noteIDs {
+		note := &fb.Note{}
+		if e := getDocAttachments(ctx, db, noteID, note); e != nil {
+			return nil, wrapStatus(e, noteID)
+		}
+		if fn != nil {
+			if e := fn(note); e != nil {
+				return nil, errors.Wrapf(e, "failed to update %s", noteID)
+			}
+			note.Modified = now().UTC()
+		}
+		if e := note.SetModel(model); e != nil {
+			return nil, errors.Wrapf(e, "invalid note %s", noteID)
+		}
+		notes = append(notes, note)
+	}
+	if fn == nil || len(notes) == 0 {
+		return notes, nil
+	}
+	if e := updateDocs(ctx, db, notes); e != nil {
+		return nil, errors.Wrap(e, "failed to save notes")
+	}
+	return notes, nil
+}
+
+// getDocAttachments is like getDoc, but includes the content of the
+// document's attachments, so that it may be stored again without losing them.
+func getDocAttachments(ctx context.Context, db getter, id string, dst interface{}) error {
+	row, err := db.Get(ctx, id, kivik.Options{"attachments": true})
+	if err != nil {
+		return err
+	}
+	return row.ScanDoc(dst)
+}
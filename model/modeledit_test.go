@@ -0,0 +1,248 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestMovedOrder(t *testing.T) {
+	tests := []struct {
+		n, from, to int
+		expected    []int
+	}{
+		{n: 1, from: 0, to: 0, expected: []int{0}},
+		{n: 3, from: 0, to: 2, expected: []int{1, 2, 0}},
+		{n: 3, from: 2, to: 0, expected: []int{2, 0, 1}},
+		{n: 4, from: 1, to: 2, expected: []int{0, 2, 1, 3}},
+	}
+	for _, test := range tests {
+		if d := diff.Interface(test.expected, movedOrder(test.n, test.from, test.to)); d != nil {
+			t.Errorf("%d from %d to %d: %s", test.n, test.from, test.to, d)
+		}
+	}
+}
+
+func TestEditModel(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	note, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "hola", "Back": "hello"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchNote := func(t *testing.T) *fb.Note {
+		t.Helper()
+		stored := &fb.Note{}
+		if e := getDoc(ctx, bdb, note.ID, stored); e != nil {
+			t.Fatal(e)
+		}
+		return stored
+	}
+	fieldNames := func(t *testing.T) []string {
+		t.Helper()
+		model, e := fetchModel(ctx, bdb, testModelID)
+		if e != nil {
+			t.Fatal(e)
+		}
+		names := make([]string, len(model.Fields))
+		for i, f := range model.Fields {
+			names[i] = f.Name
+		}
+		return names
+	}
+	cardIDs := func(t *testing.T) []string {
+		t.Helper()
+		cards, e := noteCards(ctx, udb, testBundleID, note.ID)
+		if e != nil {
+			t.Fatal(e)
+		}
+		ids := make([]string, len(cards))
+		for i, card := range cards {
+			ids[i] = card.ID
+		}
+		return ids
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			fn     func() error
+			err    string
+			status int
+		}{
+			{
+				name:   "duplicate field",
+				fn:     func() error { return repo.AddField(ctx, testBundleID, testModelID, fb.TextField, "Front") },
+				err:    "field 'Front' already exists",
+				status: kivik.StatusConflict,
+			},
+			{
+				name:   "invalid field type",
+				fn:     func() error { return repo.AddField(ctx, testBundleID, testModelID, fb.FieldType(9), "Foo") },
+				err:    "invalid field type",
+				status: kivik.StatusBadRequest,
+			},
+			{
+				name:   "unknown field",
+				fn:     func() error { return repo.RemoveField(ctx, testBundleID, testModelID, "Foo") },
+				err:    "unknown field 'Foo'",
+				status: kivik.StatusNotFound,
+			},
+			{
+				name:   "field in use",
+				fn:     func() error { return repo.RemoveField(ctx, testBundleID, testModelID, "Back") },
+				err:    "$template.0.html:3: unknown field 'Back'; $template.0.html:4: unknown field 'Back'",
+				status: kivik.StatusBadRequest,
+			},
+			{
+				name:   "invalid position",
+				fn:     func() error { return repo.MoveField(ctx, testBundleID, testModelID, "Front", 3) },
+				err:    "invalid field position 3",
+				status: kivik.StatusBadRequest,
+			},
+			{
+				name:   "missing model",
+				fn:     func() error { return repo.AddTemplate(ctx, testBundleID, testThemeID+"/1", "Card 3") },
+				err:    "model 1 not found in " + testThemeID,
+				status: kivik.StatusNotFound,
+			},
+			{
+				name: "invalid template",
+				fn: func() error {
+					return repo.SetModelTemplate(ctx, testBundleID, testModelID, testTemplate+"{{ .Fields.Front ")
+				},
+				err:    "$template.0.html:6: unclosed action",
+				status: kivik.StatusBadRequest,
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				err := test.fn()
+				checkErr(t, test.err, err)
+				if status := kivik.StatusCode(err); status != test.status {
+					t.Errorf("Unexpected status: %d", status)
+				}
+			})
+		}
+	})
+
+	t.Run("AddField", func(t *testing.T) {
+		if e := repo.AddField(ctx, testBundleID, testModelID, fb.TextField, "Notes"); e != nil {
+			t.Fatal(e)
+		}
+		if d := diff.Interface([]string{"Front", "Back", "Picture", "Notes"}, fieldNames(t)); d != nil {
+			t.Error(d)
+		}
+		if n := len(fetchNote(t).FieldValues); n != 4 {
+			t.Errorf("Expected 4 field values, got %d", n)
+		}
+		if _, _, e := repo.UpdateNote(ctx, note.ID, map[string]string{"Notes": "informal"}); e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	t.Run("MoveField", func(t *testing.T) {
+		if e := repo.MoveField(ctx, testBundleID, testModelID, "Notes", 0); e != nil {
+			t.Fatal(e)
+		}
+		if d := diff.Interface([]string{"Notes", "Front", "Back", "Picture"}, fieldNames(t)); d != nil {
+			t.Error(d)
+		}
+		if text := fetchNote(t).FieldValues[0].Text; text != "informal" {
+			t.Errorf("Unexpected first field value: %s", text)
+		}
+	})
+
+	t.Run("RemoveField", func(t *testing.T) {
+		const tmpl = `
+<div class="question" data-id="0">{{ .Fields.Front }}</div>
+<div class="answer" data-id="0">{{ .Fields.Notes }}</div>
+<div class="question" data-id="1"></div>
+<div class="answer" data-id="1">{{ .Fields.Front }}</div>
+`
+		if e := repo.SetModelTemplate(ctx, testBundleID, testModelID, tmpl); e != nil {
+			t.Fatal(e)
+		}
+		if e := repo.RemoveField(ctx, testBundleID, testModelID, "Back"); e != nil {
+			t.Fatal(e)
+		}
+		if d := diff.Interface([]string{"Notes", "Front", "Picture"}, fieldNames(t)); d != nil {
+			t.Error(d)
+		}
+		stored := fetchNote(t)
+		if len(stored.FieldValues) != 3 || stored.FieldValues[1].Text != "hola" {
+			t.Errorf("Unexpected field values: %v", stored.FieldValues)
+		}
+		// The second template no longer displays anything
+		if d := diff.Interface([]string{"card-mjxwe.Ym9iAAAAAAAAAAA.0"}, cardIDs(t)); d != nil {
+			t.Error(d)
+		}
+	})
+
+	t.Run("templates", func(t *testing.T) {
+		const tmpl = `
+<div class="question" data-id="0">{{ .Fields.Front }}</div>
+<div class="answer" data-id="0">{{ .Fields.Notes }}</div>
+<div class="question" data-id="1"></div>
+<div class="answer" data-id="1"></div>
+<div class="question" data-id="2">{{ .Fields.Notes }}</div>
+<div class="answer" data-id="2">{{ .Fields.Front }}</div>
+`
+		if e := repo.SetModelTemplate(ctx, testBundleID, testModelID, tmpl); e != nil {
+			t.Fatal(e)
+		}
+		if e := repo.AddTemplate(ctx, testBundleID, testModelID, "Card 3"); e != nil {
+			t.Fatal(e)
+		}
+		expected := []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0", "card-mjxwe.Ym9iAAAAAAAAAAA.2"}
+		if d := diff.Interface(expected, cardIDs(t)); d != nil {
+			t.Error(d)
+		}
+	})
+
+	t.Run("SetThemeCSS", func(t *testing.T) {
+		if e := repo.SetThemeCSS(ctx, testBundleID, testThemeID, "body { color: red; }"); e != nil {
+			t.Fatal(e)
+		}
+		theme := &fb.Theme{}
+		if e := getDocAttachments(ctx, bdb, testThemeID, theme); e != nil {
+			t.Fatal(e)
+		}
+		att, ok := theme.Files.GetFile(mainCSS)
+		if !ok {
+			t.Fatal("stylesheet missing")
+		}
+		if css := string(att.Content); css != "body { color: red; }" {
+			t.Errorf("Unexpected stylesheet: %s", css)
+		}
+		if _, ok := theme.Models[0].Files.GetFile("$template.0.html"); !ok {
+			t.Error("model template lost")
+		}
+	})
+}
+
+func TestEditModelNotOwner(t *testing.T) {
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, _ := testDBs(t, client)
+	bundle := &fb.Bundle{}
+	if e := getDoc(ctx, udb, testBundleID, bundle); e != nil {
+		t.Fatal(e)
+	}
+	bundle.Owner = "nrqwe"
+	if _, e := udb.Put(ctx, bundle.ID, bundle); e != nil {
+		t.Fatal(e)
+	}
+	err := repo.AddField(ctx, testBundleID, testModelID, fb.TextField, "Notes")
+	checkErr(t, "bundle not owned by current user", err)
+	if status := kivik.StatusCode(err); status != kivik.StatusForbidden {
+		t.Errorf("Unexpected status: %d", status)
+	}
+}
@@ -1,7 +1,17 @@
 package model
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
 
 	fb "github.com/FlashbackSRS/flashback-model"
 )
@@ -23,3 +33,542 @@ func (n *fbNote) MarshalJSON() ([]byte, error) {
 	}
 	return json.Marshal(note)
 }
+
+// CreateNote creates a new note in the requested bundle, using the requested
+// model (in the format theme-<theme>/<model>), and the provided field values,
+// keyed by field name. One card is generated for each of the model's templates
+// which produces non-empty output, and added to the requested deck. The deck
+// may be empty, in which case the cards do not belong to any deck.
+func (r *Repo) CreateNote(ctx context.Context, bundleID, modelID string, fields map[string]string, deckID string) (*fb.Note, []*fb.Card, error) {
+	defer profile("CreateNote")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, err := r.ownedBundle(ctx, udb, bundleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	bdb, err := r.bundleDB(ctx, bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	model, err := fetchModel(ctx, bdb, modelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	note, err := newNote(newNoteID(bundle.ID), model, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	cards, err := r.saveNewNote(ctx, udb, bdb, bundle, model, note, deckID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return note, cards, nil
+}
+
+// saveNewNote generates the cards for a new note, and saves both, adding the
+// cards to the requested deck, if any.
+func (r *Repo) saveNewNote(ctx context.Context, udb, bdb kivikDB, bundle *fb.Bundle, model *fb.Model, note *fb.Note, deckID string) ([]*fb.Card, error) {
+	cards, err := r.generateCards(ctx, bundle, &fbModel{Model: model, db: bdb}, note)
+	if err != nil {
+		return nil, err
+	}
+	if len(cards) == 0 {
+		return nil, kerrors.Status(kivik.StatusBadRequest, "note produces no cards")
+	}
+	if deckID != "" {
+		if _, e := getDeck(ctx, udb, deckID); e != nil {
+			return nil, wrapStatus(e, "deck")
+		}
+		for _, card := range cards {
+			card.Deck = deckID
+		}
+	}
+
+	if e := saveDoc(ctx, bdb, note); e != nil {
+		return nil, errors.Wrap(e, "failed to save note")
+	}
+	docs := make([]FlashbackDoc, len(cards))
+	for i, card := range cards {
+		docs[i] = card
+	}
+	if _, e := bulkInsert(ctx, udb, docs...); e != nil {
+		return nil, errors.Wrap(e, "failed to save cards")
+	}
+	if deckID != "" {
+		cardIDs := make([]string, len(cards))
+		for i, card := range cards {
+			cardIDs[i] = card.ID
+		}
+		if e := addCardsToDeck(ctx, udb, bdb, deckID, cardIDs...); e != nil {
+			return nil, e
+		}
+	}
+	return cards, nil
+}
+
+// UpdateNote changes the field values of an existing note, keyed by field
+// name. Fields not included are left unaltered. Cards are regenerated as
+// necessary: Cards for templates which now produce empty output are deleted,
+// and new cards are created for templates which now produce content. The
+// scheduling of remaining cards is not affected.
+func (r *Repo) UpdateNote(ctx context.Context, noteID string, fields map[string]string) (*fb.Note, []*fb.Card, error) {
+	defer profile("UpdateNote")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, bdb, note, err := r.findNote(ctx, udb, noteID)
+	if err != nil {
+		return nil, nil, err
+	}
+	model, err := fetchModel(ctx, bdb, fmt.Sprintf("%s/%d", note.ThemeID, note.ModelID))
+	if err != nil {
+		return nil, nil, err
+	}
+	if e := note.SetModel(model); e != nil {
+		return nil, nil, errors.Wrap(e, "invalid note")
+	}
+	if e := setFieldValues(model, note.FieldValues, fields); e != nil {
+		return nil, nil, e
+	}
+	cards, err := r.regenerateCards(ctx, udb, bdb, bundle, model, note, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return note, cards, nil
+}
+
+// saveNote saves the note, after bumping its modification time, so that a
+// later re-import of the bundle will not overwrite the change.
+func saveNote(ctx context.Context, bdb putter, note *fb.Note) error {
+	note.Modified = now().UTC()
+	rev, err := bdb.Put(ctx, note.ID, note)
+	if err != nil {
+		return errors.Wrap(err, "failed to save note")
+	}
+	note.Rev = rev
+	return nil
+}
+
+// regenerateCards brings the stored cards for note up to date, and returns the
+// resulting list of cards. If save is true, the note is saved once its
+// cards have been generated, but before any are stored, so that a failure
+// leaves the cards out of date, rather than the note.
+func (r *Repo) regenerateCards(ctx context.Context, udb, bdb kivikDB, bundle *fb.Bundle, model *fb.Model, note *fb.Note, save bool) ([]*fb.Card, error) {
+	existing, err := noteCards(ctx, udb, bundle.ID, note.ID)
+	if err != nil {
+		return nil, err
+	}
+	generated, err := r.generateCards(ctx, bundle, &fbModel{Model: model, db: bdb}, note)
+	if err != nil {
+		return nil, err
+	}
+	if len(generated) == 0 {
+		return nil, kerrors.Status(kivik.StatusBadRequest, "note produces no cards")
+	}
+	if save {
+		if e := saveNote(ctx, bdb, note); e != nil {
+			return nil, e
+		}
+	}
+	var deckID string
+	existingMap := make(map[string]*fb.Card, len(existing))
+	for _, card := range existing {
+		existingMap[card.ID] = card
+		if deckID == "" {
+			deckID = card.Deck
+		}
+	}
+	cards := make([]*fb.Card, 0, len(generated))
+	added := make([]FlashbackDoc, 0)
+	addedIDs := make([]string, 0)
+	for _, card := range generated {
+		if old, ok := existingMap[card.ID]; ok {
+			cards = append(cards, old)
+			delete(existingMap, card.ID)
+			continue
+		}
+		card.Deck = deckID
+		cards = append(cards, card)
+		added = append(added, card)
+		addedIDs = append(addedIDs, card.ID)
+	}
+	if len(added) > 0 {
+		if _, e := bulkInsert(ctx, udb, added...); e != nil {
+			return nil, errors.Wrap(e, "failed to save cards")
+		}
+		if deckID != "" {
+			if e := addCardsToDeck(ctx, udb, bdb, deckID, addedIDs...); e != nil {
+				return nil, e
+			}
+		}
+	}
+	removedByDeck := make(map[string][]string)
+	for _, card := range existingMap {
+		if _, e := udb.Delete(ctx, card.ID, card.Rev); e != nil {
+			return nil, errors.Wrapf(e, "failed to delete %s", card.ID)
+		}
+		if card.Deck != "" {
+			removedByDeck[card.Deck] = append(removedByDeck[card.Deck], card.ID)
+		}
+	}
+	for deck, ids := range removedByDeck {
+		if e := removeCardsFromDeck(ctx, udb, bdb, deck, ids...); e != nil {
+			return nil, e
+		}
+	}
+	return cards, nil
+}
+
+// noteCards returns all stored cards belonging to the note.
+func noteCards(ctx context.Context, db allDocer, bundleID, noteID string) ([]*fb.Card, error) {
+	startKey, endKey := relatedKeyRange(cardID(bundleID, noteID, 0))
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    startKey,
+		"end_key":      endKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	cards := make([]*fb.Card, 0)
+	for rows.Next() {
+		card := &fb.Card{}
+		if e := rows.ScanDoc(card); e != nil {
+			return nil, errors.Wrap(e, "scan doc")
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// findNote finds the requested note, as by noteDB, and returns the note, along
+// with its bundle and bundle database. The bundle must be owned by the current
+// user.
+func (r *Repo) findNote(ctx context.Context, udb kivikDB, noteID string) (*fb.Bundle, kivikDB, *fb.Note, error) {
+	bundleID, bdb, note, err := r.noteDB(ctx, udb, noteID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	bundle, err := r.ownedBundle(ctx, udb, bundleID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return bundle, bdb, note, nil
+}
+
+// noteDB finds the requested note, and returns it, along with its bundle ID
+// and bundle database, regardless of ownership.
+func (r *Repo) noteDB(ctx context.Context, udb kivikDB, noteID string) (string, kivikDB, *fb.Note, error) {
+	bundleIDs, err := noteBundleIDs(ctx, udb, noteID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	for _, bundleID := range bundleIDs {
+		bdb, err := r.newDB(ctx, bundleID)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		note := &fb.Note{}
+		if e := getDoc(ctx, bdb, noteID, note); e != nil {
+			if kivik.StatusCode(e) == kivik.StatusNotFound {
+				continue
+			}
+			return "", nil, nil, e
+		}
+		return bundleID, bdb, note, nil
+	}
+	return "", nil, nil, kerrors.Status(kivik.StatusNotFound, "note not found")
+}
+
+// noteBundleIDs returns the IDs of the bundles which may contain the note:
+// only the bundle encoded in the note's ID, if that is one of the user's
+// bundles, or otherwise, as for notes created elsewhere, all of them.
+func noteBundleIDs(ctx context.Context, udb kivikDB, noteID string) ([]string, error) {
+	if bundleID, ok := noteBundleID(noteID); ok {
+		_, err := udb.Get(ctx, bundleID)
+		if err == nil {
+			return []string{bundleID}, nil
+		}
+		if kivik.StatusCode(err) != kivik.StatusNotFound {
+			return nil, err
+		}
+	}
+	return getBundleIDs(ctx, udb)
+}
+
+// ownedBundle fetches the requested bundle from the user database, and
+// ensures that it belongs to the current user.
+func (r *Repo) ownedBundle(ctx context.Context, udb getter, bundleID string) (*fb.Bundle, error) {
+	bundle := &fb.Bundle{}
+	if err := getDoc(ctx, udb, bundleID, bundle); err != nil {
+		return nil, wrapStatus(err, "bundle")
+	}
+	if bundle.Owner != r.user {
+		return nil, kerrors.Status(kivik.StatusForbidden, "bundle not owned by current user")
+	}
+	return bundle, nil
+}
+
+// parseModelID splits a compound model ID, in the format
+// theme-<theme>/<model>, into its theme ID and model ID.
+func parseModelID(modelID string) (themeID string, id uint32, err error) {
+	parts := strings.Split(modelID, "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "theme-") {
+		return "", 0, kerrors.Status(kivik.StatusBadRequest, "invalid model ID")
+	}
+	n, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return "", 0, kerrors.Status(kivik.StatusBadRequest, "invalid model ID")
+	}
+	return parts[0], uint32(n), nil
+}
+
+// fetchModel fetches the requested model, in the format
+// theme-<theme>/<model>, from the bundle database.
+func fetchModel(ctx context.Context, db getter, modelID string) (*fb.Model, error) {
+	themeID, id, err := parseModelID(modelID)
+	if err != nil {
+		return nil, err
+	}
+	theme := &fb.Theme{}
+	if e := getDoc(ctx, db, themeID, theme); e != nil {
+		return nil, wrapStatus(e, "theme")
+	}
+	return themeModel(theme, id)
+}
+
+// themeModel returns the theme's model with the requested ID.
+func themeModel(theme *fb.Theme, id uint32) (*fb.Model, error) {
+	for _, model := range theme.Models {
+		if model.ID == id {
+			return model, nil
+		}
+	}
+	return nil, kerrors.Status(kivik.StatusNotFound, fmt.Sprintf("model %d not found in %s", id, theme.ID))
+}
+
+// newNote creates a new note for the model, with the provided field values,
+// which are validated against the model's fields.
+func newNote(id string, model *fb.Model, fields map[string]string) (*fb.Note, error) {
+	values := make([]*fb.FieldValue, len(model.Fields))
+	for i := range values {
+		values[i] = &fb.FieldValue{}
+	}
+	if err := setFieldValues(model, values, fields); err != nil {
+		return nil, err
+	}
+	note, err := emptyNote(id, model)
+	if err != nil {
+		return nil, err
+	}
+	for i, fv := range values {
+		note.FieldValues[i].Text = fv.Text
+	}
+	if e := note.Validate(); e != nil {
+		return nil, errors.Wrap(e, "invalid note")
+	}
+	return note, nil
+}
+
+// emptyNote creates a new note for the model, with empty field values.
+func emptyNote(id string, model *fb.Model) (*fb.Note, error) {
+	values := make([]*fb.FieldValue, len(model.Fields))
+	for i := range values {
+		values[i] = &fb.FieldValue{}
+	}
+	// fb.NewNote validates the empty field values, which it cannot do without
+	// panicking, so create the note with a field-less copy of the model, then
+	// attach the real model.
+	bare := *model
+	bare.Fields = nil
+	note, err := fb.NewNote(id, &bare)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid note")
+	}
+	note.FieldValues = values
+	if e := note.SetModel(model); e != nil {
+		return nil, errors.Wrap(e, "invalid note")
+	}
+	if e := note.Validate(); e != nil {
+		return nil, errors.Wrap(e, "invalid note")
+	}
+	return note, nil
+}
+
+// setFieldValues validates fields, keyed by field name, against the model's
+// fields, then sets the corresponding text values. Fields not included are
+// left unaltered. At least one text value must be non-empty afterward.
+func setFieldValues(model *fb.Model, values []*fb.FieldValue, fields map[string]string) error {
+	for name, text := range fields {
+		i := fieldIndex(model, name)
+		if i < 0 {
+			return kerrors.Status(kivik.StatusBadRequest, fmt.Sprintf("unknown field '%s'", name))
+		}
+		if text != "" && (model.Fields[i].Type == fb.ImageField || model.Fields[i].Type == fb.AudioField) {
+			return kerrors.Status(kivik.StatusBadRequest, fmt.Sprintf("field '%s' does not accept text", name))
+		}
+	}
+	var hasContent bool
+	for i, field := range model.Fields {
+		if text, ok := fields[field.Name]; ok {
+			values[i].Text = text
+		}
+		hasContent = hasContent || strings.TrimSpace(values[i].Text) != ""
+	}
+	if !hasContent {
+		return kerrors.Status(kivik.StatusBadRequest, "note has no content")
+	}
+	return nil
+}
+
+// fieldIndex returns the index of the named field, or -1.
+func fieldIndex(model *fb.Model, name string) int {
+	for i, field := range model.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// newNoteID returns a new note ID, which encodes the ID of the bundle it is
+// created in, followed by 64 random bits, so that the note may be found
+// without searching every bundle.
+func newNoteID(bundleID string) string {
+	raw, err := fb.B32dec(strings.TrimPrefix(bundleID, "bundle-"))
+	if err != nil {
+		// The note must be searched for, as one created elsewhere.
+		return newDocID("note-")
+	}
+	id := make([]byte, 8)
+	if _, err := io.ReadFull(idSource, id); err != nil {
+		panic(err)
+	}
+	return "note-" + base64.RawURLEncoding.EncodeToString(append(raw, id...))
+}
+
+// noteBundleID returns the ID of the bundle encoded in a note ID generated by
+// newNoteID, if any.
+func noteBundleID(noteID string) (string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(noteID, "note-"))
+	if err != nil || len(raw) <= 8 {
+		return "", false
+	}
+	return fb.EncodeDBID("bundle", raw[:len(raw)-8]), true
+}
+
+// cardID returns the ID of the card for the requested note and template.
+func cardID(bundleID, noteID string, templateID int) string {
+	return fmt.Sprintf("card-%s.%s.%d", strings.TrimPrefix(bundleID, "bundle-"), strings.TrimPrefix(noteID, "note-"), templateID)
+}
+
+// generateCards returns a new card for each of the model's templates, or for
+// each cloze number used by the note of a cloze model, which produces
+// non-empty output for the note.
+func (r *Repo) generateCards(ctx context.Context, bundle *fb.Bundle, model *fbModel, note *fb.Note) ([]*fb.Card, error) {
+	templateIDs := model.cardTemplateIDs(note)
+	cards := make([]*fb.Card, 0, len(templateIDs))
+	for _, i := range templateIDs {
+		card, err := fb.NewCard(model.Theme.ID, model.ID, cardID(bundle.ID, note.ID, int(i)))
+		if err != nil {
+			return nil, err
+		}
+		c := &Card{
+			Card:   card,
+			note:   &fbNote{Note: note},
+			model:  model,
+			appURL: r.appURL,
+			repo:   r,
+		}
+		htmlDoc, err := c.execTemplate(ctx, Question)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := hasContent(faces[Question], model.templateDiv(i), htmlDoc)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
+
+func getDeck(ctx context.Context, db getter, deckID string) (*fb.Deck, error) {
+	deck := &fb.Deck{}
+	if err := getDoc(ctx, db, deckID, deck); err != nil {
+		return nil, err
+	}
+	return deck, nil
+}
+
+// addCardsToDeck adds the cards to the deck in the user database, and in the
+// bundle database, if the deck is stored there.
+func addCardsToDeck(ctx context.Context, udb, bdb getPutter, deckID string, cardIDs ...string) error {
+	for _, db := range []getPutter{udb, bdb} {
+		deck, err := getDeck(ctx, db, deckID)
+		if err != nil {
+			if db == bdb && kivik.StatusCode(err) == kivik.StatusNotFound {
+				continue
+			}
+			return errors.Wrap(err, "deck")
+		}
+		for _, id := range cardIDs {
+			deck.AddCard(id)
+		}
+		deck.Modified = now().UTC()
+		if _, e := db.Put(ctx, deck.ID, deck); e != nil {
+			return errors.Wrap(e, "failed to update deck")
+		}
+	}
+	return nil
+}
+
+// removeCardsFromDeck removes the cards from the deck in the user database,
+// and in the bundle database, if the deck is stored there.
+func removeCardsFromDeck(ctx context.Context, udb, bdb getPutter, deckID string, cardIDs ...string) error {
+	remove := make(map[string]bool, len(cardIDs))
+	for _, id := range cardIDs {
+		remove[id] = true
+	}
+	for _, db := range []getPutter{udb, bdb} {
+		deck, err := getDeck(ctx, db, deckID)
+		if err != nil {
+			if kivik.StatusCode(err) == kivik.StatusNotFound {
+				continue
+			}
+			return errors.Wrap(err, "deck")
+		}
+		if !removeCards(deck, remove) {
+			continue
+		}
+		if _, e := db.Put(ctx, deck.ID, deck); e != nil {
+			return errors.Wrap(e, "failed to update deck")
+		}
+	}
+	return nil
+}
+
+// removeCards removes the cards from the deck, and returns true if any were
+// removed. The deck's modification time is updated accordingly.
+func removeCards(deck *fb.Deck, remove map[string]bool) bool {
+	ids := deck.Cards.All()
+	// fb.CardCollection offers no way to remove a card, so build a new one
+	deck.Cards = fb.NewCardCollection()
+	for _, id := range ids {
+		if !remove[id] {
+			deck.AddCard(id)
+		}
+	}
+	if len(deck.Cards.All()) == len(ids) {
+		return false
+	}
+	deck.Modified = now().UTC()
+	return true
+}
@@ -0,0 +1,440 @@
+package model
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+const (
+	testUser     = "mjxwe" // "bob"
+	testBundleID = "bundle-mjxwe"
+	testThemeID  = "theme-VGVzdCBBdXRob3Jpbmc"
+	testModelID  = testThemeID + "/0"
+	testDeckID   = "deck-ZGVjaw"
+)
+
+const testTemplate = `
+<div class="question" data-id="0">{{ .Fields.Front }}</div>
+<div class="answer" data-id="0">{{ .Fields.Back }}</div>
+<div class="question" data-id="1">{{ .Fields.Back }}</div>
+<div class="answer" data-id="1">{{ .Fields.Front }}</div>
+`
+
+// newAuthoringRepo returns a Repo backed by a fakeClient, with a single
+// bundle owned by the current user, containing a theme with a single,
+// two-template, model, and a deck, which is also stored in the user DB.
+func newAuthoringRepo(t *testing.T) (*Repo, *fakeClient) {
+	ctx := context.Background()
+	client := newFakeClient("user-"+testUser, testBundleID, stateDB)
+	state, _ := client.DB(ctx, stateDB)
+	repo := &Repo{user: testUser, local: client, state: state}
+	udb, _ := client.DB(ctx, "user-"+testUser)
+	bdb, _ := client.DB(ctx, testBundleID)
+
+	bundle, err := fb.NewBundle(testBundleID, testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	theme, err := fb.NewTheme(testThemeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	theme.SetFile(mainCSS, "text/css", []byte("body {}"))
+	model, err := theme.NewModel("basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.Templates = []string{"Card 1", "Card 2"}
+	_ = model.AddField(fb.TextField, "Front")
+	_ = model.AddField(fb.TextField, "Back")
+	_ = model.AddField(fb.ImageField, "Picture")
+	if e := model.AddFile("$template.0.html", fb.TemplateContentType, []byte(testTemplate)); e != nil {
+		t.Fatal(e)
+	}
+	deck, err := fb.NewDeck(testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deck.Name = "Test Deck"
+
+	for _, db := range []kivikDB{udb, bdb} {
+		if _, e := db.Put(ctx, bundle.ID, bundle); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := db.Put(ctx, deck.ID, deck); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if _, e := bdb.Put(ctx, theme.ID, theme); e != nil {
+		t.Fatal(e)
+	}
+	return repo, client
+}
+
+func testDBs(t *testing.T, client *fakeClient) (udb, bdb kivikDB) {
+	udb, err := client.DB(context.Background(), "user-"+testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdb, err = client.DB(context.Background(), testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return udb, bdb
+}
+
+func TestCreateNote(t *testing.T) {
+	tests := []struct {
+		name    string
+		bundle  string
+		model   string
+		fields  map[string]string
+		deck    string
+		cardIDs []string
+		err     string
+		status  int
+	}{
+		{
+			name:   "missing bundle",
+			bundle: "bundle-foo",
+			model:  testModelID,
+			fields: map[string]string{"Front": "foo"},
+			err:    "bundle: missing",
+			status: kivik.StatusNotFound,
+		},
+		{
+			name:   "invalid model ID",
+			bundle: testBundleID,
+			model:  "theme-foo",
+			fields: map[string]string{"Front": "foo"},
+			err:    "invalid model ID",
+			status: kivik.StatusBadRequest,
+		},
+		{
+			name:   "missing model",
+			bundle: testBundleID,
+			model:  testThemeID + "/3",
+			fields: map[string]string{"Front": "foo"},
+			err:    "model 3 not found in " + testThemeID,
+			status: kivik.StatusNotFound,
+		},
+		{
+			name:   "unknown field",
+			bundle: testBundleID,
+			model:  testModelID,
+			fields: map[string]string{"Front": "foo", "Side": "bar"},
+			err:    "unknown field 'Side'",
+			status: kivik.StatusBadRequest,
+		},
+		{
+			name:   "text in image field",
+			bundle: testBundleID,
+			model:  testModelID,
+			fields: map[string]string{"Picture": "foo"},
+			err:    "field 'Picture' does not accept text",
+			status: kivik.StatusBadRequest,
+		},
+		{
+			name:   "no content",
+			bundle: testBundleID,
+			model:  testModelID,
+			fields: map[string]string{"Front": " "},
+			err:    "note has no content",
+			status: kivik.StatusBadRequest,
+		},
+		{
+			name:   "no cards",
+			bundle: testBundleID,
+			model:  testModelID,
+			fields: map[string]string{"Front": "<i> </i>"},
+			err:    "note produces no cards",
+			status: kivik.StatusBadRequest,
+		},
+		{
+			name:   "missing deck",
+			bundle: testBundleID,
+			model:  testModelID,
+			fields: map[string]string{"Front": "foo"},
+			deck:   "deck-Zm9v",
+			err:    "deck: missing",
+			status: kivik.StatusNotFound,
+		},
+		{
+			name:    "one card",
+			bundle:  testBundleID,
+			model:   testModelID,
+			fields:  map[string]string{"Front": "foo"},
+			deck:    testDeckID,
+			cardIDs: []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0"},
+		},
+		{
+			name:    "two cards, no deck",
+			bundle:  testBundleID,
+			model:   testModelID,
+			fields:  map[string]string{"Front": "foo", "Back": "bar"},
+			cardIDs: []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0", "card-mjxwe.Ym9iAAAAAAAAAAA.1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setZeroIDSource()()
+			repo, client := newAuthoringRepo(t)
+			ctx := context.Background()
+			note, cards, err := repo.CreateNote(ctx, test.bundle, test.model, test.fields, test.deck)
+			checkErr(t, test.err, err)
+			if err != nil {
+				if status := kivik.StatusCode(err); status != test.status {
+					t.Errorf("Unexpected status: %d", status)
+				}
+				return
+			}
+			if note.ID != "note-Ym9iAAAAAAAAAAA" {
+				t.Errorf("Unexpected note ID: %s", note.ID)
+			}
+			cardIDs := make([]string, len(cards))
+			for i, card := range cards {
+				cardIDs[i] = card.ID
+				if card.Deck != test.deck {
+					t.Errorf("Unexpected deck for %s: %s", card.ID, card.Deck)
+				}
+			}
+			if d := diff.Interface(test.cardIDs, cardIDs); d != nil {
+				t.Error(d)
+			}
+
+			udb, bdb := testDBs(t, client)
+			stored := &fb.Note{}
+			if e := getDoc(ctx, bdb, note.ID, stored); e != nil {
+				t.Fatal(e)
+			}
+			if stored.FieldValues[0].Text != test.fields["Front"] {
+				t.Errorf("Unexpected stored Front: %s", stored.FieldValues[0].Text)
+			}
+			for _, id := range test.cardIDs {
+				if _, e := udb.Get(ctx, id); e != nil {
+					t.Errorf("%s: %s", id, e)
+				}
+			}
+			for _, db := range []kivikDB{udb, bdb} {
+				deck, e := getDeck(ctx, db, testDeckID)
+				if e != nil {
+					t.Fatal(e)
+				}
+				expected := []string{}
+				if test.deck != "" {
+					expected = test.cardIDs
+				}
+				if d := diff.Interface(expected, deck.Cards.All()); d != nil {
+					t.Errorf("%s: %s", db.Name(), d)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateNoteNotOwner(t *testing.T) {
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, _ := testDBs(t, client)
+	bundle := &fb.Bundle{}
+	if err := getDoc(ctx, udb, testBundleID, bundle); err != nil {
+		t.Fatal(err)
+	}
+	bundle.Owner = "nrqwe"
+	if _, err := udb.Put(ctx, bundle.ID, bundle); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "foo"}, "")
+	checkErr(t, "bundle not owned by current user", err)
+	if status := kivik.StatusCode(err); status != kivik.StatusForbidden {
+		t.Errorf("Unexpected status: %d", status)
+	}
+}
+
+type clozeMC struct {
+	mockMC
+}
+
+var clozeNumberRE = regexp.MustCompile(`{{c(\d+)::`)
+
+func (mc *clozeMC) ClozeNumbers(fields []string) []int {
+	seen := make(map[int]bool)
+	for _, field := range fields {
+		for _, m := range clozeNumberRE.FindAllStringSubmatch(field, -1) {
+			n, _ := strconv.Atoi(m[1])
+			seen[n] = true
+		}
+	}
+	nums := make([]int, 0, len(seen))
+	for n := range seen {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+func TestGenerateClozeCards(t *testing.T) {
+	// Not registered, so as not to affect the list of registered controllers
+	modelControllers["cloze"] = &clozeMC{mockMC{t: "cloze"}}
+	defer delete(modelControllers, "cloze")
+	theme, err := fb.NewTheme("theme-Y2xvemU")
+	if err != nil {
+		t.Fatal(err)
+	}
+	theme.SetFile(mainCSS, "text/css", []byte("body {}"))
+	model, err := theme.NewModel("cloze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.Templates = []string{"Cloze"}
+	_ = model.AddField(fb.TextField, "Text")
+	tmpl := `<div class="question" data-id="0">{{ .Fields.Text }}</div><div class="answer" data-id="0"></div>`
+	if e := model.AddFile("$template.0.html", fb.TemplateContentType, []byte(tmpl)); e != nil {
+		t.Fatal(e)
+	}
+	note, err := newNote("note-Y2xvemU", model, map[string]string{"Text": "{{c2::Ottawa}} is in {{c3::Canada}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &Repo{}
+	cards, err := repo.generateCards(context.Background(), &fb.Bundle{ID: testBundleID}, &fbModel{Model: model}, note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(cards))
+	for i, card := range cards {
+		ids[i] = card.ID
+	}
+	if d := diff.Interface([]string{"card-mjxwe.Y2xvemU.1", "card-mjxwe.Y2xvemU.2"}, ids); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestNoteBundleID(t *testing.T) {
+	defer setZeroIDSource()()
+	noteID := newNoteID(testBundleID)
+	if noteID != "note-Ym9iAAAAAAAAAAA" {
+		t.Errorf("Unexpected note ID: %s", noteID)
+	}
+	if bundleID, ok := noteBundleID(noteID); !ok || bundleID != testBundleID {
+		t.Errorf("Unexpected bundle ID: %s, %t", bundleID, ok)
+	}
+	// Notes created elsewhere must be searched for
+	for _, id := range []string{"note-AAAAAAAAAAA", "note-Zm9v", "note-!"} {
+		if bundleID, ok := noteBundleID(id); ok {
+			t.Errorf("%s: unexpected bundle ID: %s", id, bundleID)
+		}
+	}
+}
+
+func TestUpdateNote(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	note, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "foo"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card0 := &fb.Card{}
+	if e := getDoc(ctx, udb, "card-mjxwe.Ym9iAAAAAAAAAAA.0", card0); e != nil {
+		t.Fatal(e)
+	}
+	card0.Due = parseDue(t, "2017-01-10")
+	card0.Interval = 5 * fb.Day
+	if _, e := udb.Put(ctx, card0.ID, card0); e != nil {
+		t.Fatal(e)
+	}
+
+	origNow := now
+	defer func() { now = origNow }()
+	now = func() time.Time { return origNow().Add(time.Hour) }
+
+	t.Run("not found", func(t *testing.T) {
+		_, _, err := repo.UpdateNote(ctx, "note-Zm9v", map[string]string{"Front": "bar"})
+		checkErr(t, "note not found", err)
+	})
+	t.Run("invalid field", func(t *testing.T) {
+		_, _, err := repo.UpdateNote(ctx, note.ID, map[string]string{"Side": "bar"})
+		checkErr(t, "unknown field 'Side'", err)
+	})
+	t.Run("no cards", func(t *testing.T) {
+		_, _, err := repo.UpdateNote(ctx, note.ID, map[string]string{"Front": "<b></b>"})
+		checkErr(t, "note produces no cards", err)
+	})
+
+	checkCards := func(t *testing.T, cards []*fb.Card, expected []string) {
+		t.Helper()
+		ids := make([]string, len(cards))
+		for i, card := range cards {
+			ids[i] = card.ID
+		}
+		if d := diff.Interface(expected, ids); d != nil {
+			t.Errorf("cards: %s", d)
+		}
+		stored, err := noteCards(ctx, udb, testBundleID, note.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = make([]string, len(stored))
+		for i, card := range stored {
+			ids[i] = card.ID
+			if card.Deck != testDeckID {
+				t.Errorf("Unexpected deck for %s: %s", card.ID, card.Deck)
+			}
+		}
+		if d := diff.Interface(expected, ids); d != nil {
+			t.Errorf("stored cards: %s", d)
+		}
+		for _, db := range []kivikDB{udb, bdb} {
+			deck, err := getDeck(ctx, db, testDeckID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := diff.Interface(expected, deck.Cards.All()); d != nil {
+				t.Errorf("%s deck: %s", db.Name(), d)
+			}
+		}
+	}
+
+	t.Run("add card", func(t *testing.T) {
+		updated, cards, err := repo.UpdateNote(ctx, note.ID, map[string]string{"Back": "bar"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !updated.Modified.Equal(now().UTC()) {
+			t.Errorf("Modified time not updated: %s", updated.Modified)
+		}
+		checkCards(t, cards, []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0", "card-mjxwe.Ym9iAAAAAAAAAAA.1"})
+		stored := &fb.Note{}
+		if e := getDoc(ctx, bdb, note.ID, stored); e != nil {
+			t.Fatal(e)
+		}
+		if stored.FieldValues[0].Text != "foo" || stored.FieldValues[1].Text != "bar" {
+			t.Errorf("Unexpected stored values: %q, %q", stored.FieldValues[0].Text, stored.FieldValues[1].Text)
+		}
+		card := &fb.Card{}
+		if e := getDoc(ctx, udb, "card-mjxwe.Ym9iAAAAAAAAAAA.0", card); e != nil {
+			t.Fatal(e)
+		}
+		if card.Interval != 5*fb.Day || card.Due.String() != "2017-01-10" {
+			t.Errorf("Scheduling not preserved: %s / %s", card.Interval, card.Due)
+		}
+	})
+	t.Run("remove card", func(t *testing.T) {
+		_, cards, err := repo.UpdateNote(ctx, note.ID, map[string]string{"Back": ""})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkCards(t, cards, []string{"card-mjxwe.Ym9iAAAAAAAAAAA.0"})
+	})
+}
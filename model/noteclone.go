@@ -0,0 +1,518 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+const noteLinkPrefix = "notelink-"
+
+// noteLink records the parent of a cloned note, as described in schema.md.
+// Like themeLink, it is stored in a separate document, alongside the clone in
+// its bundle database, with the ID notelink-<clone ID>.
+type noteLink struct {
+	ID           string `json:"_id"`
+	Rev          string `json:"_rev,omitempty"`
+	Type         string `json:"type"`
+	Parent       string `json:"parent"`
+	ParentBundle string `json:"parentBundle"`
+	// ParentRev is the revision of the parent last merged into the clone.
+	ParentRev string `json:"parentRev"`
+	// Base maps the parent's field names to digests of their values, as last
+	// merged into the clone. Fields whose merge conflicted keep their earlier
+	// digest, until the conflict is resolved.
+	Base      map[string]string `json:"base"`
+	Conflicts []*NoteConflict   `json:"conflicts,omitempty"`
+}
+
+// NoteConflict describes a change to a field of a parent note, which could not
+// be merged into its clone, because the clone's value has also been changed.
+type NoteConflict struct {
+	Field string `json:"field"`
+}
+
+// CloneNote copies a note, from one of the user's bundles, to a bundle owned
+// by the current user, and records the original as the copy's parent. The copy
+// uses the clone of the parent's theme in the target bundle, which is created
+// by CloneTheme if it doesn't exist yet. Its cards are added to the requested
+// deck, which may be empty. They take over the schedules of the parent's cards
+// from the same templates, which are suspended, so that the material is not
+// studied twice. Later changes to the parent are merged into the copy by
+// MergeNote, so that errors in shared notes may be fixed locally without
+// losing upstream fixes.
+func (r *Repo) CloneNote(ctx context.Context, noteID, bundleID, deckID string) (*fb.Note, []*fb.Card, error) {
+	defer profile("CloneNote")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, err := r.ownedBundle(ctx, udb, bundleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	bdb, err := r.bundleDB(ctx, bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	parentBundleID, pdb, _, err := r.noteDB(ctx, udb, noteID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parentBundleID == bundleID {
+		return nil, nil, kerrors.Status(kivik.StatusBadRequest, "cannot clone a note into its own bundle")
+	}
+	parent, err := fetchNoteModel(ctx, pdb, noteID)
+	if err != nil {
+		return nil, nil, err
+	}
+	themeID, err := findThemeClone(ctx, bdb, parentBundleID, parent.ThemeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if themeID == "" {
+		theme, e := r.cloneTheme(ctx, bdb, parentBundleID, parent.ThemeID)
+		if e != nil {
+			return nil, nil, e
+		}
+		themeID = theme.ID
+	}
+	model, err := fetchModel(ctx, bdb, fmt.Sprintf("%s/%d", themeID, parent.ModelID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	note, err := emptyNote(newNoteID(bundle.ID), model)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, field := range model.Fields {
+		j := fieldIndex(parent.Model, field.Name)
+		if j < 0 || parent.Model.Fields[j].Type != field.Type {
+			continue
+		}
+		if e := copyFieldValue(note, i, parent, j); e != nil {
+			return nil, nil, errors.Wrap(e, field.Name)
+		}
+	}
+	base := noteState(parent)
+	if _, err = r.saveNewNote(ctx, udb, bdb, bundle, model, note, deckID); err != nil {
+		return nil, nil, err
+	}
+	cards, err := moveSchedules(ctx, udb, parentBundleID, parent.ID, bundleID, note.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	link := &noteLink{
+		ID:           noteLinkPrefix + note.ID,
+		Type:         "notelink",
+		Parent:       parent.ID,
+		ParentBundle: parentBundleID,
+		ParentRev:    parent.Rev,
+		Base:         base,
+	}
+	if _, e := bdb.Put(ctx, link.ID, link); e != nil {
+		return nil, nil, errors.Wrap(e, "failed to save note parent")
+	}
+	return note, cards, nil
+}
+
+// moveSchedules copies the schedule of each of the parent note's cards to the
+// clone's card from the same template, and suspends the parent's cards. The
+// clone's cards are returned.
+func moveSchedules(ctx context.Context, udb kivikDB, parentBundleID, parentID, bundleID, noteID string) ([]*fb.Card, error) {
+	parentCards, err := noteCards(ctx, udb, parentBundleID, parentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch parent cards")
+	}
+	cards, err := noteCards(ctx, udb, bundleID, noteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch cards")
+	}
+	if len(parentCards) == 0 {
+		return cards, nil
+	}
+	byTemplate := make(map[uint32]*fb.Card, len(cards))
+	for _, card := range cards {
+		byTemplate[card.TemplateID()] = card
+	}
+	updates := make([]*fb.Card, 0, len(cards)+len(parentCards))
+	for _, parent := range parentCards {
+		if card, ok := byTemplate[parent.TemplateID()]; ok {
+			card.LastReview = parent.LastReview
+			card.Due = parent.Due
+			card.Interval = parent.Interval
+			card.EaseFactor = parent.EaseFactor
+			card.ReviewCount = parent.ReviewCount
+			card.Modified = now().UTC()
+			updates = append(updates, card)
+		}
+		if !parent.Suspended {
+			parent.Suspended = true
+			parent.Modified = now().UTC()
+			updates = append(updates, parent)
+		}
+	}
+	if e := updateDocs(ctx, udb, updates); e != nil {
+		return nil, errors.Wrap(e, "failed to move card schedules")
+	}
+	return noteCards(ctx, udb, bundleID, noteID)
+}
+
+// fetchNoteModel fetches the requested note from db, including its
+// attachments, and sets its model.
+func fetchNoteModel(ctx context.Context, db getter, noteID string) (*fb.Note, error) {
+	note := &fb.Note{}
+	if err := getDocAttachments(ctx, db, noteID, note); err != nil {
+		return nil, wrapStatus(err, "note")
+	}
+	model, err := fetchModel(ctx, db, fmt.Sprintf("%s/%d", note.ThemeID, note.ModelID))
+	if err != nil {
+		return nil, err
+	}
+	if e := note.SetModel(model); e != nil {
+		return nil, errors.Wrap(e, "invalid note")
+	}
+	return note, nil
+}
+
+// copyFieldValue replaces the value of dst's field i, including its files,
+// with the value of src's field j. The fields must be of the same type.
+func copyFieldValue(dst *fb.Note, i int, src *fb.Note, j int) error {
+	if old := dst.FieldValues[i]; old != nil {
+		for _, name := range fieldFiles(old) {
+			dst.Attachments.RemoveFile(name)
+		}
+	}
+	dst.FieldValues[i] = nil
+	fv := dst.GetFieldValue(i)
+	from := src.FieldValues[j]
+	if from == nil {
+		return nil
+	}
+	fv.Text = from.Text
+	for _, name := range fieldFiles(from) {
+		att, ok := src.Attachments.GetFile(name)
+		if !ok {
+			continue
+		}
+		if e := fv.AddFile(name, att.ContentType, att.Content); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// noteState maps the names of the note's fields to digests of their values.
+// The note's model must be set.
+func noteState(note *fb.Note) map[string]string {
+	state := make(map[string]string, len(note.FieldValues))
+	for i, field := range note.Model.Fields {
+		h := sha256.New()
+		fmt.Fprintf(h, "%d\n", field.Type)
+		if fv := note.FieldValues[i]; fv != nil {
+			fmt.Fprintf(h, "%q\n", fv.Text)
+			for _, name := range fieldFiles(fv) {
+				if att, ok := note.Attachments.GetFile(name); ok {
+					fmt.Fprintf(h, "%q %s\n", name, fileDigest(att))
+				}
+			}
+		}
+		state[field.Name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return state
+}
+
+// NoteConflicts returns the unresolved conflicts from merging changes to the
+// parent of a cloned note.
+func (r *Repo) NoteConflicts(ctx context.Context, noteID string) ([]*NoteConflict, error) {
+	defer profile("NoteConflicts")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, bdb, _, err := r.noteDB(ctx, udb, noteID)
+	if err != nil {
+		return nil, err
+	}
+	link, err := getNoteLink(ctx, bdb, noteID)
+	if err != nil {
+		return nil, err
+	}
+	if link.Conflicts == nil {
+		return []*NoteConflict{}, nil
+	}
+	return link.Conflicts, nil
+}
+
+func getNoteLink(ctx context.Context, db getter, noteID string) (*noteLink, error) {
+	link := &noteLink{}
+	if err := getDoc(ctx, db, noteLinkPrefix+noteID, link); err != nil {
+		if kivik.StatusCode(err) == kivik.StatusNotFound {
+			return nil, kerrors.Status(kivik.StatusNotFound, "note has no parent")
+		}
+		return nil, err
+	}
+	if link.Base == nil {
+		link.Base = make(map[string]string)
+	}
+	return link, nil
+}
+
+// MergeNote merges changes made to the parent of a cloned note, since it was
+// cloned or last merged, into the clone, and returns the list of unresolved
+// conflicts. Changes to fields whose value was also changed in the clone are
+// not merged, but reported as conflicts, to be resolved by
+// ResolveNoteConflict. Fields which either note's model no longer has are
+// ignored. Cards are regenerated as by UpdateNote.
+func (r *Repo) MergeNote(ctx context.Context, noteID string) ([]*NoteConflict, error) {
+	defer profile("MergeNote")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundle, bdb, _, err := r.findNote(ctx, udb, noteID)
+	if err != nil {
+		return nil, err
+	}
+	link, err := getNoteLink(ctx, bdb, noteID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.mergeNote(ctx, udb, bdb, bundle, link); err != nil {
+		return nil, err
+	}
+	if link.Conflicts == nil {
+		return []*NoteConflict{}, nil
+	}
+	return link.Conflicts, nil
+}
+
+// mergeNote merges the linked parent into its clone, if the parent has
+// changed, and returns true if any changes were made.
+func (r *Repo) mergeNote(ctx context.Context, udb, bdb kivikDB, bundle *fb.Bundle, link *noteLink) (bool, error) {
+	pdb, err := r.newDB(ctx, link.ParentBundle)
+	if err != nil {
+		return false, err
+	}
+	parent, err := fetchNoteModel(ctx, pdb, link.Parent)
+	if err != nil {
+		if kivik.StatusCode(err) == kivik.StatusNotFound {
+			// The parent is gone, so there is nothing more to merge.
+			return false, nil
+		}
+		return false, errors.Wrap(err, "parent")
+	}
+	if parent.Rev == link.ParentRev {
+		return false, nil
+	}
+	note, err := fetchNoteModel(ctx, bdb, strings.TrimPrefix(link.ID, noteLinkPrefix))
+	if err != nil {
+		return false, err
+	}
+
+	base := link.Base
+	mine := noteState(note)
+	theirs := noteState(parent)
+	names := make([]string, 0, len(base)+len(theirs))
+	seen := make(map[string]bool)
+	for _, state := range []map[string]string{base, theirs} {
+		for name := range state {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	newBase := make(map[string]string, len(theirs))
+	conflicts := make([]*NoteConflict, 0)
+	var changed bool
+	for _, name := range names {
+		b, m, t := base[name], mine[name], theirs[name]
+		if t != "" {
+			newBase[name] = t
+		}
+		i, j := fieldIndex(note.Model, name), fieldIndex(parent.Model, name)
+		if t == b || m == t || i < 0 || j < 0 {
+			continue
+		}
+		if m != b || note.Model.Fields[i].Type != parent.Model.Fields[j].Type {
+			if b != "" {
+				newBase[name] = b
+			} else {
+				delete(newBase, name)
+			}
+			conflicts = append(conflicts, &NoteConflict{Field: name})
+			continue
+		}
+		if e := copyFieldValue(note, i, parent, j); e != nil {
+			return false, errors.Wrap(e, name)
+		}
+		changed = true
+	}
+
+	if changed {
+		if e := r.saveMergedNote(ctx, udb, bdb, bundle, note); e != nil {
+			return false, e
+		}
+	}
+	link.ParentRev = parent.Rev
+	link.Base = newBase
+	link.Conflicts = conflicts
+	rev, err := bdb.Put(ctx, link.ID, link)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to save note parent")
+	}
+	link.Rev = rev
+	return true, nil
+}
+
+// saveMergedNote regenerates the cards of a note changed by a merge, and saves
+// it. If the note no longer produces any cards, it keeps the ones it has.
+func (r *Repo) saveMergedNote(ctx context.Context, udb, bdb kivikDB, bundle *fb.Bundle, note *fb.Note) error {
+	if _, e := r.regenerateCards(ctx, udb, bdb, bundle, note.Model, note, true); e != nil {
+		if kivik.StatusCode(e) != kivik.StatusBadRequest {
+			return errors.Wrapf(e, "failed to regenerate cards for %s", note.ID)
+		}
+		// The note produces no cards, so was not saved by regenerateCards.
+		return saveNote(ctx, bdb, note)
+	}
+	return nil
+}
+
+// ResolveNoteConflict resolves a conflict reported by MergeNote, by keeping
+// the clone's value of the field, or, if useParent is true, by copying the
+// parent's current value.
+func (r *Repo) ResolveNoteConflict(ctx context.Context, noteID, field string, useParent bool) error {
+	defer profile("ResolveNoteConflict")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	bundle, bdb, _, err := r.findNote(ctx, udb, noteID)
+	if err != nil {
+		return err
+	}
+	link, err := getNoteLink(ctx, bdb, noteID)
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, conflict := range link.Conflicts {
+		if conflict.Field == field {
+			index = i
+		}
+	}
+	if index < 0 {
+		return kerrors.Status(kivik.StatusNotFound, fmt.Sprintf("no conflict for '%s'", field))
+	}
+	pdb, err := r.newDB(ctx, link.ParentBundle)
+	if err != nil {
+		return err
+	}
+	parent, err := fetchNoteModel(ctx, pdb, link.Parent)
+	if err != nil {
+		return errors.Wrap(err, "parent")
+	}
+	if useParent {
+		note, err := fetchNoteModel(ctx, bdb, noteID)
+		if err != nil {
+			return err
+		}
+		i, j := fieldIndex(note.Model, field), fieldIndex(parent.Model, field)
+		if i < 0 || j < 0 || note.Model.Fields[i].Type != parent.Model.Fields[j].Type {
+			return kerrors.Status(kivik.StatusBadRequest, fmt.Sprintf("field '%s' cannot be copied from the parent", field))
+		}
+		if e := copyFieldValue(note, i, parent, j); e != nil {
+			return errors.Wrap(e, field)
+		}
+		if e := r.saveMergedNote(ctx, udb, bdb, bundle, note); e != nil {
+			return e
+		}
+	}
+	state := noteState(parent)
+	if value, ok := state[field]; ok {
+		link.Base[field] = value
+	} else {
+		delete(link.Base, field)
+	}
+	link.Conflicts = append(link.Conflicts[:index], link.Conflicts[index+1:]...)
+	if _, e := bdb.Put(ctx, link.ID, link); e != nil {
+		return errors.Wrap(e, "failed to save note parent")
+	}
+	return nil
+}
+
+// deleteNoteLink deletes the link to the parent of a cloned note, if any.
+func deleteNoteLink(ctx context.Context, db getPutDeleter, noteID string) error {
+	link := &noteLink{}
+	if err := getDoc(ctx, db, noteLinkPrefix+noteID, link); err != nil {
+		if kivik.StatusCode(err) == kivik.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	if _, err := db.Delete(ctx, link.ID, link.Rev); err != nil {
+		return errors.Wrapf(err, "failed to delete %s", link.ID)
+	}
+	return nil
+}
+
+// mergeNotes merges changes to the parents of all cloned notes in the user's
+// own bundles, and returns true if any changes were made.
+func (r *Repo) mergeNotes(ctx context.Context) (bool, error) {
+	defer profile("mergeNotes")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return false, err
+	}
+	var merged bool
+	err = r.forOwnedBundles(ctx, udb, func(bundle *fb.Bundle, bdb kivikDB) error {
+		links, err := noteLinks(ctx, bdb)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			m, err := r.mergeNote(ctx, udb, bdb, bundle, link)
+			if err != nil {
+				return errors.Wrap(err, strings.TrimPrefix(link.ID, noteLinkPrefix))
+			}
+			merged = merged || m
+		}
+		return nil
+	})
+	return merged, err
+}
+
+// noteLinks returns every note link document in bdb.
+func noteLinks(ctx context.Context, bdb allDocer) ([]*noteLink, error) {
+	rows, err := bdb.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    noteLinkPrefix,
+		"end_key":      noteLinkPrefix + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch note parents")
+	}
+	defer func() { _ = rows.Close() }()
+	links := make([]*noteLink, 0)
+	for rows.Next() {
+		link := &noteLink{}
+		if e := rows.ScanDoc(link); e != nil {
+			return nil, errors.Wrap(e, "scan note parent")
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
@@ -0,0 +1,247 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestCloneNote(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+
+	// The parent is in a copy of the test bundle, owned by someone else
+	const parentBundleID = "bundle-nrqwe"
+	const parentID = "note-cGFyZW50"
+	_ = client.CreateDB(ctx, parentBundleID)
+	pdb, _ := client.DB(ctx, parentBundleID)
+	parentBundle, err := fb.NewBundle(parentBundleID, "nrqwe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []kivikDB{udb, pdb} {
+		if _, e := db.Put(ctx, parentBundle.ID, parentBundle); e != nil {
+			t.Fatal(e)
+		}
+	}
+	theme := &fb.Theme{}
+	if e := getDocAttachments(ctx, bdb, testThemeID, theme); e != nil {
+		t.Fatal(e)
+	}
+	theme.Rev = ""
+	if _, e := pdb.Put(ctx, theme.ID, theme); e != nil {
+		t.Fatal(e)
+	}
+	model, err := fetchModel(ctx, pdb, testModelID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := newNote(parentID, model, map[string]string{"Front": "hola", "Back": "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := saveDoc(ctx, pdb, parent); e != nil {
+		t.Fatal(e)
+	}
+	// The parent's first card has been studied
+	parentCard, err := fb.NewCard(model.Theme.ID, model.ID, cardID(parentBundleID, parentID, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parentCard.Due = fb.Due(parseTime(t, "2017-01-10T00:00:00Z"))
+	parentCard.Interval = 4 * fb.Day
+	parentCard.EaseFactor = 2.6
+	parentCard.ReviewCount = 3
+	if e := saveDoc(ctx, udb, parentCard); e != nil {
+		t.Fatal(e)
+	}
+	updateParent := func(t *testing.T, fields map[string]string) {
+		t.Helper()
+		note, e := fetchNoteModel(ctx, pdb, parentID)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if e := setFieldValues(note.Model, note.FieldValues, fields); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := pdb.Put(ctx, note.ID, note); e != nil {
+			t.Fatal(e)
+		}
+	}
+	fieldText := func(t *testing.T, noteID string) []string {
+		t.Helper()
+		note := &fb.Note{}
+		if e := getDoc(ctx, bdb, noteID, note); e != nil {
+			t.Fatal(e)
+		}
+		text := make([]string, len(note.FieldValues))
+		for i, fv := range note.FieldValues {
+			text[i] = fv.Text
+		}
+		return text
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		_, _, err := repo.CloneNote(ctx, parentID, parentBundleID, "")
+		checkErr(t, "bundle not owned by current user", err)
+		_, _, err = repo.CloneNote(ctx, "note-Zm9v", testBundleID, "")
+		checkErr(t, "note not found", err)
+	})
+
+	note, cards, err := repo.CloneNote(ctx, parentID, testBundleID, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.ThemeID != "theme-AAAAAAAAAAA" {
+		t.Errorf("Unexpected theme: %s", note.ThemeID)
+	}
+	if len(cards) != 2 || cards[0].Deck != testDeckID {
+		t.Errorf("Unexpected cards: %v", cards)
+	}
+	if d := diff.Interface([]string{"hola", "hello", ""}, fieldText(t, note.ID)); d != nil {
+		t.Error(d)
+	}
+	if c := cards[0]; !c.Due.Equal(parentCard.Due) || c.Interval != parentCard.Interval || c.EaseFactor != parentCard.EaseFactor || c.ReviewCount != 3 {
+		t.Errorf("Schedule not moved: %v", c)
+	}
+	if c := cards[1]; c.ReviewCount != 0 || !c.Due.IsZero() {
+		t.Errorf("Unexpected schedule for an unstudied card: %v", c)
+	}
+	stored := &fb.Card{}
+	if e := getDoc(ctx, udb, parentCard.ID, stored); e != nil {
+		t.Fatal(e)
+	}
+	if !stored.Suspended {
+		t.Error("Parent card not suspended")
+	}
+
+	t.Run("parent unchanged", func(t *testing.T) {
+		conflicts, err := repo.MergeNote(ctx, note.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conflicts) != 0 {
+			t.Errorf("Unexpected conflicts: %v", conflicts)
+		}
+	})
+
+	t.Run("no parent", func(t *testing.T) {
+		_, err := repo.NoteConflicts(ctx, parentID)
+		checkErr(t, "note has no parent", err)
+		if status := kivik.StatusCode(err); status != kivik.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+
+	if _, _, e := repo.UpdateNote(ctx, note.ID, map[string]string{"Back": "hi"}); e != nil {
+		t.Fatal(e)
+	}
+	updateParent(t, map[string]string{"Front": "¡hola!", "Back": "hello!"})
+
+	t.Run("merge", func(t *testing.T) {
+		conflicts, err := repo.MergeNote(ctx, note.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []*NoteConflict{{Field: "Back"}}
+		if d := diff.Interface(expected, conflicts); d != nil {
+			t.Error(d)
+		}
+		if d := diff.Interface([]string{"¡hola!", "hi", ""}, fieldText(t, note.ID)); d != nil {
+			t.Error(d)
+		}
+		conflicts, err = repo.NoteConflicts(ctx, note.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface(expected, conflicts); d != nil {
+			t.Error(d)
+		}
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		err := repo.ResolveNoteConflict(ctx, note.ID, "Front", false)
+		checkErr(t, "no conflict for 'Front'", err)
+		if e := repo.ResolveNoteConflict(ctx, note.ID, "Back", true); e != nil {
+			t.Fatal(e)
+		}
+		if d := diff.Interface([]string{"¡hola!", "hello!", ""}, fieldText(t, note.ID)); d != nil {
+			t.Error(d)
+		}
+		conflicts, err := repo.NoteConflicts(ctx, note.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conflicts) != 0 {
+			t.Errorf("Unexpected conflicts: %v", conflicts)
+		}
+	})
+
+	t.Run("mergeNotes", func(t *testing.T) {
+		updateParent(t, map[string]string{"Back": "hello"})
+		merged, err := repo.mergeNotes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !merged {
+			t.Error("Expected a merge")
+		}
+		if d := diff.Interface([]string{"¡hola!", "hello", ""}, fieldText(t, note.ID)); d != nil {
+			t.Error(d)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if e := repo.DeleteNotes(ctx, []string{note.ID}, false); e != nil {
+			t.Fatal(e)
+		}
+		_, err := getNoteLink(ctx, bdb, note.ID)
+		checkErr(t, "note has no parent", err)
+	})
+}
+
+func TestNoteLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		db       allDocer
+		expected []*noteLink
+		err      string
+	}{
+		{
+			name: "db error",
+			db:   &mockAllDocer{err: errors.New("db error")},
+			err:  "failed to fetch note parents: db error",
+		},
+		{
+			name: "iteration error",
+			db:   &mockAllDocer{rows: &mockRows{err: errors.New("db error")}},
+			err:  "db error",
+		},
+		{
+			name: "success",
+			db: &mockAllDocer{rows: &mockRows{rows: []string{
+				`{"_id":"notelink-note-Zm9v","type":"notelink","parent":"note-YmFy","parentBundle":"bundle-nrqwe"}`,
+			}}},
+			expected: []*noteLink{{ID: "notelink-note-Zm9v", Type: "notelink", Parent: "note-YmFy", ParentBundle: "bundle-nrqwe"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			links, err := noteLinks(context.Background(), test.db)
+			checkErr(t, test.err, err)
+			if err != nil {
+				return
+			}
+			if d := diff.Interface(test.expected, links); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
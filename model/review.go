@@ -0,0 +1,376 @@
+package model
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback"
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+const reviewPrefix = "review-"
+
+// pendingInterval is the time for which a card is buried when an answer to it
+// is submitted for review; in effect, until the answer is graded, which
+// reschedules the card, or discarded.
+const pendingInterval = 100 * 365 * fb.Day
+
+// PendingReview is an answer recorded for delayed review, as described in
+// notes.txt. It is graded later, by the user who answered, or by the owner of
+// the card's bundle, so it is stored in the bundle database, where those users
+// share it. Other users with access to the bundle may neither read nor grade
+// it.
+type PendingReview struct {
+	ID       string `json:"_id"`
+	Rev      string `json:"_rev,omitempty"`
+	Type     string `json:"type"`
+	BundleID string `json:"bundle"`
+	CardID   string `json:"card"`
+	// User is the user who answered.
+	User string `json:"user"`
+	// Answer is the submitted answer, such as typed text or a drawing, in a
+	// format determined by the card's model controller.
+	Answer interface{} `json:"answer"`
+	// Answered is the time the answer was submitted, and AnswerDelay the time
+	// taken to answer.
+	Answered    time.Time     `json:"answered"`
+	AnswerDelay time.Duration `json:"answerDelay"`
+	// Grader, Quality and Graded are set when the answer is graded.
+	Grader  string                  `json:"grader,omitempty"`
+	Quality flashback.AnswerQuality `json:"quality"`
+	Graded  time.Time               `json:"graded,omitempty"`
+	// Files are files submitted with the answer, such as drawings. When
+	// reviews are listed, their content is omitted; it is fetched by
+	// ReviewFile.
+	Files map[string]*fb.Attachment `json:"_attachments,omitempty"`
+}
+
+// SubmitForReview records an answer to the card, and any files submitted with
+// it, for delayed review. The card is buried while the answer is pending, so
+// that it is not studied again before it has been graded. The card itself is
+// not saved.
+func (c *Card) SubmitForReview(ctx context.Context, answer interface{}, answerDelay time.Duration, files map[string]*fb.Attachment) (*PendingReview, error) {
+	bdb, err := c.bundleDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := c.repo.CurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	review := &PendingReview{
+		ID:          newDocID(reviewPrefix),
+		Type:        "review",
+		BundleID:    c.BundleID(),
+		CardID:      c.ID,
+		User:        user,
+		Answer:      answer,
+		Answered:    now().UTC(),
+		AnswerDelay: answerDelay,
+		Files:       files,
+	}
+	rev, err := bdb.Put(ctx, review.ID, review)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store review")
+	}
+	review.Rev = rev
+	c.BuriedUntil = fb.Due(now().UTC()).Add(pendingInterval)
+	return review, nil
+}
+
+// ReviewFile returns a file submitted with an answer to the card, such as
+// a drawing to be shown alongside the correct answer. The answer must be the
+// current user's.
+func (c *Card) ReviewFile(ctx context.Context, reviewID, name string) (*fb.Attachment, error) {
+	bdb, err := c.bundleDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	review, err := c.review(ctx, bdb, reviewID, true)
+	if err != nil {
+		return nil, err
+	}
+	return reviewFile(review, name)
+}
+
+// DiscardReview deletes a recorded answer to the card, once the card has been
+// graded by other means, such as by the user on the answer face, and unburies
+// the card. The answer must be the current user's. The card itself is not
+// saved, so it should be discarded before the card is rescheduled.
+func (c *Card) DiscardReview(ctx context.Context, reviewID string) error {
+	bdb, err := c.bundleDB(ctx)
+	if err != nil {
+		return err
+	}
+	review, err := c.review(ctx, bdb, reviewID, false)
+	if err != nil {
+		return err
+	}
+	if _, e := bdb.Delete(ctx, review.ID, review.Rev); e != nil {
+		return errors.Wrapf(e, "failed to delete %s", review.ID)
+	}
+	c.BuriedUntil = fb.Due{}
+	return nil
+}
+
+// review fetches a recorded answer to the card by the current user, with any
+// files if attachments is true.
+func (c *Card) review(ctx context.Context, bdb getter, reviewID string, attachments bool) (*PendingReview, error) {
+	user, err := c.repo.CurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	review := &PendingReview{}
+	get := getDoc
+	if attachments {
+		get = getDocAttachments
+	}
+	if e := get(ctx, bdb, reviewID, review); e != nil {
+		return nil, wrapStatus(e, "review")
+	}
+	if review.User != user || review.CardID != c.ID {
+		return nil, kerrors.Status(kivik.StatusForbidden, "not your review")
+	}
+	return review, nil
+}
+
+// bundleDB returns the database of the card's bundle.
+func (c *Card) bundleDB(ctx context.Context) (kivikDB, error) {
+	if c.repo == nil {
+		return nil, errors.New("card has no repository")
+	}
+	return c.repo.newDB(ctx, c.BundleID())
+}
+
+// ReviewQueue returns the answers waiting to be graded in a bundle, or in all
+// of the user's bundles if bundleID is empty, oldest first. The queue includes
+// the user's own answers, and in bundles the user owns, those of other users.
+func (r *Repo) ReviewQueue(ctx context.Context, bundleID string) ([]*PendingReview, error) {
+	defer profile("ReviewQueue")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundleIDs := []string{bundleID}
+	if bundleID == "" {
+		if bundleIDs, err = getBundleIDs(ctx, udb); err != nil {
+			return nil, err
+		}
+	}
+	user, err := r.CurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	queue := make([]*PendingReview, 0)
+	for _, id := range bundleIDs {
+		bdb, bundle, err := r.reviewDB(ctx, udb, id)
+		if err != nil {
+			return nil, err
+		}
+		reviews, err := pendingReviews(ctx, bdb)
+		if err != nil {
+			return nil, err
+		}
+		for _, review := range reviews {
+			if review.Graded.IsZero() && mayGrade(user, bundle, review) {
+				queue = append(queue, review)
+			}
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		return queue[i].Answered.Before(queue[j].Answered)
+	})
+	return queue, nil
+}
+
+// GradeReview grades an answer in the review queue, which must be the current
+// user's, or in a bundle the current user owns. The grade is applied to
+// the card's schedule, as of the time of the answer, immediately if the
+// current user gave the answer, or otherwise when the user who did next syncs.
+func (r *Repo) GradeReview(ctx context.Context, bundleID, reviewID string, quality flashback.AnswerQuality) error {
+	defer profile("GradeReview")()
+	if quality < flashback.AnswerBlackout || quality > flashback.AnswerPerfect {
+		return kerrors.Statusf(kivik.StatusBadRequest, "invalid answer quality %d", quality)
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	user, err := r.CurrentUser()
+	if err != nil {
+		return err
+	}
+	bdb, bundle, err := r.reviewDB(ctx, udb, bundleID)
+	if err != nil {
+		return err
+	}
+	review := &PendingReview{}
+	// Any files are fetched too, to store them again along with the grade.
+	if e := getDocAttachments(ctx, bdb, reviewID, review); e != nil {
+		return wrapStatus(e, "review")
+	}
+	if !mayGrade(user, bundle, review) {
+		return kerrors.Status(kivik.StatusForbidden, "not permitted to grade review")
+	}
+	if !review.Graded.IsZero() {
+		return kerrors.Status(kivik.StatusConflict, "review already graded")
+	}
+	review.Grader = user
+	review.Quality = quality
+	review.Graded = now().UTC()
+	if review.User == user {
+		return applyReview(ctx, udb, bdb, review)
+	}
+	if _, e := bdb.Put(ctx, review.ID, review); e != nil {
+		return errors.Wrap(e, "failed to store grade")
+	}
+	return nil
+}
+
+// ReviewFile returns a file submitted with an answer in the review queue.
+func (r *Repo) ReviewFile(ctx context.Context, bundleID, reviewID, name string) (*fb.Attachment, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := r.CurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	bdb, bundle, err := r.reviewDB(ctx, udb, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	review := &PendingReview{}
+	if e := getDocAttachments(ctx, bdb, reviewID, review); e != nil {
+		return nil, wrapStatus(e, "review")
+	}
+	if !mayGrade(user, bundle, review) {
+		return nil, kerrors.Status(kivik.StatusForbidden, "not permitted to read review")
+	}
+	return reviewFile(review, name)
+}
+
+func reviewFile(review *PendingReview, name string) (*fb.Attachment, error) {
+	file, ok := review.Files[name]
+	if !ok {
+		return nil, kerrors.Statusf(kivik.StatusNotFound, "file '%s' not found", name)
+	}
+	return file, nil
+}
+
+// reviewDB returns the database of a bundle in the user's database, to which
+// the user therefore has access, and the bundle as stored in it, whose owner
+// is authoritative.
+func (r *Repo) reviewDB(ctx context.Context, udb getter, bundleID string) (kivikDB, *fb.Bundle, error) {
+	if err := getDoc(ctx, udb, bundleID, &fb.Bundle{}); err != nil {
+		return nil, nil, wrapStatus(err, "bundle")
+	}
+	bdb, err := r.newDB(ctx, bundleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle := &fb.Bundle{}
+	if e := getDoc(ctx, bdb, bundleID, bundle); e != nil {
+		return nil, nil, wrapStatus(e, "bundle")
+	}
+	return bdb, bundle, nil
+}
+
+// mayGrade returns true if the user may read and grade the review: their own
+// answers, and any in a bundle they own.
+func mayGrade(user string, bundle *fb.Bundle, review *PendingReview) bool {
+	return review.User == user || bundle.Owner == user
+}
+
+func pendingReviews(ctx context.Context, bdb allDocer) ([]*PendingReview, error) {
+	rows, err := bdb.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    reviewPrefix,
+		"end_key":      reviewPrefix + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch reviews")
+	}
+	defer func() { _ = rows.Close() }()
+	reviews := make([]*PendingReview, 0)
+	for rows.Next() {
+		review := &PendingReview{}
+		if e := rows.ScanDoc(review); e != nil {
+			return nil, errors.Wrap(e, "scan review")
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, rows.Err()
+}
+
+// applyReview schedules the card of a graded review, as of the time of the
+// answer, and deletes the review. The schedule is left alone if the card has
+// been reviewed since, or deleted.
+func applyReview(ctx context.Context, udb, bdb kivikDB, review *PendingReview) error {
+	card := &fb.Card{}
+	err := getDoc(ctx, udb, review.CardID, card)
+	switch {
+	case kivik.StatusCode(err) == kivik.StatusNotFound:
+	case err != nil:
+		return err
+	case card.LastReview.After(review.Answered):
+	default:
+		if e := ScheduleAt(&Card{Card: card}, review.Answered, review.AnswerDelay, review.Quality); e != nil {
+			return e
+		}
+		if e := saveDoc(ctx, udb, card); e != nil {
+			return errors.Wrap(e, "failed to save card")
+		}
+	}
+	if _, e := bdb.Delete(ctx, review.ID, review.Rev); e != nil {
+		return errors.Wrapf(e, "failed to delete %s", review.ID)
+	}
+	return nil
+}
+
+// applyReviews applies the grades of the user's answers which have been
+// graded by the owners of their bundles, and returns true if any were applied.
+func (r *Repo) applyReviews(ctx context.Context) (bool, error) {
+	defer profile("applyReviews")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return false, err
+	}
+	user, err := r.CurrentUser()
+	if err != nil {
+		return false, err
+	}
+	bundleIDs, err := getBundleIDs(ctx, udb)
+	if err != nil {
+		return false, err
+	}
+	var applied bool
+	for _, bundleID := range bundleIDs {
+		bdb, bundle, err := r.reviewDB(ctx, udb, bundleID)
+		if err != nil {
+			return false, err
+		}
+		reviews, err := pendingReviews(ctx, bdb)
+		if err != nil {
+			return false, err
+		}
+		for _, review := range reviews {
+			// Grades by anyone but the bundle owner are ignored, as they
+			// could only have been stored by bypassing GradeReview.
+			if review.User != user || review.Graded.IsZero() || !mayGrade(review.Grader, bundle, review) {
+				continue
+			}
+			if e := applyReview(ctx, udb, bdb, review); e != nil {
+				return false, e
+			}
+			applied = true
+		}
+	}
+	return applied, nil
+}
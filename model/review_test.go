@@ -0,0 +1,189 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flimzy/kivik"
+
+	"github.com/FlashbackSRS/flashback"
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestDelayedReview(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, _ := testDBs(t, client)
+	_, cards, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "hola", "Back": "hello"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getCard := func(t *testing.T, id string) *fb.Card {
+		t.Helper()
+		card := &fb.Card{}
+		if e := getDoc(ctx, udb, id, card); e != nil {
+			t.Fatal(e)
+		}
+		return card
+	}
+	submit := func(t *testing.T, card *fb.Card) *PendingReview {
+		t.Helper()
+		c := &Card{Card: card, repo: repo}
+		if _, e := c.SubmitForReview(ctx, map[string]string{"Back": "hi"}, 5*time.Second, nil); e != nil {
+			t.Fatal(e)
+		}
+		if !c.BuriedUntil.After(fb.Due(now()).Add(365 * fb.Day)) {
+			t.Errorf("Card not buried while pending: %s", c.BuriedUntil)
+		}
+		queue, e := repo.ReviewQueue(ctx, "")
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(queue) != 1 || queue[0].CardID != card.ID || queue[0].User != testUser {
+			t.Fatalf("Unexpected queue: %v", queue)
+		}
+		return queue[0]
+	}
+
+	// The bundle's owner, and another user with access to the bundle
+	const otherUser = "mfwgsy3f" // "alice"
+	bdb, _ := client.DB(ctx, testBundleID)
+	bundle := &fb.Bundle{}
+	if e := getDoc(ctx, bdb, testBundleID, bundle); e != nil {
+		t.Fatal(e)
+	}
+	bundle.Owner = otherUser
+	if _, e := bdb.Put(ctx, bundle.ID, bundle); e != nil {
+		t.Fatal(e)
+	}
+	bundle.Rev = ""
+	for _, user := range []string{otherUser, "stranger"} {
+		_ = client.CreateDB(ctx, "user-"+user)
+		db, _ := client.DB(ctx, "user-"+user)
+		if _, e := db.Put(ctx, bundle.ID, bundle); e != nil {
+			t.Fatal(e)
+		}
+	}
+	other := &Repo{user: otherUser, local: client}
+	stranger := &Repo{user: "stranger", local: client}
+
+	t.Run("no access", func(t *testing.T) {
+		_ = client.CreateDB(ctx, "user-nobody")
+		nobody := &Repo{user: "nobody", local: client}
+		_, err := nobody.ReviewQueue(ctx, testBundleID)
+		checkErr(t, "bundle: missing", err)
+	})
+
+	t.Run("peer review", func(t *testing.T) {
+		review := submit(t, cards[0])
+		queue, err := stranger.ReviewQueue(ctx, testBundleID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(queue) != 0 {
+			t.Errorf("Review visible to another user: %v", queue)
+		}
+		err = stranger.GradeReview(ctx, testBundleID, review.ID, flashback.AnswerCorrect)
+		checkErr(t, "not permitted to grade review", err)
+		if status := kivik.StatusCode(err); status != kivik.StatusForbidden {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		if queue, e := other.ReviewQueue(ctx, testBundleID); e != nil || len(queue) != 1 {
+			t.Fatalf("Unexpected owner queue: %v, %v", queue, e)
+		}
+		err = other.GradeReview(ctx, testBundleID, review.ID, flashback.AnswerPerfect+1)
+		checkErr(t, "invalid answer quality 6", err)
+		if status := kivik.StatusCode(err); status != kivik.StatusBadRequest {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		if e := other.GradeReview(ctx, testBundleID, review.ID, flashback.AnswerCorrect); e != nil {
+			t.Fatal(e)
+		}
+		err = other.GradeReview(ctx, testBundleID, review.ID, flashback.AnswerCorrect)
+		checkErr(t, "review already graded", err)
+		queue, err = repo.ReviewQueue(ctx, testBundleID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(queue) != 0 {
+			t.Errorf("Unexpected queue: %v", queue)
+		}
+		if card := getCard(t, cards[0].ID); card.ReviewCount != 0 {
+			t.Errorf("Card scheduled before sync")
+		}
+		applied, err := repo.applyReviews(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !applied {
+			t.Error("Expected the review to be applied")
+		}
+		card := getCard(t, cards[0].ID)
+		if card.ReviewCount != 1 || !card.LastReview.Equal(review.Answered) {
+			t.Errorf("Unexpected schedule: count %d, last review %s", card.ReviewCount, card.LastReview)
+		}
+		err = repo.GradeReview(ctx, testBundleID, review.ID, flashback.AnswerCorrect)
+		checkErr(t, "review: missing", err)
+	})
+
+	t.Run("self review", func(t *testing.T) {
+		review := submit(t, cards[1])
+		if e := repo.GradeReview(ctx, testBundleID, review.ID, flashback.AnswerBlackout); e != nil {
+			t.Fatal(e)
+		}
+		card := getCard(t, cards[1].ID)
+		if card.Due.IsZero() || card.Interval != flashback.LapseInterval {
+			t.Errorf("Card not scheduled: due %s, interval %s", card.Due, card.Interval)
+		}
+		applied, err := repo.applyReviews(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if applied {
+			t.Error("Review applied twice")
+		}
+	})
+}
+
+func TestReviewFile(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, _ := testDBs(t, client)
+	_, cards, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "水", "Back": "water"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card := &fb.Card{}
+	if e := getDoc(ctx, udb, cards[0].ID, card); e != nil {
+		t.Fatal(e)
+	}
+	c := &Card{Card: card, repo: repo}
+	drawing := &fb.Attachment{ContentType: "application/json", Content: []byte("[[[0,0],[1,1]]]")}
+	review, err := c.SubmitForReview(ctx, nil, time.Second, map[string]*fb.Attachment{"drawing.json": drawing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := repo.ReviewFile(ctx, testBundleID, review.ID, "drawing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.ContentType != drawing.ContentType || string(file.Content) != string(drawing.Content) {
+		t.Errorf("Unexpected file: %s %s", file.ContentType, file.Content)
+	}
+	_, err = c.ReviewFile(ctx, review.ID, "drawing.png")
+	checkErr(t, "file 'drawing.png' not found", err)
+	stranger := &Repo{user: "stranger", local: client}
+	_, err = (&Card{Card: card, repo: stranger}).ReviewFile(ctx, review.ID, "drawing.json")
+	checkErr(t, "not your review", err)
+	if e := c.DiscardReview(ctx, review.ID); e != nil {
+		t.Fatal(e)
+	}
+	if !c.BuriedUntil.IsZero() {
+		t.Errorf("Card still buried: %s", c.BuriedUntil)
+	}
+	_, err = c.ReviewFile(ctx, review.ID, "drawing.json")
+	checkErr(t, "review: missing", err)
+}
@@ -10,14 +10,20 @@ import (
 
 // Schedule implements the default scheduler.
 func Schedule(card *Card, answerDelay time.Duration, quality flashback.AnswerQuality) error {
-	ivl, ease := schedule(card, quality)
-	card.Due = fb.Due(now()).Add(ivl)
+	return ScheduleAt(card, now(), answerDelay, quality)
+}
+
+// ScheduleAt schedules the card as Schedule does, but as of the time the
+// answer was given, for answers which are graded later.
+func ScheduleAt(card *Card, answered time.Time, answerDelay time.Duration, quality flashback.AnswerQuality) error {
+	ivl, ease := schedule(card, answered, quality)
+	card.Due = fb.Due(answered).Add(ivl)
 	card.Interval = ivl
 	card.EaseFactor = ease
 	if quality <= flashback.AnswerIncorrectEasy {
 		card.ReviewCount = 0
 	} else {
-		card.LastReview = now().UTC()
+		card.LastReview = answered.UTC()
 		card.ReviewCount++
 	}
 
@@ -25,7 +31,7 @@ func Schedule(card *Card, answerDelay time.Duration, quality flashback.AnswerQua
 		// Bury cards with an interval >= 1d; they would make no progress if
 		// re-studied again today, due to fuzzing.
 		bury := buryInterval(card.Interval, card.Interval, false)
-		card.BuriedUntil = fb.Due(now().UTC()).Add(bury)
+		card.BuriedUntil = fb.Due(answered.UTC()).Add(bury)
 		// card.BuriedUntil = fb.Due(now().UTC()).Add(fb.Day)
 	} else {
 		// Bury cards with sub-day intervals until they are due. We only allow
@@ -36,7 +42,22 @@ func Schedule(card *Card, answerDelay time.Duration, quality flashback.AnswerQua
 	return nil
 }
 
-func schedule(card *Card, quality flashback.AnswerQuality) (interval fb.Interval, easeFactor float32) {
+// seenInterval is the interval after which a seen lesson would be due again;
+// in practice, never.
+const seenInterval = 100 * 365 * fb.Day
+
+// MarkSeen records that a lesson card has been seen. As lessons are not
+// reviewed, the card is retired from study by scheduling it far in the future,
+// so that suspension is left to the user.
+func MarkSeen(card *Card) {
+	seen := now().UTC()
+	card.LastReview = seen
+	card.ReviewCount++
+	card.Interval = seenInterval
+	card.Due = fb.Due(seen).Add(seenInterval)
+}
+
+func schedule(card *Card, answered time.Time, quality flashback.AnswerQuality) (interval fb.Interval, easeFactor float32) {
 	ease := card.EaseFactor
 	if ease == 0.0 {
 		ease = flashback.InitialEase
@@ -54,7 +75,7 @@ func schedule(card *Card, quality flashback.AnswerQuality) (interval fb.Interval
 	ease = adjustEase(ease, quality)
 	interval = card.Interval
 	lastReviewed := time.Time(card.Due.Add(-interval))
-	observedInterval := fb.Interval(float32(now().Sub(lastReviewed)) * ease)
+	observedInterval := fb.Interval(float32(answered.Sub(lastReviewed)) * ease)
 	if card.ReviewCount == 1 && observedInterval < flashback.SecondInterval {
 		return flashback.SecondInterval, ease
 	}
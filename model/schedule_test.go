@@ -225,7 +225,7 @@ func TestScheduling(t *testing.T) {
 			rcard := &Card{
 				Card: test.Card,
 			}
-			ivl, ease := schedule(rcard, test.Answer)
+			ivl, ease := schedule(rcard, now(), test.Answer)
 			due := fb.Due(test.Now).Add(ivl)
 			if !due.Equal(test.ExpectedDue) {
 				t.Errorf("Due:\n\tExpected: %s\n\t  Actual: %s\n", test.ExpectedDue, due)
@@ -352,3 +352,17 @@ func TestSchedule(t *testing.T) {
 		})
 	}
 }
+
+func TestMarkSeen(t *testing.T) {
+	card := &Card{Card: &fb.Card{}}
+	MarkSeen(card)
+	expected := &Card{Card: &fb.Card{
+		LastReview:  now().UTC(),
+		ReviewCount: 1,
+		Interval:    seenInterval,
+		Due:         fb.Due(now().UTC()).Add(seenInterval),
+	}}
+	if d := diff.Interface(expected, card); d != nil {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/model/search"
+)
+
+// SearchResult holds the IDs of the cards which match a search, and of their
+// notes.
+type SearchResult struct {
+	CardIDs []string `json:"cards"`
+	NoteIDs []string `json:"notes"`
+}
+
+// Search returns the cards which match the query, as described in the search
+// package, along with their notes. The IDs are sorted.
+func (r *Repo) Search(ctx context.Context, query string) (*SearchResult, error) {
+	defer profile("Search")()
+	q, err := search.Parse(query)
+	if err != nil {
+		return nil, kerrors.WrapStatus(kivik.StatusBadRequest, errors.Wrap(err, "invalid query"))
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	decks, err := deckNames(ctx, udb)
+	if err != nil {
+		return nil, err
+	}
+	cardIDs, err := findIDs(ctx, udb, q.CardSelector(decks, now()))
+	if err != nil {
+		return nil, errors.Wrap(err, "card search failed")
+	}
+	bundleIDs, err := getBundleIDs(ctx, udb)
+	if err != nil {
+		return nil, err
+	}
+	bdbs := make(map[string]kivikDB, len(bundleIDs))
+	for _, bundleID := range bundleIDs {
+		bdb, err := r.newDB(ctx, bundleID)
+		if err != nil {
+			return nil, err
+		}
+		bdbs[bundleID] = bdb
+	}
+
+	for _, filter := range q.TagFilters() {
+		taggedCards, err := findTagged(ctx, udb, filter.Selector)
+		if err != nil {
+			return nil, err
+		}
+		taggedNotes := make(map[string]map[string]bool, len(bdbs))
+		for bundleID, bdb := range bdbs {
+			if taggedNotes[bundleID], err = findTagged(ctx, bdb, filter.Selector); err != nil {
+				return nil, err
+			}
+		}
+		cardIDs = filterCardIDs(cardIDs, func(card *fb.Card) bool {
+			tagged := taggedCards[card.ID] || taggedNotes[card.BundleID()][card.NoteID()]
+			return tagged != filter.Negate
+		})
+	}
+
+	// Cards are only restricted by their notes if the query has note terms,
+	// in which case cards of notes in no bundle match nothing.
+	if q.HasNoteTerms() {
+		matchedNotes := make(map[string]map[string]bool, len(bdbs))
+		for bundleID, bdb := range bdbs {
+			fields, err := modelFields(ctx, bdb)
+			if err != nil {
+				return nil, err
+			}
+			ids, err := findIDs(ctx, bdb, q.NoteSelector(fields))
+			if err != nil {
+				return nil, errors.Wrap(err, "note search failed")
+			}
+			matchedNotes[bundleID] = make(map[string]bool, len(ids))
+			for _, id := range ids {
+				matchedNotes[bundleID][id] = true
+			}
+		}
+		cardIDs = filterCardIDs(cardIDs, func(card *fb.Card) bool {
+			return matchedNotes[card.BundleID()][card.NoteID()]
+		})
+	}
+
+	result := &SearchResult{CardIDs: cardIDs, NoteIDs: make([]string, 0)}
+	seen := make(map[string]bool)
+	for _, id := range cardIDs {
+		noteID := (&fb.Card{ID: id}).NoteID()
+		if !seen[noteID] {
+			seen[noteID] = true
+			result.NoteIDs = append(result.NoteIDs, noteID)
+		}
+	}
+	sort.Strings(result.NoteIDs)
+	return result, nil
+}
+
+func filterCardIDs(ids []string, keep func(*fb.Card) bool) []string {
+	kept := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if keep(&fb.Card{ID: id}) {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// findIDs returns the sorted IDs of all documents matching the selector.
+func findIDs(ctx context.Context, db finder, selector map[string]interface{}) ([]string, error) {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": selector,
+		"fields":   []string{"_id"},
+		// CouchDB returns only 25 results by default
+		"limit": math.MaxInt32,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var doc struct {
+			ID string `json:"_id"`
+		}
+		if e := rows.ScanDoc(&doc); e != nil {
+			return nil, errors.Wrap(e, "scan doc")
+		}
+		ids = append(ids, doc.ID)
+	}
+	sort.Strings(ids)
+	return ids, rows.Err()
+}
+
+// findTagged returns the set of IDs of the documents whose tags match the
+// selector.
+func findTagged(ctx context.Context, db finder, selector map[string]interface{}) (map[string]bool, error) {
+	ids, err := findIDs(ctx, db, selector)
+	if err != nil {
+		return nil, errors.Wrap(err, "tag search failed")
+	}
+	tagged := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		tagged[strings.TrimPrefix(id, tagsPrefix)] = true
+	}
+	return tagged, nil
+}
+
+// deckNames returns a map of deck IDs to names.
+func deckNames(ctx context.Context, db allDocer) (map[string]string, error) {
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    "deck-",
+		"end_key":      "deck-" + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch decks")
+	}
+	defer func() { _ = rows.Close() }()
+	names := make(map[string]string)
+	for rows.Next() {
+		var deck struct {
+			ID   string `json:"_id"`
+			Name string `json:"name"`
+		}
+		if e := rows.ScanDoc(&deck); e != nil {
+			return nil, errors.Wrap(e, "scan deck")
+		}
+		names[deck.ID] = deck.Name
+	}
+	return names, rows.Err()
+}
+
+// modelFields returns a function which locates fields, by name, in each of
+// the models in the bundle database. Field names are matched
+// case-insensitively.
+func modelFields(ctx context.Context, db allDocer) (func(string) []search.Field, error) {
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    "theme-",
+		"end_key":      "theme-" + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch themes")
+	}
+	defer func() { _ = rows.Close() }()
+	fields := make(map[string][]search.Field)
+	for rows.Next() {
+		theme := &fb.Theme{}
+		if e := rows.ScanDoc(theme); e != nil {
+			return nil, errors.Wrap(e, "scan theme")
+		}
+		for _, model := range theme.Models {
+			for i, field := range model.Fields {
+				name := strings.ToLower(field.Name)
+				fields[name] = append(fields[name], search.Field{Theme: theme.ID, Model: model.ID, Index: i})
+			}
+		}
+	}
+	if e := rows.Err(); e != nil {
+		return nil, e
+	}
+	return func(name string) []search.Field {
+		return fields[strings.ToLower(name)]
+	}, nil
+}
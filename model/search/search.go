@@ -0,0 +1,400 @@
+// Package search implements a query language for finding cards, modeled on
+// Anki's search syntax. Queries are parsed with Parse, then compiled into Mango
+// selectors, for use with Find against the user and bundle databases.
+//
+// A query consists of whitespace-separated terms, all of which must match. A
+// term may be negated by prefixing it with '-', and may be quoted, in whole or
+// in part, to include whitespace. The following terms are supported:
+//
+//	text          Notes with a field containing text
+//	field:text    Notes whose named field matches text
+//	deck:name     Cards in the named deck, or any of its sub-decks
+//	tag:name      Cards, or cards of notes, with the tag, or any of its
+//	              child tags
+//	is:new        Cards which have never been studied
+//	is:review     Cards which have been studied
+//	is:due        Cards which are due for study
+//	is:suspended  Suspended cards
+//	is:buried     Buried cards
+//	ivl:>30       Cards with an interval greater than 30 days. The
+//	              operators =, <, >, <= and >= are supported.
+//
+// In text, names and tags, '*' matches any sequence of characters, and may be
+// escaped as '\*'. All matching is case-insensitive.
+package search
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// Term is a single term of a query.
+type Term struct {
+	Negate bool
+	// Key is the term's qualifier, such as "deck" or "is", or a field name.
+	// It is empty for unqualified text.
+	Key string
+	// Op is the comparison operator of an "ivl" term.
+	Op    string
+	Value string
+}
+
+// Query is a parsed search query.
+type Query struct {
+	Terms []Term
+}
+
+var states = map[string]bool{
+	"new":       true,
+	"review":    true,
+	"due":       true,
+	"suspended": true,
+	"buried":    true,
+}
+
+var operators = map[string]string{
+	"=":  "$eq",
+	"<":  "$lt",
+	">":  "$gt",
+	"<=": "$lte",
+	">=": "$gte",
+}
+
+// Parse parses a search query. An empty query matches all cards.
+func Parse(query string) (*Query, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{Terms: make([]Term, 0, len(tokens))}
+	for _, token := range tokens {
+		term, err := parseTerm(token)
+		if err != nil {
+			return nil, err
+		}
+		q.Terms = append(q.Terms, term)
+	}
+	return q, nil
+}
+
+// tokenize splits the query on unquoted whitespace, and removes the quotes.
+// Escaped quotes are unescaped, but other escapes are preserved, for the
+// benefit of pattern matching.
+func tokenize(query string) ([]string, error) {
+	tokens := make([]string, 0)
+	var token []rune
+	var quoted, escaped, inToken bool
+	for _, r := range query {
+		switch {
+		case escaped:
+			if r != '"' {
+				token = append(token, '\\')
+			}
+			token = append(token, r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			inToken = true
+		case r == '"':
+			quoted = !quoted
+			inToken = true
+		case unicode.IsSpace(r) && !quoted:
+			if inToken {
+				tokens = append(tokens, string(token))
+			}
+			token = token[:0]
+			inToken = false
+		default:
+			token = append(token, r)
+			inToken = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if escaped {
+		token = append(token, '\\')
+	}
+	if inToken {
+		tokens = append(tokens, string(token))
+	}
+	return tokens, nil
+}
+
+func parseTerm(token string) (Term, error) {
+	var term Term
+	if len(token) > 1 && token[0] == '-' {
+		term.Negate = true
+		token = token[1:]
+	}
+	i := strings.Index(token, ":")
+	if i <= 0 {
+		term.Value = token
+		return term, nil
+	}
+	term.Key, term.Value = token[:i], token[i+1:]
+	if term.Value == "" {
+		return term, errors.Errorf("missing value for '%s'", term.Key)
+	}
+	switch strings.ToLower(term.Key) {
+	case "deck", "tag":
+		term.Key = strings.ToLower(term.Key)
+	case "is":
+		term.Key = "is"
+		term.Value = strings.ToLower(term.Value)
+		if !states[term.Value] {
+			return term, errors.Errorf("unknown state '%s'", term.Value)
+		}
+	case "ivl":
+		term.Key = "ivl"
+		term.Op = "="
+		for _, op := range []string{"<=", ">=", "<", ">", "="} {
+			if strings.HasPrefix(term.Value, op) {
+				term.Op = op
+				term.Value = strings.TrimPrefix(term.Value, op)
+				break
+			}
+		}
+		if _, err := strconv.ParseUint(term.Value, 10, 32); err != nil {
+			return term, errors.Errorf("invalid interval '%s'", term.Value)
+		}
+	}
+	return term, nil
+}
+
+// CardSelector compiles the card terms (deck, is and ivl) of the query into a
+// selector for cards in the user database. decks maps the IDs of the user's
+// decks to their names. Due and buried cards are evaluated as of now.
+func (q *Query) CardSelector(decks map[string]string, now time.Time) map[string]interface{} {
+	clauses := []map[string]interface{}{{"type": "card"}}
+	for _, term := range q.Terms {
+		var sel map[string]interface{}
+		switch term.Key {
+		case "deck":
+			sel = deckSelector(term.Value, decks)
+		case "is":
+			sel = stateSelector(term.Value, now)
+		case "ivl":
+			days, _ := strconv.Atoi(term.Value)
+			sel = map[string]interface{}{"interval": map[string]interface{}{operators[term.Op]: days}}
+		default:
+			continue
+		}
+		clauses = append(clauses, negate(term.Negate, sel))
+	}
+	return and(clauses)
+}
+
+// DeckMatches returns true if the deck name matches the pattern, or if it is
+// a sub-deck (as in parent::child) of a deck which does.
+func DeckMatches(pattern, name string) bool {
+	re := regexp.MustCompile("^" + globPattern(pattern) + "(::.*)?$")
+	return re.MatchString(name)
+}
+
+func deckSelector(pattern string, decks map[string]string) map[string]interface{} {
+	ids := make([]string, 0)
+	for id, name := range decks {
+		if DeckMatches(pattern, name) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return map[string]interface{}{"deck": map[string]interface{}{"$in": ids}}
+}
+
+func stateSelector(state string, now time.Time) map[string]interface{} {
+	ts := now.UTC().Format(fb.DueSeconds)
+	switch state {
+	case "new":
+		return map[string]interface{}{"due": map[string]interface{}{"$exists": false}}
+	case "review":
+		return map[string]interface{}{"due": map[string]interface{}{"$exists": true}}
+	case "suspended":
+		return map[string]interface{}{"suspended": true}
+	case "buried":
+		return map[string]interface{}{"buriedUntil": map[string]interface{}{"$gt": ts}}
+	}
+	// Due. Mango selectors never match missing fields, other than with
+	// $exists, so unset fields must be considered explicitly.
+	return and([]map[string]interface{}{
+		{"due": map[string]interface{}{"$lte": ts}},
+		{"$or": []interface{}{
+			map[string]interface{}{"suspended": map[string]interface{}{"$exists": false}},
+			map[string]interface{}{"suspended": false},
+		}},
+		{"$or": []interface{}{
+			map[string]interface{}{"buriedUntil": map[string]interface{}{"$exists": false}},
+			map[string]interface{}{"buriedUntil": map[string]interface{}{"$lte": ts}},
+		}},
+	})
+}
+
+// Field identifies a note field, by its position within a model.
+type Field struct {
+	Theme string
+	Model uint32
+	Index int
+}
+
+// HasNoteTerms returns true if the query has text terms, which are matched
+// against notes.
+func (q *Query) HasNoteTerms() bool {
+	for _, term := range q.Terms {
+		if !cardKeys[term.Key] {
+			return true
+		}
+	}
+	return false
+}
+
+// cardKeys are the keys of terms matched against cards, or their tags.
+var cardKeys = map[string]bool{
+	"deck": true,
+	"tag":  true,
+	"is":   true,
+	"ivl":  true,
+}
+
+// NoteSelector compiles the text terms of the query into a selector for notes
+// in a bundle database. fields returns the location of the named field, in
+// each of the bundle's models which has one. If the query has no text terms,
+// nil is returned.
+func (q *Query) NoteSelector(fields func(name string) []Field) map[string]interface{} {
+	if !q.HasNoteTerms() {
+		return nil
+	}
+	clauses := []map[string]interface{}{{"type": "note"}}
+	for _, term := range q.Terms {
+		if cardKeys[term.Key] {
+			continue
+		}
+		var sel map[string]interface{}
+		switch term.Key {
+		case "":
+			sel = map[string]interface{}{
+				"fieldValues": map[string]interface{}{
+					"$elemMatch": map[string]interface{}{
+						"text": map[string]interface{}{"$regex": globPattern(term.Value)},
+					},
+				},
+			}
+		default:
+			sel = fieldSelector(term.Value, fields(term.Key))
+		}
+		clauses = append(clauses, negate(term.Negate, sel))
+	}
+	return and(clauses)
+}
+
+func fieldSelector(pattern string, fields []Field) map[string]interface{} {
+	if len(fields) == 0 {
+		// Match nothing
+		return map[string]interface{}{"_id": map[string]interface{}{"$exists": false}}
+	}
+	alts := make([]interface{}, len(fields))
+	for i, field := range fields {
+		alts[i] = map[string]interface{}{
+			"theme": field.Theme,
+			"model": field.Model,
+			"fieldValues." + strconv.Itoa(field.Index) + ".text": map[string]interface{}{
+				"$regex": "^" + globPattern(pattern) + "$",
+			},
+		}
+	}
+	if len(alts) == 1 {
+		return alts[0].(map[string]interface{})
+	}
+	return map[string]interface{}{"$or": alts}
+}
+
+// TagFilter is a compiled tag term.
+type TagFilter struct {
+	Negate bool
+	// Selector matches the tags documents of tagged notes and cards, in both
+	// the user and bundle databases.
+	Selector map[string]interface{}
+}
+
+// TagFilters compiles the tag terms of the query.
+func (q *Query) TagFilters() []TagFilter {
+	filters := make([]TagFilter, 0)
+	for _, term := range q.Terms {
+		if term.Key != "tag" {
+			continue
+		}
+		filters = append(filters, TagFilter{
+			Negate: term.Negate,
+			Selector: map[string]interface{}{
+				"type": "tags",
+				"tags": map[string]interface{}{
+					"$elemMatch": map[string]interface{}{
+						"$regex": "^" + globPattern(term.Value) + "(::.*)?$",
+					},
+				},
+			},
+		})
+	}
+	return filters
+}
+
+func and(clauses []map[string]interface{}) map[string]interface{} {
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	list := make([]interface{}, len(clauses))
+	for i, clause := range clauses {
+		list[i] = clause
+	}
+	return map[string]interface{}{"$and": list}
+}
+
+func negate(not bool, sel map[string]interface{}) map[string]interface{} {
+	if !not {
+		return sel
+	}
+	return map[string]interface{}{"$not": sel}
+}
+
+// globPattern converts a pattern, with '*' wildcards, to an unanchored
+// case-insensitive regular expression. Case-insensitivity is achieved with
+// character classes, rather than flags, as flag syntax differs between the
+// CouchDB and PouchDB regular expression engines.
+func globPattern(glob string) string {
+	var buf bytes.Buffer
+	var escaped bool
+	for _, r := range glob {
+		switch {
+		case escaped:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			buf.WriteString(".*")
+		default:
+			lower, upper := unicode.ToLower(r), unicode.ToUpper(r)
+			if lower == upper {
+				buf.WriteString(regexp.QuoteMeta(string(r)))
+				continue
+			}
+			buf.WriteRune('[')
+			buf.WriteRune(lower)
+			buf.WriteRune(upper)
+			buf.WriteRune(']')
+		}
+	}
+	if escaped {
+		buf.WriteString(`\\`)
+	}
+	return buf.String()
+}
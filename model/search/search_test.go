@@ -0,0 +1,289 @@
+package search
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/testy"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected []Term
+		err      string
+	}{
+		{
+			name:     "empty",
+			query:    "  ",
+			expected: []Term{},
+		},
+		{
+			name:  "full example",
+			query: `deck:"Spanish::Verbs" tag:irregular is:due is:new is:suspended ivl:>30 "front:hola*"`,
+			expected: []Term{
+				{Key: "deck", Value: "Spanish::Verbs"},
+				{Key: "tag", Value: "irregular"},
+				{Key: "is", Value: "due"},
+				{Key: "is", Value: "new"},
+				{Key: "is", Value: "suspended"},
+				{Key: "ivl", Op: ">", Value: "30"},
+				{Key: "front", Value: "hola*"},
+			},
+		},
+		{
+			name:  "negation and text",
+			query: `-Deck:foo -"bar baz" qux IS:Buried`,
+			expected: []Term{
+				{Negate: true, Key: "deck", Value: "foo"},
+				{Negate: true, Value: "bar baz"},
+				{Value: "qux"},
+				{Key: "is", Value: "buried"},
+			},
+		},
+		{
+			name:  "escapes",
+			query: `a\*b \"c\"`,
+			expected: []Term{
+				{Value: `a\*b`},
+				{Value: `"c"`},
+			},
+		},
+		{
+			name:     "interval operators",
+			query:    "ivl:<=3 ivl:7",
+			expected: []Term{{Key: "ivl", Op: "<=", Value: "3"}, {Key: "ivl", Op: "=", Value: "7"}},
+		},
+		{
+			name:  "unterminated quote",
+			query: `deck:"foo`,
+			err:   "unterminated quote",
+		},
+		{
+			name:  "missing value",
+			query: "tag:",
+			err:   "missing value for 'tag'",
+		},
+		{
+			name:  "unknown state",
+			query: "is:foo",
+			err:   "unknown state 'foo'",
+		},
+		{
+			name:  "invalid interval",
+			query: "ivl:>x",
+			err:   "invalid interval 'x'",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := Parse(test.query)
+			testy.Error(t, test.err, err)
+			if d := diff.Interface(test.expected, q.Terms); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestDeckMatches(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		expected      bool
+	}{
+		{"Spanish", "Spanish", true},
+		{"spanish", "Spanish::Verbs", true},
+		{"Spanish", "Spanish Verbs", false},
+		{"Span*", "Spanish Verbs", true},
+		{"Spanish::Verbs", "Spanish", false},
+		{"a.b", "axb", false},
+	}
+	for _, test := range tests {
+		if result := DeckMatches(test.pattern, test.name); result != test.expected {
+			t.Errorf("%s / %s: expected %t", test.pattern, test.name, test.expected)
+		}
+	}
+}
+
+func TestGlobPattern(t *testing.T) {
+	tests := []struct {
+		glob     string
+		expected string
+		match    []string
+		nomatch  []string
+	}{
+		{
+			glob:     "Hola*",
+			expected: "[hH][oO][lL][aA].*",
+			match:    []string{"hola", "HOLA mundo"},
+			nomatch:  []string{"hol"},
+		},
+		{
+			glob:     `a\*.`,
+			expected: `[aA]\*\.`,
+			match:    []string{"a*."},
+			nomatch:  []string{"ab."},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.glob, func(t *testing.T) {
+			result := globPattern(test.glob)
+			if result != test.expected {
+				t.Errorf("Unexpected pattern: %s", result)
+			}
+			re := regexp.MustCompile("^" + result + "$")
+			for _, s := range test.match {
+				if !re.MatchString(s) {
+					t.Errorf("Expected match for %s", s)
+				}
+			}
+			for _, s := range test.nomatch {
+				if re.MatchString(s) {
+					t.Errorf("Unexpected match for %s", s)
+				}
+			}
+		})
+	}
+}
+
+func TestCardSelector(t *testing.T) {
+	decks := map[string]string{
+		"deck-1": "Spanish",
+		"deck-2": "Spanish::Verbs",
+		"deck-3": "French",
+	}
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "no card terms",
+			query:    "foo tag:bar",
+			expected: `{"type":"card"}`,
+		},
+		{
+			name:  "deck",
+			query: "deck:spanish",
+			expected: `{"$and":[
+				{"type":"card"},
+				{"deck":{"$in":["deck-1","deck-2"]}}
+			]}`,
+		},
+		{
+			name:  "states",
+			query: "is:new -is:suspended is:buried",
+			expected: `{"$and":[
+				{"type":"card"},
+				{"due":{"$exists":false}},
+				{"$not":{"suspended":true}},
+				{"buriedUntil":{"$gt":"2017-01-01 12:00:00"}}
+			]}`,
+		},
+		{
+			name:  "due",
+			query: "is:due",
+			expected: `{"$and":[
+				{"type":"card"},
+				{"$and":[
+					{"due":{"$lte":"2017-01-01 12:00:00"}},
+					{"$or":[{"suspended":{"$exists":false}},{"suspended":false}]},
+					{"$or":[{"buriedUntil":{"$exists":false}},{"buriedUntil":{"$lte":"2017-01-01 12:00:00"}}]}
+				]}
+			]}`,
+		},
+		{
+			name:  "interval",
+			query: "ivl:>=21",
+			expected: `{"$and":[
+				{"type":"card"},
+				{"interval":{"$gte":21}}
+			]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := Parse(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := diff.AsJSON(json.RawMessage(test.expected), q.CardSelector(decks, now)); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestNoteSelector(t *testing.T) {
+	fields := func(name string) []Field {
+		switch name {
+		case "front":
+			return []Field{{Theme: "theme-a", Model: 0, Index: 0}, {Theme: "theme-b", Model: 2, Index: 1}}
+		case "back":
+			return []Field{{Theme: "theme-a", Model: 0, Index: 1}}
+		}
+		return nil
+	}
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "no note terms",
+			query:    "deck:foo is:new",
+			expected: `null`,
+		},
+		{
+			name:  "text",
+			query: "-cat",
+			expected: `{"$and":[
+				{"type":"note"},
+				{"$not":{"fieldValues":{"$elemMatch":{"text":{"$regex":"[cC][aA][tT]"}}}}}
+			]}`,
+		},
+		{
+			name:  "fields",
+			query: "front:hola* back:x other:y",
+			expected: `{"$and":[
+				{"type":"note"},
+				{"$or":[
+					{"theme":"theme-a","model":0,"fieldValues.0.text":{"$regex":"^[hH][oO][lL][aA].*$"}},
+					{"theme":"theme-b","model":2,"fieldValues.1.text":{"$regex":"^[hH][oO][lL][aA].*$"}}
+				]},
+				{"theme":"theme-a","model":0,"fieldValues.1.text":{"$regex":"^[xX]$"}},
+				{"_id":{"$exists":false}}
+			]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := Parse(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := diff.AsJSON(json.RawMessage(test.expected), q.NoteSelector(fields)); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestTagFilters(t *testing.T) {
+	q, err := Parse("tag:foo -tag:b* bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[
+		{"Negate":false,"Selector":{"type":"tags","tags":{"$elemMatch":{"$regex":"^[fF][oO][oO](::.*)?$"}}}},
+		{"Negate":true,"Selector":{"type":"tags","tags":{"$elemMatch":{"$regex":"^[bB].*(::.*)?$"}}}}
+	]`
+	if d := diff.AsJSON(json.RawMessage(expected), q.TagFilters()); d != nil {
+		t.Error(d)
+	}
+}
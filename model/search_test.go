@@ -0,0 +1,145 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestSearch(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, _ := testDBs(t, client)
+	note1, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "hola mundo", "Back": "hello world"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idSource = bytes.NewReader(bytes.Repeat([]byte{1}, 8))
+	note2, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "adios"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		card10 = "card-mjxwe.Ym9iAAAAAAAAAAA.0"
+		card11 = "card-mjxwe.Ym9iAAAAAAAAAAA.1"
+		card20 = "card-mjxwe.Ym9iAQEBAQEBAQE.0"
+	)
+	update := func(id string, fn func(*fb.Card)) {
+		card := &fb.Card{}
+		if e := getDoc(ctx, udb, id, card); e != nil {
+			t.Fatal(e)
+		}
+		fn(card)
+		if _, e := udb.Put(ctx, card.ID, card); e != nil {
+			t.Fatal(e)
+		}
+	}
+	update(card10, func(card *fb.Card) {
+		card.Due = parseDue(t, "2016-12-30")
+		card.Interval = 30 * fb.Day
+	})
+	update(card11, func(card *fb.Card) {
+		card.Suspended = true
+	})
+	if e := repo.AddNoteTags(ctx, note1.ID, "irregular"); e != nil {
+		t.Fatal(e)
+	}
+	if e := repo.AddCardTags(ctx, card20, "irregular::past"); e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		query    string
+		expected *SearchResult
+		err      string
+		status   int
+	}{
+		{
+			query:    "",
+			expected: &SearchResult{CardIDs: []string{card10, card11, card20}, NoteIDs: []string{note1.ID, note2.ID}},
+		},
+		{
+			query:    "is:due",
+			expected: &SearchResult{CardIDs: []string{card10}, NoteIDs: []string{note1.ID}},
+		},
+		{
+			query:    "is:new -is:suspended",
+			expected: &SearchResult{CardIDs: []string{card20}, NoteIDs: []string{note2.ID}},
+		},
+		{
+			query:    "ivl:>21",
+			expected: &SearchResult{CardIDs: []string{card10}, NoteIDs: []string{note1.ID}},
+		},
+		{
+			query:    `deck:"test deck" tag:irregular`,
+			expected: &SearchResult{CardIDs: []string{card10, card11, card20}, NoteIDs: []string{note1.ID, note2.ID}},
+		},
+		{
+			query:    "-tag:irregular::past",
+			expected: &SearchResult{CardIDs: []string{card10, card11}, NoteIDs: []string{note1.ID}},
+		},
+		{
+			query:    "deck:other",
+			expected: &SearchResult{CardIDs: []string{}, NoteIDs: []string{}},
+		},
+		{
+			query:    `"front:HOLA*"`,
+			expected: &SearchResult{CardIDs: []string{card10, card11}, NoteIDs: []string{note1.ID}},
+		},
+		{
+			query:    "-world is:new",
+			expected: &SearchResult{CardIDs: []string{card20}, NoteIDs: []string{note2.ID}},
+		},
+		{
+			query:  "is:foo",
+			err:    "invalid query: unknown state 'foo'",
+			status: kivik.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			result, err := repo.Search(ctx, test.query)
+			checkErr(t, test.err, err)
+			if err != nil {
+				if status := kivik.StatusCode(err); status != test.status {
+					t.Errorf("Unexpected status: %d", status)
+				}
+				return
+			}
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+
+	t.Run("no bundles", func(t *testing.T) {
+		bundle := &fb.Bundle{}
+		if e := getDoc(ctx, udb, testBundleID, bundle); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := udb.Delete(ctx, bundle.ID, bundle.Rev); e != nil {
+			t.Fatal(e)
+		}
+		result, err := repo.Search(ctx, "is:new")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &SearchResult{CardIDs: []string{card11, card20}, NoteIDs: []string{note1.ID, note2.ID}}
+		if d := diff.Interface(expected, result); d != nil {
+			t.Error(d)
+		}
+		result, err = repo.Search(ctx, "hola")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface(&SearchResult{CardIDs: []string{}, NoteIDs: []string{}}, result); d != nil {
+			t.Error(d)
+		}
+	})
+}
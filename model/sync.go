@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"fmt"
+	"math"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -40,7 +41,19 @@ func (r *Repo) Sync(ctx context.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "schema upgrade failed")
 	}
-	if updated {
+	merged, err := r.mergeThemes(ctx)
+	if err != nil {
+		return errors.Wrap(err, "theme merge failed")
+	}
+	notesMerged, err := r.mergeNotes(ctx)
+	if err != nil {
+		return errors.Wrap(err, "note merge failed")
+	}
+	reviewsApplied, err := r.applyReviews(ctx)
+	if err != nil {
+		return errors.Wrap(err, "applying reviews failed")
+	}
+	if updated || merged || notesMerged || reviewsApplied {
 		fmt.Printf("Documents were updated\n")
 		if e := r.doSync(ctx, rdb, udbName, &docsWritten, &docsRead); e != nil {
 			return errors.Wrap(e, "resync failed")
@@ -106,19 +119,64 @@ func storeDecksInUserDB(ctx context.Context, r *Repo) (bool, error) {
 		return false, err
 	}
 
-	allDecks := make([]FlashbackDoc, 0)
+	bundleDecks := make([]*fb.Deck, 0)
 	for _, bundleID := range bundleIDs {
 		decks, err := getDecksFromBundle(ctx, r, bundleID)
 		if err != nil {
 			return false, err
 		}
-		for _, deck := range decks {
+		bundleDecks = append(bundleDecks, decks...)
+	}
+	if len(bundleDecks) == 0 {
+		return false, nil
+	}
+
+	modified, err := locallyModifiedDecks(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	allDecks := make([]FlashbackDoc, 0, len(bundleDecks))
+	for _, deck := range bundleDecks {
+		// Decks modified locally (such as by adding new cards) must not be
+		// overwritten; the rest are merged as for any other import.
+		if !modified[deck.ID] {
 			allDecks = append(allDecks, deck)
 		}
 	}
 	return bulkInsert(ctx, db, allDecks...)
 }
 
+// locallyModifiedDecks returns the set of IDs of the decks in db which were
+// created or modified since they were last imported, and so would be refused
+// by mergeDoc.
+func locallyModifiedDecks(ctx context.Context, db finder) (map[string]bool, error) {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": map[string]interface{}{"type": "deck"},
+		"fields":   []string{"_id", "modified", "imported"},
+		// CouchDB returns only 25 results by default
+		"limit": math.MaxInt32,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find decks")
+	}
+	defer func() { _ = rows.Close() }()
+	modified := make(map[string]bool)
+	for rows.Next() {
+		var deck struct {
+			ID       string    `json:"_id"`
+			Modified time.Time `json:"modified"`
+			Imported time.Time `json:"imported"`
+		}
+		if e := rows.ScanDoc(&deck); e != nil {
+			return nil, errors.Wrap(e, "scan doc")
+		}
+		if deck.Imported.IsZero() || deck.Modified.After(deck.Imported) {
+			modified[deck.ID] = true
+		}
+	}
+	return modified, rows.Err()
+}
+
 func getBundleIDs(ctx context.Context, db kivikDB) ([]string, error) {
 	rows, err := db.AllDocs(ctx, kivik.Options{
 		"startkey": "bundle-",
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"testing"
 	"time"
@@ -666,7 +667,10 @@ func TestStoreDecksInUserDB(t *testing.T) {
 								rows: []string{""},
 								keys: []string{"bundle-foo"},
 							},
-							kivikDB: &mockBulkDocer{err: errors.New("bulkdocs error")},
+							kivikDB: &mockBulkDocer{
+								err:     errors.New("bulkdocs error"),
+								kivikDB: &mockFinder{rows: &mockRows{}},
+							},
 						},
 						"bundle-foo": &mockAllDocer{
 							rows: &mockRows{
@@ -678,6 +682,29 @@ func TestStoreDecksInUserDB(t *testing.T) {
 			},
 			err: "bulkdocs error",
 		},
+		{
+			name: "find failure",
+			repo: &Repo{
+				user: "bob",
+				local: &mockClient{
+					dbs: map[string]kivikDB{
+						"user-bob": &mockAllDocer{
+							rows: &mockRows{
+								rows: []string{""},
+								keys: []string{"bundle-foo"},
+							},
+							kivikDB: &mockFinder{err: errors.New("find failed")},
+						},
+						"bundle-foo": &mockAllDocer{
+							rows: &mockRows{
+								rows: []string{`{"_id":"deck-foo", "_rev":"1-12345", "name":"Foo", "created":"2017-01-01T00:00:00Z", "modified":"2017-01-01T00:00:00Z", "cards":[]}`},
+							},
+						},
+					},
+				},
+			},
+			err: "failed to find decks: find failed",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -695,6 +722,51 @@ func TestStoreDecksInUserDB(t *testing.T) {
 	}
 }
 
+func TestStoreDecksInUserDBMerge(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient("user-bob", "bundle-foo")
+	udb, _ := client.DB(ctx, "user-bob")
+	bdb, _ := client.DB(ctx, "bundle-foo")
+	for db, docs := range map[kivikDB][]string{
+		udb: {
+			`{"_id":"bundle-foo", "type":"bundle", "owner":"bob", "created":"2017-01-01T00:00:00Z", "modified":"2017-01-01T00:00:00Z"}`,
+			`{"_id":"deck-bG9jYWw", "type":"deck", "name":"Local", "created":"2017-01-01T00:00:00Z", "modified":"2017-03-01T00:00:00Z", "imported":"2017-02-01T00:00:00Z", "cards":[]}`,
+			`{"_id":"deck-bWVyZ2Vk", "type":"deck", "name":"Old", "created":"2017-01-01T00:00:00Z", "modified":"2017-01-01T00:00:00Z", "imported":"2017-02-01T00:00:00Z", "cards":[]}`,
+		},
+		bdb: {
+			`{"_id":"deck-bG9jYWw", "type":"deck", "name":"Upstream", "created":"2017-01-01T00:00:00Z", "modified":"2017-04-01T00:00:00Z", "imported":"2017-02-01T00:00:00Z", "cards":[]}`,
+			`{"_id":"deck-bWVyZ2Vk", "type":"deck", "name":"New", "created":"2017-01-01T00:00:00Z", "modified":"2017-04-01T00:00:00Z", "imported":"2017-02-01T00:00:00Z", "cards":[]}`,
+		},
+	} {
+		for _, doc := range docs {
+			var v map[string]interface{}
+			if err := json.Unmarshal([]byte(doc), &v); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Put(ctx, v["_id"].(string), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	updated, err := storeDecksInUserDB(ctx, &Repo{user: "bob", local: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated {
+		t.Error("Expected an update")
+	}
+	// The locally modified deck is kept, the other takes the upstream changes.
+	for id, name := range map[string]string{"deck-bG9jYWw": "Local", "deck-bWVyZ2Vk": "New"} {
+		deck := &fb.Deck{}
+		if err := getDoc(ctx, udb, id, deck); err != nil {
+			t.Fatal(err)
+		}
+		if deck.Name != name {
+			t.Errorf("%s: unexpected name %q", id, deck.Name)
+		}
+	}
+}
+
 func TestGetBundleIDs(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,377 @@
+package model
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// tagsPrefix is the prefix of the documents which hold the tags of a note or
+// card, as tags-<note or card ID>. Tags are kept apart from the documents they
+// describe, as the fb types discard unrecognized fields, and would lose them
+// on the next update. A note's tags are stored in its bundle database, and a
+// card's tags in the user database.
+const tagsPrefix = "tags-"
+
+type tagsDoc struct {
+	ID   string   `json:"_id"`
+	Rev  string   `json:"_rev,omitempty"`
+	Type string   `json:"type"`
+	Tags []string `json:"tags"`
+}
+
+// TagCount is an entry in the tag index, as returned by Tags.
+type TagCount struct {
+	Name  string `json:"name"`
+	Notes int    `json:"notes"`
+	Cards int    `json:"cards"`
+}
+
+// NoteTags returns the tags of the requested note.
+func (r *Repo) NoteTags(ctx context.Context, noteID string) ([]string, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, bdb, _, err := r.noteDB(ctx, udb, noteID)
+	if err != nil {
+		return nil, err
+	}
+	return getTags(ctx, bdb, noteID)
+}
+
+// AddNoteTags adds the tags to the requested note, which must belong to a
+// bundle owned by the current user.
+func (r *Repo) AddNoteTags(ctx context.Context, noteID string, tags ...string) error {
+	return r.updateNoteTags(ctx, noteID, tags, addTags)
+}
+
+// RemoveNoteTags removes the tags from the requested note, which must belong
+// to a bundle owned by the current user.
+func (r *Repo) RemoveNoteTags(ctx context.Context, noteID string, tags ...string) error {
+	return r.updateNoteTags(ctx, noteID, tags, removeTags)
+}
+
+func (r *Repo) updateNoteTags(ctx context.Context, noteID string, tags []string, fn func(map[string]bool, []string)) error {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return err
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	_, bdb, _, err := r.findNote(ctx, udb, noteID)
+	if err != nil {
+		return err
+	}
+	return updateTags(ctx, bdb, noteID, func(set map[string]bool) { fn(set, tags) })
+}
+
+// CardTags returns the tags of the requested card.
+func (r *Repo) CardTags(ctx context.Context, cardID string) ([]string, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return getTags(ctx, udb, cardID)
+}
+
+// AddCardTags adds the tags to the requested card.
+func (r *Repo) AddCardTags(ctx context.Context, cardID string, tags ...string) error {
+	return r.updateCardTags(ctx, cardID, tags, addTags)
+}
+
+// RemoveCardTags removes the tags from the requested card.
+func (r *Repo) RemoveCardTags(ctx context.Context, cardID string, tags ...string) error {
+	return r.updateCardTags(ctx, cardID, tags, removeTags)
+}
+
+func (r *Repo) updateCardTags(ctx context.Context, cardID string, tags []string, fn func(map[string]bool, []string)) error {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return err
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	if e := getDoc(ctx, udb, cardID, &fb.Card{}); e != nil {
+		return wrapStatus(e, cardID)
+	}
+	return updateTags(ctx, udb, cardID, func(set map[string]bool) { fn(set, tags) })
+}
+
+// RenameTag renames a tag on every card, and on every note in a bundle owned
+// by the current user. If a note or card already has the new tag, the two
+// are merged.
+func (r *Repo) RenameTag(ctx context.Context, oldName, newName string) error {
+	from, err := normalizeTags([]string{oldName})
+	if err != nil {
+		return err
+	}
+	to, err := normalizeTags([]string{newName})
+	if err != nil {
+		return err
+	}
+	if from[0] == to[0] {
+		return nil
+	}
+	rename := func(set map[string]bool) {
+		if set[from[0]] {
+			delete(set, from[0])
+			set[to[0]] = true
+		}
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	dbs := []kivikDB{udb}
+	bundleIDs, err := getBundleIDs(ctx, udb)
+	if err != nil {
+		return err
+	}
+	for _, bundleID := range bundleIDs {
+		if _, e := r.ownedBundle(ctx, udb, bundleID); e != nil {
+			if kivik.StatusCode(e) == kivik.StatusForbidden {
+				continue
+			}
+			return e
+		}
+		bdb, e := r.newDB(ctx, bundleID)
+		if e != nil {
+			return e
+		}
+		dbs = append(dbs, bdb)
+	}
+	for _, db := range dbs {
+		docs, err := allTags(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if e := updateTags(ctx, db, strings.TrimPrefix(doc.ID, tagsPrefix), rename); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Tags returns an index of all tags in use, with the number of notes and
+// cards to which each is applied, sorted by name.
+func (r *Repo) Tags(ctx context.Context) ([]*TagCount, error) {
+	defer profile("Tags")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]*TagCount)
+	count := func(db allDocer, fn func(*TagCount)) error {
+		docs, err := allTags(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			for _, tag := range doc.Tags {
+				tc, ok := index[tag]
+				if !ok {
+					tc = &TagCount{Name: tag}
+					index[tag] = tc
+				}
+				fn(tc)
+			}
+		}
+		return nil
+	}
+	if e := count(udb, func(tc *TagCount) { tc.Cards++ }); e != nil {
+		return nil, e
+	}
+	bundleIDs, err := getBundleIDs(ctx, udb)
+	if err != nil {
+		return nil, err
+	}
+	for _, bundleID := range bundleIDs {
+		bdb, err := r.newDB(ctx, bundleID)
+		if err != nil {
+			return nil, err
+		}
+		if e := count(bdb, func(tc *TagCount) { tc.Notes++ }); e != nil {
+			return nil, e
+		}
+	}
+	tags := make([]*TagCount, 0, len(index))
+	for _, tc := range index {
+		tags = append(tags, tc)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// normalizeTags trims surrounding whitespace from each tag, removes
+// duplicates, and returns the result sorted. Empty tags, or tags containing
+// whitespace, are rejected.
+func normalizeTags(tags []string) ([]string, error) {
+	set := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || strings.IndexFunc(tag, unicode.IsSpace) >= 0 {
+			return nil, kerrors.Statusf(kivik.StatusBadRequest, "invalid tag '%s'", tag)
+		}
+		set[tag] = true
+	}
+	return tagList(set), nil
+}
+
+func tagList(set map[string]bool) []string {
+	tags := make([]string, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func addTags(set map[string]bool, tags []string) {
+	for _, tag := range tags {
+		set[tag] = true
+	}
+}
+
+func removeTags(set map[string]bool, tags []string) {
+	for _, tag := range tags {
+		delete(set, tag)
+	}
+}
+
+// getTags returns the tags of the document, which may be empty.
+func getTags(ctx context.Context, db getter, docID string) ([]string, error) {
+	doc := &tagsDoc{}
+	if err := getDoc(ctx, db, tagsPrefix+docID, doc); err != nil {
+		if kivik.StatusCode(err) == kivik.StatusNotFound {
+			return []string{}, nil
+		}
+		return nil, errors.Wrap(err, "tags")
+	}
+	return doc.Tags, nil
+}
+
+type getPutDeleter interface {
+	getter
+	putter
+	deleter
+}
+
+// updateTags applies fn to the set of tags of the document, and stores the
+// result if it has changed. The tags document is deleted once it holds no
+// tags.
+func updateTags(ctx context.Context, db getPutDeleter, docID string, fn func(map[string]bool)) error {
+	doc := &tagsDoc{}
+	if err := getDoc(ctx, db, tagsPrefix+docID, doc); err != nil && kivik.StatusCode(err) != kivik.StatusNotFound {
+		return errors.Wrap(err, "tags")
+	}
+	set := make(map[string]bool, len(doc.Tags))
+	addTags(set, doc.Tags)
+	fn(set)
+	tags := tagList(set)
+	if strings.Join(tags, " ") == strings.Join(doc.Tags, " ") {
+		return nil
+	}
+	if len(tags) == 0 {
+		if _, e := db.Delete(ctx, doc.ID, doc.Rev); e != nil {
+			return errors.Wrap(e, "failed to delete tags")
+		}
+		return nil
+	}
+	doc.ID = tagsPrefix + docID
+	doc.Type = "tags"
+	doc.Tags = tags
+	if _, e := db.Put(ctx, doc.ID, doc); e != nil {
+		return errors.Wrap(e, "failed to save tags")
+	}
+	return nil
+}
+
+// deleteTags deletes the tags of the document, if it has any.
+func deleteTags(ctx context.Context, db getPutDeleter, docID string) error {
+	return updateTags(ctx, db, docID, func(set map[string]bool) {
+		for tag := range set {
+			delete(set, tag)
+		}
+	})
+}
+
+// allTags returns every tags document in db.
+func allTags(ctx context.Context, db allDocer) ([]*tagsDoc, error) {
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    tagsPrefix,
+		"end_key":      tagsPrefix + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch tags")
+	}
+	defer func() { _ = rows.Close() }()
+	docs := make([]*tagsDoc, 0)
+	for rows.Next() {
+		doc := &tagsDoc{}
+		if e := rows.ScanDoc(doc); e != nil {
+			return nil, errors.Wrap(e, "scan tags")
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// tagsDocIDs returns the set of IDs of the tags documents in db.
+func tagsDocIDs(ctx context.Context, db allDocer) (map[string]bool, error) {
+	rows, err := db.AllDocs(ctx, kivik.Options{
+		"start_key": tagsPrefix,
+		"end_key":   tagsPrefix + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch tags")
+	}
+	defer func() { _ = rows.Close() }()
+	ids := make(map[string]bool)
+	for rows.Next() {
+		ids[rows.ID()] = true
+	}
+	return ids, rows.Err()
+}
+
+// cardTags returns the combined tags of the card and its note. A card not
+// loaded through a Repo has no tags.
+func (c *Card) cardTags(ctx context.Context) ([]string, error) {
+	if c.repo == nil {
+		return []string{}, nil
+	}
+	udb, err := c.repo.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bdb, err := c.repo.newDB(ctx, c.BundleID())
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	for _, src := range []struct {
+		db getter
+		id string
+	}{{bdb, c.NoteID()}, {udb, c.ID}} {
+		tags, err := getTags(ctx, src.db, src.id)
+		if err != nil {
+			return nil, err
+		}
+		addTags(set, tags)
+	}
+	return tagList(set), nil
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		expected []string
+		err      string
+	}{
+		{
+			name:     "none",
+			expected: []string{},
+		},
+		{
+			name:     "duplicates",
+			tags:     []string{"foo", " bar", "foo "},
+			expected: []string{"bar", "foo"},
+		},
+		{
+			name: "empty",
+			tags: []string{"foo", " "},
+			err:  "invalid tag ''",
+		},
+		{
+			name: "space",
+			tags: []string{"foo bar"},
+			err:  "invalid tag 'foo bar'",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := normalizeTags(test.tags)
+			checkErr(t, test.err, err)
+			if err != nil {
+				if status := kivik.StatusCode(err); status != kivik.StatusBadRequest {
+					t.Errorf("Unexpected status: %d", status)
+				}
+				return
+			}
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+	note, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "foo", "Back": "bar"}, testDeckID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card0, card1 := "card-mjxwe.Ym9iAAAAAAAAAAA.0", "card-mjxwe.Ym9iAAAAAAAAAAA.1"
+
+	checkTags := func(t *testing.T, get func() ([]string, error), expected []string) {
+		t.Helper()
+		tags, err := get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface(expected, tags); d != nil {
+			t.Error(d)
+		}
+	}
+	noteTags := func() ([]string, error) { return repo.NoteTags(ctx, note.ID) }
+	cardTags := func(id string) func() ([]string, error) {
+		return func() ([]string, error) { return repo.CardTags(ctx, id) }
+	}
+
+	t.Run("untagged", func(t *testing.T) {
+		checkTags(t, noteTags, []string{})
+		checkTags(t, cardTags(card0), []string{})
+	})
+	t.Run("unknown card", func(t *testing.T) {
+		err := repo.AddCardTags(ctx, "card-mjxwe.Zm9v.0", "foo")
+		checkErr(t, "card-mjxwe.Zm9v.0: missing", err)
+	})
+	t.Run("add", func(t *testing.T) {
+		if err := repo.AddNoteTags(ctx, note.ID, "verb", "french"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.AddCardTags(ctx, card0, "hard", "verb"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.AddCardTags(ctx, card1, "hard"); err != nil {
+			t.Fatal(err)
+		}
+		checkTags(t, noteTags, []string{"french", "verb"})
+		checkTags(t, cardTags(card0), []string{"hard", "verb"})
+	})
+	t.Run("index", func(t *testing.T) {
+		tags, err := repo.Tags(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []*TagCount{
+			{Name: "french", Notes: 1},
+			{Name: "hard", Cards: 2},
+			{Name: "verb", Notes: 1, Cards: 1},
+		}
+		if d := diff.Interface(expected, tags); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("template", func(t *testing.T) {
+		card := &Card{Card: &fb.Card{ID: card0}, repo: repo}
+		tags, err := card.cardTags(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface([]string{"french", "hard", "verb"}, tags); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("rename", func(t *testing.T) {
+		if err := repo.RenameTag(ctx, "verb", "french"); err != nil {
+			t.Fatal(err)
+		}
+		checkTags(t, noteTags, []string{"french"})
+		checkTags(t, cardTags(card0), []string{"french", "hard"})
+	})
+	t.Run("remove", func(t *testing.T) {
+		if err := repo.RemoveCardTags(ctx, card1, "hard"); err != nil {
+			t.Fatal(err)
+		}
+		checkTags(t, cardTags(card1), []string{})
+		// Removing the last tag removes the document
+		if _, err := udb.Get(ctx, tagsPrefix+card1); kivik.StatusCode(err) != kivik.StatusNotFound {
+			t.Errorf("Expected tags to be deleted, got %v", err)
+		}
+	})
+	t.Run("delete cascade", func(t *testing.T) {
+		if err := repo.DeleteNotes(ctx, []string{note.ID}, false); err != nil {
+			t.Fatal(err)
+		}
+		checkDeleted(t, bdb, tagsPrefix+note.ID)
+		checkDeleted(t, udb, tagsPrefix+card0)
+	})
+}
+
+func TestNoteTagsNotOwner(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, _ := testDBs(t, client)
+	note, _, err := repo.CreateNote(ctx, testBundleID, testModelID, map[string]string{"Front": "foo"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := &fb.Bundle{}
+	if e := getDoc(ctx, udb, testBundleID, bundle); e != nil {
+		t.Fatal(e)
+	}
+	bundle.Owner = "nrqwe"
+	if _, e := udb.Put(ctx, bundle.ID, bundle); e != nil {
+		t.Fatal(e)
+	}
+	err = repo.AddNoteTags(ctx, note.ID, "foo")
+	checkErr(t, "bundle not owned by current user", err)
+	// Reading is still permitted
+	if _, e := repo.NoteTags(ctx, note.ID); e != nil {
+		t.Error(e)
+	}
+}
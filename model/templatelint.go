@@ -0,0 +1,238 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+// TemplateError describes a problem found in one of a model's template files.
+type TemplateError struct {
+	File string `json:"file"`
+	// Line is the line number of the problem, or 0 if it does not apply to a
+	// specific line.
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+}
+
+// TemplateErrors is the list of problems found in a model's templates.
+type TemplateErrors []*TemplateError
+
+func (e TemplateErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// StatusCode returns kivik.StatusBadRequest, for the benefit of
+// kivik.StatusCode.
+func (e TemplateErrors) StatusCode() int {
+	return kivik.StatusBadRequest
+}
+
+// ValidateModel checks the templates of the model (in the format
+// theme-<theme>/<model>) for problems which would prevent its cards from
+// being displayed. If any are found, the returned error is a TemplateErrors.
+func (r *Repo) ValidateModel(ctx context.Context, bundleID, modelID string) error {
+	defer profile("ValidateModel")()
+	bdb, err := r.newDB(ctx, bundleID)
+	if err != nil {
+		return err
+	}
+	model, err := fetchModel(ctx, bdb, modelID)
+	if err != nil {
+		return err
+	}
+	return lintTemplates(ctx, &fbModel{Model: model, db: bdb})
+}
+
+// lintTemplates parses each of the model's HTML templates with the model
+// controller's placeholder FuncMap, and checks that they refer only to the
+// model's fields, and together provide the question and answer containers for
+// each of the model's templates. It returns a TemplateErrors, or nil.
+func lintTemplates(ctx context.Context, m *fbModel) error {
+	mc, err := GetModelController(m.Type)
+	if err != nil {
+		return err
+	}
+	var funcs template.FuncMap
+	if funcMapper, ok := mc.(FuncMapper); ok {
+		funcs = funcMapper.FuncMap(nil, 0)
+	}
+	files, err := m.extractTemplateFiles(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to read templates")
+	}
+	mainTemplate := fmt.Sprintf("$template.%d.html", m.ID)
+	var errs TemplateErrors
+	if _, ok := files[mainTemplate]; !ok {
+		errs = append(errs, &TemplateError{File: mainTemplate, Message: "main template not found"})
+	}
+
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		if strings.HasSuffix(filename, ".html") {
+			filenames = append(filenames, filename)
+		}
+	}
+	sort.Strings(filenames)
+	containers := make(map[string]bool)
+	for _, filename := range filenames {
+		content := files[filename]
+		errs = append(errs, lintFieldRefs(m.Model, filename, content, funcs)...)
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+		if err != nil {
+			errs = append(errs, &TemplateError{File: filename, Message: err.Error()})
+			continue
+		}
+		for i := range m.Templates {
+			for _, face := range []int{Question, Answer} {
+				sel := fmt.Sprintf("div.%s[data-id='%d']", faces[face], i)
+				if doc.Find(sel).Length() > 0 {
+					containers[sel] = true
+				}
+			}
+		}
+	}
+	for i := range m.Templates {
+		for _, face := range []int{Question, Answer} {
+			sel := fmt.Sprintf("div.%s[data-id='%d']", faces[face], i)
+			if !containers[sel] {
+				errs = append(errs, &TemplateError{File: mainTemplate, Message: fmt.Sprintf("no %s found for template %d", sel, i)})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// lintFieldRefs parses the template file, and checks that its references to
+// note fields refer to the model's fields.
+func lintFieldRefs(model *fb.Model, filename, content string, funcs template.FuncMap) TemplateErrors {
+	tmpl, err := template.New(filename).Funcs(funcs).Parse(content)
+	if err != nil {
+		return TemplateErrors{parseError(filename, err)}
+	}
+	var errs TemplateErrors
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		tree := t.Tree
+		walkFieldRefs(tree.Root, func(node parse.Node, name string) {
+			if fieldIndex(model, name) < 0 {
+				errs = append(errs, &TemplateError{
+					File:    filename,
+					Line:    nodeLine(tree, node),
+					Message: fmt.Sprintf("unknown field '%s'", name),
+				})
+			}
+		})
+	}
+	return errs
+}
+
+var parseErrorRE = regexp.MustCompile(`^template: (.*?):(\d+): (.*)$`)
+
+// parseError converts a template parse error to a TemplateError.
+func parseError(filename string, err error) *TemplateError {
+	m := parseErrorRE.FindStringSubmatch(err.Error())
+	if m == nil {
+		return &TemplateError{File: filename, Message: err.Error()}
+	}
+	line, _ := strconv.Atoi(m[2])
+	return &TemplateError{File: filename, Line: line, Message: m[3]}
+}
+
+// nodeLine returns the line number of node within the parsed file.
+func nodeLine(tree *parse.Tree, node parse.Node) int {
+	location, _ := tree.ErrorContext(node)
+	parts := strings.Split(location, ":")
+	if len(parts) < 3 {
+		return 0
+	}
+	line, _ := strconv.Atoi(parts[len(parts)-2])
+	return line
+}
+
+// walkFieldRefs calls fn for each reference to a note field, in the form
+// .Fields.X, $var.Fields.X or index .Fields "X", found in the tree.
+func walkFieldRefs(node parse.Node, fn func(parse.Node, string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkFieldRefs(child, fn)
+		}
+	case *parse.ActionNode:
+		walkFieldRefs(n.Pipe, fn)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.TemplateNode:
+		walkFieldRefs(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkFieldRefs(cmd, fn)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) == 3 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "index" {
+				if field, ok := n.Args[1].(*parse.FieldNode); ok && len(field.Ident) == 1 && field.Ident[0] == "Fields" {
+					if name, ok := n.Args[2].(*parse.StringNode); ok {
+						fn(n, name.Text)
+					}
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			walkFieldRefs(arg, fn)
+		}
+	case *parse.ChainNode:
+		walkFieldRefs(n.Node, fn)
+	case *parse.FieldNode:
+		if len(n.Ident) >= 2 && n.Ident[0] == "Fields" {
+			fn(n, n.Ident[1])
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) >= 3 && n.Ident[1] == "Fields" {
+			fn(n, n.Ident[2])
+		}
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(parse.Node, string)) {
+	walkFieldRefs(n.Pipe, fn)
+	walkFieldRefs(n.List, fn)
+	walkFieldRefs(n.ElseList, fn)
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestLintTemplates(t *testing.T) {
+	newModel := func(t *testing.T, files map[string]string) *fbModel {
+		theme, err := fb.NewTheme("theme-Zm9v")
+		if err != nil {
+			t.Fatal(err)
+		}
+		model, err := theme.NewModel("basic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		model.Templates = []string{"Card 1"}
+		_ = model.AddField(fb.TextField, "Front")
+		_ = model.AddField(fb.TextField, "Back")
+		for name, content := range files {
+			if e := model.AddFile(name, fb.TemplateContentType, []byte(content)); e != nil {
+				t.Fatal(e)
+			}
+		}
+		return &fbModel{Model: model}
+	}
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected TemplateErrors
+	}{
+		{
+			name: "valid",
+			files: map[string]string{
+				"$template.0.html": `<div class="question" data-id="0">{{ .Fields.Front }}</div>
+<div class="answer" data-id="0">{{ $g := . }}{{ $g.Fields.Back }}</div>`,
+			},
+		},
+		{
+			name:  "no main template",
+			files: map[string]string{"other.html": "<p></p>"},
+			expected: TemplateErrors{
+				{File: "$template.0.html", Message: "main template not found"},
+				{File: "$template.0.html", Message: "no div.question[data-id='0'] found for template 0"},
+				{File: "$template.0.html", Message: "no div.answer[data-id='0'] found for template 0"},
+			},
+		},
+		{
+			name: "unknown fields",
+			files: map[string]string{
+				"$template.0.html": `<div class="question" data-id="0">{{ .Fields.Front }}</div>
+<div class="answer" data-id="0">
+{{ if .Fields.Bcak }}{{ index .Fields "Side" }}{{ end }}
+</div>`,
+			},
+			expected: TemplateErrors{
+				{File: "$template.0.html", Line: 3, Message: "unknown field 'Bcak'"},
+				{File: "$template.0.html", Line: 3, Message: "unknown field 'Side'"},
+			},
+		},
+		{
+			name: "parse error",
+			files: map[string]string{
+				"$template.0.html": `<div class="question" data-id="0">{{ .Fields.Front }}</div>
+<div class="answer" data-id="0">{{ .Fields.Back }</div>`,
+			},
+			expected: TemplateErrors{
+				{File: "$template.0.html", Line: 2, Message: `unexpected "}" in operand`},
+			},
+		},
+		{
+			name: "containers in partial",
+			files: map[string]string{
+				"$template.0.html": `<div class="question" data-id="0">{{ .Fields.Front }}</div>{{ template "answer.html" . }}`,
+				"answer.html":      `<div class="answer" data-id="0">{{ .Fields.Back }}</div>`,
+			},
+		},
+		{
+			name: "missing container",
+			files: map[string]string{
+				"$template.0.html": `<div class="question" data-id="1">{{ .Fields.Front }}</div><div class="answer" data-id="0"></div>`,
+			},
+			expected: TemplateErrors{
+				{File: "$template.0.html", Message: "no div.question[data-id='0'] found for template 0"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := lintTemplates(context.Background(), newModel(t, test.files))
+			if test.expected == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				return
+			}
+			errs, ok := err.(TemplateErrors)
+			if !ok {
+				t.Fatalf("Unexpected error type %T: %v", err, err)
+			}
+			if d := diff.Interface(test.expected, errs); d != nil {
+				t.Error(d)
+			}
+			if status := kivik.StatusCode(err); status != kivik.StatusBadRequest {
+				t.Errorf("Unexpected status: %d", status)
+			}
+		})
+	}
+}
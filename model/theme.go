@@ -18,6 +18,43 @@ type fbModel struct {
 	db attachmentGetter
 }
 
+// cardTemplateIDs returns the template IDs of the cards which the model may
+// generate for the note: one for each cloze number used in the note's fields,
+// for cloze models, or otherwise one for each of the model's templates.
+func (m *fbModel) cardTemplateIDs(note *fb.Note) []uint32 {
+	clozer, ok := modelControllers[m.Type].(Clozer)
+	if !ok {
+		ids := make([]uint32, len(m.Templates))
+		for i := range ids {
+			ids[i] = uint32(i)
+		}
+		return ids
+	}
+	texts := make([]string, len(note.FieldValues))
+	for i, fv := range note.FieldValues {
+		if fv != nil {
+			texts[i] = fv.Text
+		}
+	}
+	ids := make([]uint32, 0)
+	for _, n := range clozer.ClozeNumbers(texts) {
+		if n > 0 {
+			ids = append(ids, uint32(n-1))
+		}
+	}
+	return ids
+}
+
+// templateDiv returns the data-id of the question and answer divs which
+// render the card with the given template ID. Those of cloze models are
+// rendered by the first template.
+func (m *fbModel) templateDiv(templateID uint32) uint32 {
+	if _, ok := modelControllers[m.Type].(Clozer); ok {
+		return 0
+	}
+	return templateID
+}
+
 func (m *fbModel) getCachedAttachment(filename string) (*fb.Attachment, error) {
 	att, ok := m.Files.GetFile(filename)
 	if ok {
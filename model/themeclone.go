@@ -0,0 +1,637 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+	"github.com/pkg/errors"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+const themeLinkPrefix = "themelink-"
+
+// themeLink records the parent of a cloned theme, as described in schema.md.
+// fb.Theme does not preserve unknown fields, so the link is stored in a
+// separate document, alongside the clone in its bundle database, with the ID
+// themelink-<clone ID>.
+type themeLink struct {
+	ID           string `json:"_id"`
+	Rev          string `json:"_rev,omitempty"`
+	Type         string `json:"type"`
+	Parent       string `json:"parent"`
+	ParentBundle string `json:"parentBundle"`
+	// ParentRev is the revision of the parent last merged into the clone.
+	ParentRev string `json:"parentRev"`
+	// Base is the state of the parent last merged into the clone, the common
+	// ancestor for the next merge. Items whose merge conflicted keep their
+	// earlier state, until the conflict is resolved.
+	Base      themeState       `json:"base"`
+	Conflicts []*ThemeConflict `json:"conflicts,omitempty"`
+}
+
+// ThemeConflict describes a change to a parent theme, which could not be
+// merged into its clone, because the clone has also been changed.
+type ThemeConflict struct {
+	// Path identifies the conflicting item, in one of the forms:
+	//
+	//	theme/file/<filename>
+	//	model/<id>
+	//	model/<id>/field/<name>
+	//	model/<id>/file/<filename>
+	Path string `json:"path"`
+}
+
+// themeState maps the paths of the mergeable items of a theme, as described
+// for ThemeConflict.Path, to a representation of their values: the digest of
+// a file, the type of a model, or the type of a field.
+type themeState map[string]string
+
+// newThemeState returns the current state of theme.
+func newThemeState(theme *fb.Theme) themeState {
+	state := make(themeState)
+	for _, name := range theme.Files.FileList() {
+		att, _ := theme.Files.GetFile(name)
+		state["theme/file/"+name] = fileDigest(att)
+	}
+	for _, model := range theme.Models {
+		prefix := fmt.Sprintf("model/%d", model.ID)
+		state[prefix] = model.Type
+		for _, field := range model.Fields {
+			state[prefix+"/field/"+field.Name] = strconv.Itoa(int(field.Type))
+		}
+		for _, name := range model.Files.FileList() {
+			att, _ := model.Files.GetFile(name)
+			state[prefix+"/file/"+name] = fileDigest(att)
+		}
+	}
+	return state
+}
+
+func fileDigest(att *fb.Attachment) string {
+	sum := sha256.Sum256(att.Content)
+	return att.ContentType + ";" + hex.EncodeToString(sum[:])
+}
+
+// parseThemePath splits a theme state path into its model ID (if any), item
+// kind ("file", "model" or "field") and name.
+func parseThemePath(path string) (modelID uint32, kind, name string, err error) {
+	parts := strings.SplitN(path, "/", 4)
+	switch {
+	case len(parts) == 3 && parts[0] == "theme" && parts[1] == "file":
+		return 0, "file", parts[2], nil
+	case parts[0] == "model" && len(parts) > 1:
+		id, e := strconv.ParseUint(parts[1], 10, 32)
+		if e != nil {
+			break
+		}
+		if len(parts) == 2 {
+			return uint32(id), "model", "", nil
+		}
+		if len(parts) == 4 && (parts[2] == "field" || parts[2] == "file") {
+			return uint32(id), parts[2], parts[3], nil
+		}
+	}
+	return 0, "", "", kerrors.Status(kivik.StatusBadRequest, fmt.Sprintf("invalid theme path '%s'", path))
+}
+
+// CloneTheme copies a theme, from any of the user's bundles, to a bundle
+// owned by the current user, and records the original as the copy's parent.
+// Later changes to the parent are merged into the copy by MergeTheme.
+func (r *Repo) CloneTheme(ctx context.Context, parentBundleID, parentID, bundleID string) (*fb.Theme, error) {
+	defer profile("CloneTheme")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := r.ownedBundle(ctx, udb, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	bdb, err := r.bundleDB(ctx, bundle)
+	if err != nil {
+		return nil, err
+	}
+	return r.cloneTheme(ctx, bdb, parentBundleID, parentID)
+}
+
+// cloneTheme copies the parent theme to bdb, and saves the link to its parent.
+func (r *Repo) cloneTheme(ctx context.Context, bdb kivikDB, parentBundleID, parentID string) (*fb.Theme, error) {
+	pdb, err := r.newDB(ctx, parentBundleID)
+	if err != nil {
+		return nil, err
+	}
+	parent := &fb.Theme{}
+	if e := getDocAttachments(ctx, pdb, parentID, parent); e != nil {
+		return nil, wrapStatus(e, "theme")
+	}
+	theme, err := fb.NewTheme(newDocID("theme-"))
+	if err != nil {
+		return nil, err
+	}
+	theme.Name = parent.Name
+	theme.Description = parent.Description
+	theme.ModelSequence = parent.ModelSequence
+	for _, name := range parent.Files.FileList() {
+		att, _ := parent.Files.GetFile(name)
+		theme.SetFile(name, att.ContentType, att.Content)
+	}
+	for _, model := range parent.Models {
+		copyModel(theme, model)
+	}
+	if _, e := bdb.Put(ctx, theme.ID, theme); e != nil {
+		return nil, errors.Wrap(e, "failed to save theme")
+	}
+	link := &themeLink{
+		ID:           themeLinkPrefix + theme.ID,
+		Type:         "themelink",
+		Parent:       parent.ID,
+		ParentBundle: parentBundleID,
+		ParentRev:    parent.Rev,
+		Base:         newThemeState(parent),
+	}
+	if _, e := bdb.Put(ctx, link.ID, link); e != nil {
+		return nil, errors.Wrap(e, "failed to save theme parent")
+	}
+	return theme, nil
+}
+
+// copyModel adds a copy of model, with the same ID, to theme.
+func copyModel(theme *fb.Theme, model *fb.Model) *fb.Model {
+	m := &fb.Model{
+		Theme:       theme,
+		ID:          model.ID,
+		Type:        model.Type,
+		Name:        model.Name,
+		Description: model.Description,
+		Templates:   append([]string{}, model.Templates...),
+		Fields:      make([]*fb.Field, len(model.Fields)),
+		Files:       theme.Attachments.NewView(),
+	}
+	for i, field := range model.Fields {
+		f := *field
+		m.Fields[i] = &f
+	}
+	for _, name := range model.Files.FileList() {
+		att, _ := model.Files.GetFile(name)
+		m.Files.SetFile(name, att.ContentType, att.Content)
+	}
+	theme.Models = append(theme.Models, m)
+	if theme.ModelSequence <= m.ID {
+		theme.ModelSequence = m.ID + 1
+	}
+	return m
+}
+
+// findThemeClone returns the ID of the clone of the parent theme in bdb, or
+// an empty string if there is none.
+func findThemeClone(ctx context.Context, bdb allDocer, parentBundleID, parentID string) (string, error) {
+	links, err := themeLinks(ctx, bdb)
+	if err != nil {
+		return "", err
+	}
+	for _, link := range links {
+		if link.ParentBundle == parentBundleID && link.Parent == parentID {
+			return strings.TrimPrefix(link.ID, themeLinkPrefix), nil
+		}
+	}
+	return "", nil
+}
+
+// themeLinks returns every theme link document in bdb.
+func themeLinks(ctx context.Context, bdb allDocer) ([]*themeLink, error) {
+	rows, err := bdb.AllDocs(ctx, kivik.Options{
+		"include_docs": true,
+		"start_key":    themeLinkPrefix,
+		"end_key":      themeLinkPrefix + kivik.EndKeySuffix,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch theme parents")
+	}
+	defer func() { _ = rows.Close() }()
+	links := make([]*themeLink, 0)
+	for rows.Next() {
+		link := &themeLink{}
+		if e := rows.ScanDoc(link); e != nil {
+			return nil, errors.Wrap(e, "scan theme parent")
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// ThemeConflicts returns the unresolved conflicts from merging changes to the
+// parent of a cloned theme.
+func (r *Repo) ThemeConflicts(ctx context.Context, bundleID, themeID string) ([]*ThemeConflict, error) {
+	defer profile("ThemeConflicts")()
+	bdb, err := r.newDB(ctx, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	link, err := getThemeLink(ctx, bdb, themeID)
+	if err != nil {
+		return nil, err
+	}
+	if link.Conflicts == nil {
+		return []*ThemeConflict{}, nil
+	}
+	return link.Conflicts, nil
+}
+
+func getThemeLink(ctx context.Context, db getter, themeID string) (*themeLink, error) {
+	link := &themeLink{}
+	if err := getDoc(ctx, db, themeLinkPrefix+themeID, link); err != nil {
+		if kivik.StatusCode(err) == kivik.StatusNotFound {
+			return nil, kerrors.Status(kivik.StatusNotFound, "theme has no parent")
+		}
+		return nil, err
+	}
+	if link.Base == nil {
+		link.Base = make(themeState)
+	}
+	return link, nil
+}
+
+// deleteThemeLink deletes the link to the parent of a cloned theme, if any.
+func deleteThemeLink(ctx context.Context, db getPutDeleter, themeID string) error {
+	link := &themeLink{}
+	if err := getDoc(ctx, db, themeLinkPrefix+themeID, link); err != nil {
+		if kivik.StatusCode(err) == kivik.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	if _, err := db.Delete(ctx, link.ID, link.Rev); err != nil {
+		return errors.Wrapf(err, "failed to delete %s", link.ID)
+	}
+	return nil
+}
+
+// MergeTheme merges changes made to the parent of a cloned theme, since it was
+// cloned or last merged, into the clone, and returns the list of unresolved
+// conflicts. Changes to items which were also changed in the clone are not
+// merged, but reported as conflicts, to be resolved by ResolveThemeConflict.
+// Models removed from the parent are kept in the clone, and a change to the
+// type of a field is always a conflict. Notes of models whose fields change
+// are updated to match, as by AddField and RemoveField.
+func (r *Repo) MergeTheme(ctx context.Context, bundleID, themeID string) ([]*ThemeConflict, error) {
+	defer profile("MergeTheme")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := r.ownedBundle(ctx, udb, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	bdb, err := r.bundleDB(ctx, bundle)
+	if err != nil {
+		return nil, err
+	}
+	link, err := getThemeLink(ctx, bdb, themeID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.mergeTheme(ctx, udb, bdb, bundle, link); err != nil {
+		return nil, err
+	}
+	if link.Conflicts == nil {
+		return []*ThemeConflict{}, nil
+	}
+	return link.Conflicts, nil
+}
+
+// mergeTheme merges the linked parent into its clone, if the parent has
+// changed, and returns true if any changes were made.
+func (r *Repo) mergeTheme(ctx context.Context, udb, bdb kivikDB, bundle *fb.Bundle, link *themeLink) (bool, error) {
+	pdb, err := r.newDB(ctx, link.ParentBundle)
+	if err != nil {
+		return false, err
+	}
+	parent := &fb.Theme{}
+	if e := getDocAttachments(ctx, pdb, link.Parent, parent); e != nil {
+		if kivik.StatusCode(e) == kivik.StatusNotFound {
+			// The parent is gone, so there is nothing more to merge.
+			return false, nil
+		}
+		return false, wrapStatus(e, "parent theme")
+	}
+	if parent.Rev == link.ParentRev {
+		return false, nil
+	}
+	themeID := strings.TrimPrefix(link.ID, themeLinkPrefix)
+	theme := &fb.Theme{}
+	if e := getDocAttachments(ctx, bdb, themeID, theme); e != nil {
+		if kivik.StatusCode(e) == kivik.StatusNotFound {
+			// The clone is gone, so it is no longer linked.
+			if _, e := bdb.Delete(ctx, link.ID, link.Rev); e != nil {
+				return false, errors.Wrapf(e, "failed to delete %s", link.ID)
+			}
+			return false, nil
+		}
+		return false, wrapStatus(e, "theme")
+	}
+
+	base, mine, theirs := link.Base, newThemeState(theme), newThemeState(parent)
+	paths := make([]string, 0, len(base)+len(theirs))
+	seen := make(map[string]bool)
+	for _, state := range []themeState{base, theirs} {
+		for path := range state {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	// Models sort before their fields and files.
+	sort.Strings(paths)
+
+	newBase := make(themeState, len(theirs))
+	conflicts := make([]*ThemeConflict, 0)
+	oldFields := make(map[uint32][]string)
+	for _, path := range paths {
+		b, m, t := base[path], mine[path], theirs[path]
+		newBase[path] = t
+		switch {
+		case t == b, m == t:
+			continue
+		case m != b, strings.Contains(path, "/field/") && b != "" && t != "":
+			newBase[path] = b
+			conflicts = append(conflicts, &ThemeConflict{Path: path})
+			continue
+		}
+		modelID, kind, _, err := parseThemePath(path)
+		if err != nil {
+			return false, err
+		}
+		if kind == "field" {
+			if model, e := themeModel(theme, modelID); e == nil {
+				if _, ok := oldFields[modelID]; !ok {
+					oldFields[modelID] = fieldNames(model)
+				}
+			}
+		}
+		if e := applyThemeChange(theme, parent, path); e != nil {
+			return false, errors.Wrap(e, path)
+		}
+	}
+	for path, value := range newBase {
+		if value == "" {
+			delete(newBase, path)
+		}
+	}
+
+	// Notes are saved before the theme, so that a failure to save them
+	// leaves the theme unchanged.
+	notes := make(map[*fb.Model][]*fb.Note, len(oldFields))
+	for modelID, names := range oldFields {
+		model, _ := themeModel(theme, modelID)
+		modelNotes, e := editModelNotes(ctx, bdb, model, remapFields(names, model))
+		if e != nil {
+			return false, e
+		}
+		notes[model] = modelNotes
+	}
+	theme.Modified = now().UTC()
+	rev, err := bdb.Put(ctx, theme.ID, theme)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to save theme")
+	}
+	theme.Rev = rev
+	for model, modelNotes := range notes {
+		if e := r.regenerateModelCards(ctx, udb, bdb, bundle, model, modelNotes); e != nil {
+			return false, e
+		}
+	}
+	link.ParentRev = parent.Rev
+	link.Base = newBase
+	link.Conflicts = conflicts
+	rev, err = bdb.Put(ctx, link.ID, link)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to save theme parent")
+	}
+	link.Rev = rev
+	return true, nil
+}
+
+// applyThemeChange copies the parent's version of the item identified by path
+// to theme. Items of models which theme doesn't have are ignored, as are
+// models removed from the parent.
+func applyThemeChange(theme, parent *fb.Theme, path string) error {
+	modelID, kind, name, err := parseThemePath(path)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(path, "theme/") {
+		return copyFile(theme.Files, parent.Files, name)
+	}
+	model, err := themeModel(theme, modelID)
+	parentModel, parentErr := themeModel(parent, modelID)
+	if kind == "model" {
+		if err != nil && parentErr == nil {
+			copyModel(theme, parentModel)
+		}
+		return nil
+	}
+	if err != nil || parentErr != nil {
+		return nil
+	}
+	if kind == "file" {
+		return copyFile(model.Files, parentModel.Files, name)
+	}
+	if i := fieldIndex(parentModel, name); i >= 0 {
+		// The field may have been copied along with a new model
+		if fieldIndex(model, name) < 0 {
+			f := *parentModel.Fields[i]
+			model.Fields = append(model.Fields, &f)
+		}
+		return nil
+	}
+	if i := fieldIndex(model, name); i >= 0 {
+		if len(model.Fields) == 1 {
+			return errors.New("cannot remove the only field")
+		}
+		model.Fields = append(model.Fields[:i], model.Fields[i+1:]...)
+	}
+	return nil
+}
+
+// copyFile copies the named file from src to dst, or removes it from dst, if
+// src doesn't have it.
+func copyFile(dst, src *fb.FileCollectionView, name string) error {
+	att, ok := src.GetFile(name)
+	if !ok {
+		if _, ok := dst.GetFile(name); !ok {
+			return nil
+		}
+		return dst.RemoveFile(name)
+	}
+	dst.SetFile(name, att.ContentType, att.Content)
+	return nil
+}
+
+func fieldNames(model *fb.Model) []string {
+	names := make([]string, len(model.Fields))
+	for i, field := range model.Fields {
+		names[i] = field.Name
+	}
+	return names
+}
+
+// remapFields returns a note edit which rearranges a note's field values,
+// from the fields named by oldNames, to the model's current fields. Values of
+// removed fields are discarded, along with their files, and added fields are
+// empty.
+func remapFields(oldNames []string, model *fb.Model) func(*fb.Note) error {
+	return func(note *fb.Note) error {
+		if len(note.FieldValues) != len(oldNames) {
+			return errors.New("field values don't match model")
+		}
+		old := make(map[string]*fb.FieldValue, len(oldNames))
+		for i, name := range oldNames {
+			old[name] = note.FieldValues[i]
+		}
+		values := make([]*fb.FieldValue, len(model.Fields))
+		for i, field := range model.Fields {
+			if fv, ok := old[field.Name]; ok {
+				values[i] = fv
+				delete(old, field.Name)
+				continue
+			}
+			values[i] = &fb.FieldValue{}
+		}
+		for _, fv := range old {
+			if fv == nil {
+				continue
+			}
+			for _, file := range fieldFiles(fv) {
+				note.Attachments.RemoveFile(file)
+			}
+		}
+		note.FieldValues = values
+		return nil
+	}
+}
+
+// ResolveThemeConflict resolves a conflict reported by MergeTheme, by
+// keeping the clone's version of the item, or, if useParent is true, by
+// copying the parent's current version. Only file conflicts may be resolved
+// with the parent's version; others must be resolved by editing the model.
+func (r *Repo) ResolveThemeConflict(ctx context.Context, bundleID, themeID, path string, useParent bool) error {
+	defer profile("ResolveThemeConflict")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	bundle, err := r.ownedBundle(ctx, udb, bundleID)
+	if err != nil {
+		return err
+	}
+	bdb, err := r.bundleDB(ctx, bundle)
+	if err != nil {
+		return err
+	}
+	link, err := getThemeLink(ctx, bdb, themeID)
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, conflict := range link.Conflicts {
+		if conflict.Path == path {
+			index = i
+		}
+	}
+	if index < 0 {
+		return kerrors.Status(kivik.StatusNotFound, fmt.Sprintf("no conflict for '%s'", path))
+	}
+	_, kind, _, err := parseThemePath(path)
+	if err != nil {
+		return err
+	}
+	if useParent && kind != "file" {
+		return kerrors.Status(kivik.StatusBadRequest, "only file conflicts may be resolved with the parent's version")
+	}
+	pdb, err := r.newDB(ctx, link.ParentBundle)
+	if err != nil {
+		return err
+	}
+	parent := &fb.Theme{}
+	if e := getDocAttachments(ctx, pdb, link.Parent, parent); e != nil {
+		return wrapStatus(e, "parent theme")
+	}
+	if useParent {
+		if _, _, _, e := r.editTheme(ctx, bundleID, themeID, func(theme *fb.Theme, _ kivikDB) error {
+			return applyThemeChange(theme, parent, path)
+		}); e != nil {
+			return e
+		}
+	}
+	if value, ok := newThemeState(parent)[path]; ok {
+		link.Base[path] = value
+	} else {
+		delete(link.Base, path)
+	}
+	link.Conflicts = append(link.Conflicts[:index], link.Conflicts[index+1:]...)
+	if _, e := bdb.Put(ctx, link.ID, link); e != nil {
+		return errors.Wrap(e, "failed to save theme parent")
+	}
+	return nil
+}
+
+// mergeThemes merges changes to the parents of all cloned themes in the
+// user's own bundles, and returns true if any changes were made.
+func (r *Repo) mergeThemes(ctx context.Context) (bool, error) {
+	defer profile("mergeThemes")()
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return false, err
+	}
+	var merged bool
+	err = r.forOwnedBundles(ctx, udb, func(bundle *fb.Bundle, bdb kivikDB) error {
+		links, err := themeLinks(ctx, bdb)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			m, err := r.mergeTheme(ctx, udb, bdb, bundle, link)
+			if err != nil {
+				return errors.Wrap(err, strings.TrimPrefix(link.ID, themeLinkPrefix))
+			}
+			merged = merged || m
+		}
+		return nil
+	})
+	return merged, err
+}
+
+// forOwnedBundles calls fn for each of the bundles owned by the current user.
+func (r *Repo) forOwnedBundles(ctx context.Context, udb kivikDB, fn func(*fb.Bundle, kivikDB) error) error {
+	bundleIDs, err := getBundleIDs(ctx, udb)
+	if err != nil {
+		return err
+	}
+	for _, bundleID := range bundleIDs {
+		bundle, err := r.ownedBundle(ctx, udb, bundleID)
+		if err != nil {
+			if kivik.StatusCode(err) == kivik.StatusForbidden {
+				continue
+			}
+			return err
+		}
+		bdb, err := r.bundleDB(ctx, bundle)
+		if err != nil {
+			return err
+		}
+		if e := fn(bundle, bdb); e != nil {
+			return e
+		}
+	}
+	return nil
+}
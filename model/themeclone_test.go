@@ -0,0 +1,250 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+
+	fb "github.com/FlashbackSRS/flashback-model"
+)
+
+func TestParseThemePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		modelID uint32
+		kind    string
+		name    string
+		err     string
+	}{
+		{path: "theme/file/$main.css", kind: "file", name: "$main.css"},
+		{path: "model/2", modelID: 2, kind: "model"},
+		{path: "model/0/field/Front", kind: "field", name: "Front"},
+		{path: "model/1/file/a/b.png", modelID: 1, kind: "file", name: "a/b.png"},
+		{path: "model/x", err: "invalid theme path 'model/x'"},
+		{path: "theme/foo", err: "invalid theme path 'theme/foo'"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			modelID, kind, name, err := parseThemePath(test.path)
+			checkErr(t, test.err, err)
+			if modelID != test.modelID || kind != test.kind || name != test.name {
+				t.Errorf("Unexpected result: %d, %s, %s", modelID, kind, name)
+			}
+		})
+	}
+}
+
+func TestCloneTheme(t *testing.T) {
+	defer setZeroIDSource()()
+	repo, client := newAuthoringRepo(t)
+	ctx := context.Background()
+	udb, bdb := testDBs(t, client)
+
+	// The parent is a copy of the test theme, in a bundle owned by someone else
+	const parentBundleID = "bundle-nrqwe"
+	_ = client.CreateDB(ctx, parentBundleID)
+	pdb, _ := client.DB(ctx, parentBundleID)
+	parentBundle, err := fb.NewBundle(parentBundleID, "nrqwe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []kivikDB{udb, pdb} {
+		if _, e := db.Put(ctx, parentBundle.ID, parentBundle); e != nil {
+			t.Fatal(e)
+		}
+	}
+	parent := &fb.Theme{}
+	if e := getDocAttachments(ctx, bdb, testThemeID, parent); e != nil {
+		t.Fatal(e)
+	}
+	parent.Rev = ""
+	if _, e := pdb.Put(ctx, parent.ID, parent); e != nil {
+		t.Fatal(e)
+	}
+	updateParent := func(t *testing.T, fn func(*fb.Theme)) {
+		t.Helper()
+		theme := &fb.Theme{}
+		if e := getDocAttachments(ctx, pdb, testThemeID, theme); e != nil {
+			t.Fatal(e)
+		}
+		fn(theme)
+		if _, e := pdb.Put(ctx, theme.ID, theme); e != nil {
+			t.Fatal(e)
+		}
+	}
+	const cloneID = "theme-AAAAAAAAAAA"
+	getClone := func(t *testing.T) *fb.Theme {
+		t.Helper()
+		theme := &fb.Theme{}
+		if e := getDocAttachments(ctx, bdb, cloneID, theme); e != nil {
+			t.Fatal(e)
+		}
+		return theme
+	}
+	fileContent := func(t *testing.T, files *fb.FileCollectionView, name string) string {
+		t.Helper()
+		att, ok := files.GetFile(name)
+		if !ok {
+			t.Fatalf("%s not found", name)
+		}
+		return string(att.Content)
+	}
+
+	t.Run("not owner", func(t *testing.T) {
+		_, err := repo.CloneTheme(ctx, testBundleID, testThemeID, parentBundleID)
+		checkErr(t, "bundle not owned by current user", err)
+	})
+
+	clone, err := repo.CloneTheme(ctx, parentBundleID, testThemeID, testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.ID != cloneID {
+		t.Fatalf("Unexpected clone ID: %s", clone.ID)
+	}
+	idSource = bytes.NewReader(bytes.Repeat([]byte{1}, 8))
+	note, _, err := repo.CreateNote(ctx, testBundleID, cloneID+"/0", map[string]string{"Front": "hola", "Back": "hello"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := repo.SetThemeCSS(ctx, testBundleID, cloneID, "body { color: blue; }"); e != nil {
+		t.Fatal(e)
+	}
+
+	t.Run("no parent", func(t *testing.T) {
+		_, err := repo.MergeTheme(ctx, testBundleID, testThemeID)
+		checkErr(t, "theme has no parent", err)
+		if status := kivik.StatusCode(err); status != kivik.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+
+	t.Run("parent unchanged", func(t *testing.T) {
+		rev := getClone(t).Rev
+		conflicts, err := repo.MergeTheme(ctx, testBundleID, cloneID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conflicts) != 0 {
+			t.Errorf("Unexpected conflicts: %v", conflicts)
+		}
+		if newRev := getClone(t).Rev; newRev != rev {
+			t.Errorf("Clone should not have changed")
+		}
+	})
+
+	const newTemplate = `
+<div class="question" data-id="0">{{ .Fields.Front }}</div>
+<div class="answer" data-id="0">{{ .Fields.Back }} ({{ .Fields.Notes }})</div>
+<div class="question" data-id="1">{{ .Fields.Back }}</div>
+<div class="answer" data-id="1">{{ .Fields.Front }}</div>
+`
+	updateParent(t, func(theme *fb.Theme) {
+		theme.SetFile(mainCSS, "text/css", []byte("body { color: red; }"))
+		model := theme.Models[0]
+		model.Files.SetFile("$template.0.html", fb.TemplateContentType, []byte(newTemplate))
+		_ = model.AddField(fb.TextField, "Notes")
+		model.Fields = append(model.Fields[:2], model.Fields[3:]...) // Remove Picture
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		conflicts, err := repo.MergeTheme(ctx, testBundleID, cloneID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []*ThemeConflict{{Path: "theme/file/$main.css"}}
+		if d := diff.Interface(expected, conflicts); d != nil {
+			t.Error(d)
+		}
+		theme := getClone(t)
+		if css := fileContent(t, theme.Files, mainCSS); css != "body { color: blue; }" {
+			t.Errorf("Local change overwritten: %s", css)
+		}
+		if tmpl := fileContent(t, theme.Models[0].Files, "$template.0.html"); tmpl != newTemplate {
+			t.Errorf("Template not merged: %s", tmpl)
+		}
+		if d := diff.Interface([]string{"Front", "Back", "Notes"}, fieldNames(theme.Models[0])); d != nil {
+			t.Error(d)
+		}
+		stored := &fb.Note{}
+		if e := getDoc(ctx, bdb, note.ID, stored); e != nil {
+			t.Fatal(e)
+		}
+		if len(stored.FieldValues) != 3 || stored.FieldValues[1].Text != "hello" {
+			t.Errorf("Unexpected field values: %v", stored.FieldValues)
+		}
+
+		conflicts, err = repo.ThemeConflicts(ctx, testBundleID, cloneID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface(expected, conflicts); d != nil {
+			t.Error(d)
+		}
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		err := repo.ResolveThemeConflict(ctx, testBundleID, cloneID, "model/0", false)
+		checkErr(t, "no conflict for 'model/0'", err)
+		if e := repo.ResolveThemeConflict(ctx, testBundleID, cloneID, "theme/file/$main.css", true); e != nil {
+			t.Fatal(e)
+		}
+		if css := fileContent(t, getClone(t).Files, mainCSS); css != "body { color: red; }" {
+			t.Errorf("Parent's version not used: %s", css)
+		}
+		conflicts, err := repo.ThemeConflicts(ctx, testBundleID, cloneID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conflicts) != 0 {
+			t.Errorf("Unexpected conflicts: %v", conflicts)
+		}
+	})
+
+	t.Run("mergeThemes", func(t *testing.T) {
+		updateParent(t, func(theme *fb.Theme) {
+			theme.SetFile(mainCSS, "text/css", []byte("body { color: green; }"))
+		})
+		merged, err := repo.mergeThemes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !merged {
+			t.Error("Expected a merge")
+		}
+		if css := fileContent(t, getClone(t).Files, mainCSS); css != "body { color: green; }" {
+			t.Errorf("Parent's change not merged: %s", css)
+		}
+	})
+
+	t.Run("clone deleted", func(t *testing.T) {
+		link, err := getThemeLink(ctx, bdb, cloneID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := repo.DeleteNotes(ctx, []string{note.ID}, true); e != nil {
+			t.Fatal(e)
+		}
+		checkDeleted(t, bdb, cloneID, themeLinkPrefix+cloneID)
+
+		// A link left behind by an earlier version is removed by the merge
+		link.Rev = ""
+		if _, e := bdb.Put(ctx, link.ID, link); e != nil {
+			t.Fatal(e)
+		}
+		updateParent(t, func(theme *fb.Theme) {
+			theme.SetFile(mainCSS, "text/css", []byte("body { color: black; }"))
+		})
+		merged, err := repo.mergeThemes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged {
+			t.Error("Unexpected merge")
+		}
+		checkDeleted(t, bdb, themeLinkPrefix+cloneID)
+	})
+}
@@ -2,6 +2,8 @@ package model
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"io"
 	"reflect"
@@ -123,3 +125,25 @@ func updateDocs(ctx context.Context, db bulkDocer, docs interface{}) error {
 	}
 	return nil
 }
+
+// idSource is the source of randomness for new document IDs. It may be
+// overridden in tests.
+var idSource io.Reader = rand.Reader
+
+// newDocID returns a new document ID consisting of prefix, followed by 64
+// random bits, encoded as URL-safe base64.
+func newDocID(prefix string) string {
+	id := make([]byte, 8)
+	if _, err := io.ReadFull(idSource, id); err != nil {
+		panic(err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(id)
+}
+
+// wrapStatus wraps err with msg, preserving its status code.
+func wrapStatus(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return errors.WrapStatus(kivik.StatusCode(err), errors.Wrap(err, msg))
+}
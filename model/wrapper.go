@@ -105,6 +105,20 @@ type clientNamer interface {
 	Name() string
 }
 
+type changer interface {
+	Changes(ctx context.Context, options ...kivik.Options) (kivikChanges, error)
+}
+
+// kivikChanges is the subset of *kivik.Changes that we use. ID() is omitted,
+// as it is broken in the vendored version of kivik; use ScanDoc instead.
+type kivikChanges interface {
+	Close() error
+	Next() bool
+	Err() error
+	Deleted() bool
+	ScanDoc(dest interface{}) error
+}
+
 type kivikDB interface {
 	getter
 	putter
@@ -116,6 +130,7 @@ type kivikDB interface {
 	clientNamer
 	attachmentGetter
 	allDocer
+	changer
 }
 
 type dbWrapper struct {
@@ -144,6 +159,10 @@ func (db *dbWrapper) AllDocs(ctx context.Context, options ...kivik.Options) (kiv
 	return db.DB.AllDocs(ctx, options...)
 }
 
+func (db *dbWrapper) Changes(ctx context.Context, options ...kivik.Options) (kivikChanges, error) {
+	return db.DB.Changes(ctx, options...)
+}
+
 func (db *dbWrapper) Client() kivikClient {
 	return wrapClient(db.DB.Client())
 }
@@ -19,7 +19,10 @@ import (
 	"github.com/FlashbackSRS/flashback/model"
 	"github.com/FlashbackSRS/flashback/util"
 
-	_ "github.com/FlashbackSRS/flashback/controllers/anki" // Anki model controllers
+	_ "github.com/FlashbackSRS/flashback/controllers/anki"        // Anki model controllers
+	_ "github.com/FlashbackSRS/flashback/controllers/drawing"     // Drawing model controller
+	_ "github.com/FlashbackSRS/flashback/controllers/lesson"      // Lesson model controller
+	_ "github.com/FlashbackSRS/flashback/controllers/multichoice" // Multiple-choice model controllers
 	"github.com/FlashbackSRS/flashback/webclient/handlers/auth"
 	"github.com/FlashbackSRS/flashback/webclient/handlers/general"
 	"github.com/FlashbackSRS/flashback/webclient/handlers/import"